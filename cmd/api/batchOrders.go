@@ -0,0 +1,309 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/maxwellkuo47/tradingEngine/internal/data"
+	"github.com/maxwellkuo47/tradingEngine/internal/orderbook"
+	"github.com/maxwellkuo47/tradingEngine/internal/validator"
+)
+
+// maxOrderBatch is how many orders one batch may place
+const maxOrderBatch = 50
+
+// outcomes of an order in a batch
+const (
+	batchOrderCreated  = "created"
+	batchOrderReplayed = "replayed"
+	batchOrderRejected = "rejected"
+)
+
+// batchOrderResult is what became of one order of a batch, Index is its position in the request
+type batchOrderResult struct {
+	Index  int               `json:"index"`
+	Status string            `json:"status"`
+	Order  *data.Order       `json:"order,omitempty"`
+	Errors map[string]string `json:"errors,omitempty"`
+}
+
+// reservationErrors describes why an order of a batch could not be reserved, like reservationErrResp does for a single order
+func reservationErrors(err error) map[string]string {
+	switch {
+	case errors.Is(err, errReduceOnlyExceeds):
+		return map[string]string{"reduce_only": err.Error()}
+	case errors.Is(err, data.ErrRecordNotFound):
+		return map[string]string{"balance": "no stock balance to sell from"}
+	default:
+		return map[string]string{"balance": err.Error()}
+	}
+}
+
+// orderBatchCreateHandler places up to maxOrderBatch orders of the user at once. Every order is validated like in
+// orderCreateHandler and all of them are reserved and inserted in one transaction, the resting orders reach the book
+// in one round trip. With all_or_none a single rejected order rejects the whole batch,
+// otherwise the rejected orders are reported and the rest is placed.
+func (app *application) orderBatchCreateHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Orders    []orderInput `json:"orders"`
+		AllOrNone bool         `json:"all_or_none"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badReqResp(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	v.Check(len(input.Orders) > 0, "orders", "must contain at least one order")
+	v.Check(len(input.Orders) <= maxOrderBatch, "orders", fmt.Sprintf("must not contain more than %d orders", maxOrderBatch))
+	if !v.Valid() {
+		app.failedValidationResp(w, r, v.Errors)
+		return
+	}
+
+	user := app.contextGetUser(r)
+	results := make([]batchOrderResult, len(input.Orders))
+	orders := make([]*data.Order, len(input.Orders))
+	stocks := make(map[int64]bool)
+	clientOrderIDs := make(map[string]bool)
+	rejected := false
+
+	for i, item := range input.Orders {
+		results[i] = batchOrderResult{Index: i, Status: batchOrderRejected}
+		itemValidator := validator.New()
+
+		if item.ClientOrderID != "" {
+			if clientOrderIDs[item.ClientOrderID] {
+				itemValidator.AddError("client_order_id", "is used by another order of the batch")
+			}
+			clientOrderIDs[item.ClientOrderID] = true
+
+			previous, err := app.models.Order.GetForClientOrderID(item.ClientOrderID, user.ID)
+			switch {
+			case err == nil:
+				results[i].Status = batchOrderReplayed
+				results[i].Order = previous
+				continue
+			case !errors.Is(err, data.ErrRecordNotFound):
+				app.serverErrResp(w, r, err)
+				return
+			}
+		}
+
+		exist, ok := stocks[item.StockID]
+		if !ok {
+			exist, err = app.models.Stock.ConfirmStockExist(item.StockID)
+			if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+				app.serverErrResp(w, r, err)
+				return
+			}
+			stocks[item.StockID] = exist
+		}
+		if !exist {
+			itemValidator.AddError("stock", fmt.Sprintf("can not find stock with id %d", item.StockID))
+		}
+
+		order, err := app.newOrder(itemValidator, user, item, data.ORDER_LEG_NONE)
+		if err != nil {
+			app.serverErrResp(w, r, err)
+			return
+		}
+		if !itemValidator.Valid() {
+			results[i].Errors = itemValidator.Errors
+			rejected = true
+			continue
+		}
+		orders[i] = &order
+	}
+	if rejected && input.AllOrNone {
+		app.orderBatchResp(w, r, http.StatusUnprocessableEntity, results)
+		return
+	}
+
+	tx, err := app.models.DBHandler.Begin()
+	if err != nil {
+		app.serverErrResp(w, r, err)
+		return
+	}
+	defer tx.Rollback()
+	txModels := data.NewTxModels(tx)
+
+	// the reservations of the whole batch are taken in request order
+	valid := []*data.Order{}
+	positions := []int{}
+	for i, order := range orders {
+		if order != nil {
+			valid = append(valid, order)
+			positions = append(positions, i)
+		}
+	}
+	reservations, err := app.reserveOrders(txModels, user.ID, valid)
+	if err != nil {
+		app.reservationErrResp(w, r, err)
+		return
+	}
+	placed := []*data.Order{}
+	for j, order := range valid {
+		i := positions[j]
+		if reservations[j] != nil {
+			results[i].Errors = reservationErrors(reservations[j])
+			rejected = true
+			continue
+		}
+		placed = append(placed, order)
+		results[i].Status = batchOrderCreated
+		results[i].Order = order
+	}
+	if rejected && input.AllOrNone {
+		for i := range results {
+			if results[i].Status == batchOrderCreated {
+				results[i].Status = batchOrderRejected
+				results[i].Order = nil
+			}
+		}
+		app.orderBatchResp(w, r, http.StatusUnprocessableEntity, results)
+		return
+	}
+
+	for _, order := range placed {
+		err = txModels.Order.Insert(order)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrDuplicateClientOrderID):
+				// a retry raced this batch, sending it again replays the orders placed by the other one
+				app.editConflictResp(w, r)
+			default:
+				app.serverErrResp(w, r, err)
+			}
+			return
+		}
+		err = app.recordUserEvent(txModels, data.USER_EVENT_ORDER_ACCEPTED, order, reservationDeltas(order, order.Quantity), nil)
+		if err != nil {
+			app.serverErrResp(w, r, err)
+			return
+		}
+	}
+
+	// the orders have to be visible in db before they reach the book, the matcher may settle them right away
+	err = tx.Commit()
+	if err != nil {
+		app.serverErrResp(w, r, err)
+		return
+	}
+	if len(placed) > 0 {
+		app.userStreams.notify(user.ID)
+	}
+
+	// an order that could not be handed over is withdrawn and reported as rejected, the rest of the batch stands
+	failed := app.submitOrders(placed)
+	for i := range results {
+		if results[i].Status != batchOrderCreated {
+			continue
+		}
+		order := results[i].Order
+		submitErr, ok := failed[order.ID]
+		if !ok {
+			continue
+		}
+		app.errorLogger.Error("error submitOrders", slog.Int64("order_id", order.ID), slog.String("msg", submitErr.Error()), slog.String("state", "submit batch order"))
+		err = app.withdrawOrder(order.ID, "the order could not be handed over to the order book")
+		if err != nil {
+			app.errorLogger.Error("error withdrawOrder", slog.Int64("order_id", order.ID), slog.String("msg", err.Error()), slog.String("state", "withdraw batch order"))
+		}
+		results[i].Status = batchOrderRejected
+		results[i].Errors = map[string]string{"order": "could not be handed over to the order book"}
+		results[i].Order, err = app.models.Order.GetForUser(order.ID, user.ID)
+		if err != nil {
+			app.serverErrResp(w, r, err)
+			return
+		}
+	}
+
+	// IOC and FOK orders are matched and closed by now and a post-only order may have been rejected, show where they ended up
+	for i := range results {
+		order := results[i].Order
+		if results[i].Status != batchOrderCreated || !order.IsImmediate() && order.PostOnly == data.ORDER_POST_ONLY_NONE {
+			continue
+		}
+		results[i].Order, err = app.models.Order.GetForUser(order.ID, user.ID)
+		if err != nil {
+			app.serverErrResp(w, r, err)
+			return
+		}
+	}
+
+	app.orderBatchResp(w, r, http.StatusOK, results)
+}
+
+func (app *application) orderBatchResp(w http.ResponseWriter, r *http.Request, status int, results []batchOrderResult) {
+	err := app.writeJSON(w, status, envelope{"results": results}, nil)
+	if err != nil {
+		app.serverErrResp(w, r, err)
+	}
+}
+
+// submitOrders hands newly placed orders over to the books like submitOrder,
+// the orders that simply rest in the book are queued in one round trip.
+// It returns the orders that could not be handed over, keyed by id, with the error of each.
+func (app *application) submitOrders(orders []*data.Order) map[int64]error {
+	failed := make(map[int64]error)
+	resting := []orderbook.Order{}
+	for _, order := range orders {
+		var err error
+		switch {
+		case order.Status == data.ORDER_STATUS_UNTRIGGERED:
+			err = app.triggerBook.Add(newTrigger(*order))
+		case order.IsImmediate() || order.PostOnly != data.ORDER_POST_ONLY_NONE:
+			err = app.submitOrder(*order)
+		default:
+			resting = append(resting, newBookOrder(*order))
+		}
+		if err != nil {
+			failed[order.ID] = err
+		}
+	}
+	if len(resting) == 0 {
+		return failed
+	}
+	err := app.orderBook.AddBatch(context.Background(), resting)
+	if err != nil {
+		// some of them may have been queued before the batch failed, withdrawOrder pulls those out again
+		for _, order := range resting {
+			failed[order.OrderID] = err
+		}
+	}
+	return failed
+}
+
+// orderMassCancelHandler cancels every open order of the user, or only those of one stock or one side
+func (app *application) orderMassCancelHandler(w http.ResponseWriter, r *http.Request) {
+	var filter data.OrderFilter
+
+	v := validator.New()
+	qs := r.URL.Query()
+
+	filter.StockID = app.readOptionalInt64(qs, "stock_id", v)
+	filter.Type = app.readOptionalInt(qs, "type", v)
+
+	if data.ValidateOrderFilter(v, filter); !v.Valid() {
+		app.failedValidationResp(w, r, v.Errors)
+		return
+	}
+
+	user := app.contextGetUser(r)
+	cancelled, err := app.killOrders(user.ID, filter, data.ORDER_EVENT_CANCELLED, "cancelled by the user")
+	if err != nil {
+		app.reservationErrResp(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "orders cancelled successfully", "cancelled": cancelled}, nil)
+	if err != nil {
+		app.serverErrResp(w, r, err)
+	}
+}
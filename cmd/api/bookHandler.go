@@ -0,0 +1,127 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/maxwellkuo47/tradingEngine/internal/data"
+	"github.com/maxwellkuo47/tradingEngine/internal/orderbook"
+	"github.com/maxwellkuo47/tradingEngine/internal/validator"
+)
+
+const (
+	// bookMaxDepth is how many price levels of each side one request can ask for
+	bookMaxDepth = 500
+	// tickerWindow is the time span of the volume, high, low and vwap of the ticker
+	tickerWindow = 24 * time.Hour
+)
+
+// publicStock answers a request for a stock that does not exist with not found, it reports whether the request can go on
+func (app *application) publicStock(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	stockID, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResp(w, r)
+		return 0, false
+	}
+
+	exist, err := app.models.Stock.ConfirmStockExist(stockID)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		app.serverErrResp(w, r, err)
+		return 0, false
+	}
+	if !exist {
+		app.notFoundResp(w, r)
+		return 0, false
+	}
+	return stockID, true
+}
+
+// bookShowHandler returns the aggregated price levels of both sides of the book of a stock, best price first.
+// Iceberg orders only count with their displayed quantity.
+func (app *application) bookShowHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	depth := app.readInt(r.URL.Query(), "depth", app.config.market.depthLevels, v)
+	v.Check(depth > 0, "depth", "must be greater than zero")
+	v.Check(depth <= bookMaxDepth, "depth", "must be a maximum of 500")
+	if !v.Valid() {
+		app.failedValidationResp(w, r, v.Errors)
+		return
+	}
+
+	stockID, ok := app.publicStock(w, r)
+	if !ok {
+		return
+	}
+
+	bids, err := app.orderBook.Depth(r.Context(), stockID, orderbook.Buy, depth)
+	if err != nil {
+		app.serverErrResp(w, r, err)
+		return
+	}
+	asks, err := app.orderBook.Depth(r.Context(), stockID, orderbook.Sell, depth)
+	if err != nil {
+		app.serverErrResp(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"stock_id": stockID, "time": time.Now(), "bids": bids, "asks": asks}, nil)
+	if err != nil {
+		app.serverErrResp(w, r, err)
+	}
+}
+
+// tickerShowHandler returns the last trade price, the best bid and ask and the trade stats of the last 24 hours of a stock
+func (app *application) tickerShowHandler(w http.ResponseWriter, r *http.Request) {
+	stockID, ok := app.publicStock(w, r)
+	if !ok {
+		return
+	}
+
+	bid, err := app.orderBook.Best(r.Context(), stockID, orderbook.Buy)
+	if err != nil {
+		app.serverErrResp(w, r, err)
+		return
+	}
+	ask, err := app.orderBook.Best(r.Context(), stockID, orderbook.Sell)
+	if err != nil {
+		app.serverErrResp(w, r, err)
+		return
+	}
+
+	var lastPrice *data.Money
+	var lastTradeAt *time.Time
+	last, err := app.models.Trade.GetLastForStock(stockID)
+	switch {
+	case err == nil:
+		lastPrice, lastTradeAt = &last.Price, &last.ExecutedAt
+	case !errors.Is(err, data.ErrRecordNotFound):
+		app.serverErrResp(w, r, err)
+		return
+	}
+
+	now := time.Now().UTC()
+	stats, err := app.models.Trade.GetStatsForStock(stockID, now.Add(-tickerWindow))
+	if err != nil {
+		app.serverErrResp(w, r, err)
+		return
+	}
+
+	ticker := envelope{
+		"stock_id":      stockID,
+		"time":          now,
+		"last_price":    lastPrice,
+		"last_trade_at": lastTradeAt,
+		"bid":           bid,
+		"ask":           ask,
+		"trades":        stats.Trades,
+		"volume":        stats.Volume,
+		"high":          stats.High,
+		"low":           stats.Low,
+		"vwap":          stats.VWAP,
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"ticker": ticker}, nil)
+	if err != nil {
+		app.serverErrResp(w, r, err)
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/maxwellkuo47/tradingEngine/internal/data"
+	"github.com/maxwellkuo47/tradingEngine/internal/validator"
+)
+
+// candleListHandler returns the candles of a stock in one interval, the last data.MaxCandles of them in the time range
+func (app *application) candleListHandler(w http.ResponseWriter, r *http.Request) {
+	var filter data.CandleFilter
+
+	v := validator.New()
+	qs := r.URL.Query()
+
+	filter.Period = app.readString(qs, "interval", "1m")
+	filter.From = app.readOptionalTime(qs, "from", v)
+	filter.To = app.readOptionalTime(qs, "to", v)
+
+	if data.ValidateCandleFilter(v, filter); !v.Valid() {
+		app.failedValidationResp(w, r, v.Errors)
+		return
+	}
+
+	stockID, ok := app.publicStock(w, r)
+	if !ok {
+		return
+	}
+
+	candles, err := app.models.Candle.GetForStock(stockID, filter)
+	if err != nil {
+		app.serverErrResp(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"stock_id": stockID, "interval": filter.Period, "candles": candles}, nil)
+	if err != nil {
+		app.serverErrResp(w, r, err)
+	}
+}
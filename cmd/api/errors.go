@@ -87,3 +87,13 @@ func (app *application) balanceRecordNotFoundResp(w http.ResponseWriter, r *http
 	message := "unexpected balance record not found"
 	app.errResp(w, r, http.StatusForbidden, message)
 }
+
+func (app *application) orderNotCancelableResp(w http.ResponseWriter, r *http.Request) {
+	message := "the order is no longer pending and cannot be cancelled"
+	app.errResp(w, r, http.StatusConflict, message)
+}
+
+func (app *application) orderNotAmendableResp(w http.ResponseWriter, r *http.Request, reason string) {
+	message := "the order cannot be amended: " + reason
+	app.errResp(w, r, http.StatusConflict, message)
+}
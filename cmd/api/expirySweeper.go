@@ -0,0 +1,75 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/maxwellkuo47/tradingEngine/internal/data"
+	"github.com/maxwellkuo47/tradingEngine/internal/orderbook"
+)
+
+// expiredOrdersBatch is how many expired orders one sweep closes at most
+const expiredOrdersBatch = 100
+
+// startExpirySweeper closes DAY and GTD orders once they expire,
+// and IOC or FOK orders that were left open by a crash between their match and their cancellation
+func (app *application) startExpirySweeper() {
+	app.background("expirySweeper", func() {
+		ticker := time.NewTicker(app.config.expiry.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-app.done:
+				app.infoLogger.Info("expiry sweeper stopped")
+				return
+			case <-ticker.C:
+				app.sweepExpiredOrders()
+			}
+		}
+	})
+}
+
+func (app *application) sweepExpiredOrders() {
+	orders, err := app.models.Order.GetExpired(time.Now(), expiredOrdersBatch)
+	if err != nil {
+		app.errorLogger.Error("error GetExpired", slog.String("msg", err.Error()), slog.String("state", "sweep expired orders"))
+		return
+	}
+
+	for _, order := range orders {
+		_, err := app.killOrder(order.ID, data.ORDER_EVENT_EXPIRED, expiryMessage(order))
+		if err != nil && !errors.Is(err, errOrderNotOpen) && !errors.Is(err, orderbook.ErrOrderNotFound) {
+			// left open, the next sweep tries again
+			app.errorLogger.Error(
+				"error killOrder",
+				slog.Int64("order_id", order.ID),
+				slog.String("msg", err.Error()),
+				slog.String("state", "expire order"),
+			)
+		}
+	}
+}
+
+func expiryMessage(order *data.Order) string {
+	switch order.TimeInForce {
+	case data.ORDER_TIF_IOC, data.ORDER_TIF_FOK:
+		return "immediate order closed because it was not completed right away"
+	case data.ORDER_TIF_DAY:
+		return "day order expired at the end of the trading day"
+	default:
+		return fmt.Sprintf("order expired at %s", order.ExpiresAt.UTC().Format(time.RFC3339))
+	}
+}
+
+// dayOrderExpiry is the end of the trading day that now belongs to, the next market close in UTC
+func (app *application) dayOrderExpiry(now time.Time) time.Time {
+	now = now.UTC()
+	closeAt := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC).Add(app.config.expiry.marketClose)
+	if !closeAt.After(now) {
+		closeAt = closeAt.AddDate(0, 0, 1)
+	}
+	return closeAt
+}
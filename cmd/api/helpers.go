@@ -7,7 +7,13 @@ import (
 	"io"
 	"log/slog"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/maxwellkuo47/tradingEngine/internal/validator"
 )
 
 type envelope map[string]any
@@ -32,6 +38,23 @@ func (app *application) writeJSON(w http.ResponseWriter, status int, data envelo
 	return nil
 }
 
+func (app *application) readIDParam(r *http.Request) (int64, error) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	id, err := strconv.ParseInt(params.ByName("id"), 10, 64)
+	if err != nil || id < 1 {
+		return 0, errors.New("invalid id parameter")
+	}
+
+	return id, nil
+}
+
+// readClientOrderIDParam reads the client order id from the path, the order is looked up by it as given
+func (app *application) readClientOrderIDParam(r *http.Request) string {
+	params := httprouter.ParamsFromContext(r.Context())
+	return params.ByName("client_order_id")
+}
+
 func (app *application) readJSON(w http.ResponseWriter, r *http.Request, dst any) error {
 	maxBytes := 1_048_576
 	r.Body = http.MaxBytesReader(w, r.Body, int64(maxBytes))
@@ -85,6 +108,79 @@ func (app *application) readJSON(w http.ResponseWriter, r *http.Request, dst any
 	return nil
 }
 
+func (app *application) readString(qs url.Values, key string, defaultValue string) string {
+	s := qs.Get(key)
+	if s == "" {
+		return defaultValue
+	}
+	return s
+}
+
+func (app *application) readInt(qs url.Values, key string, defaultValue int, v *validator.Validator) int {
+	s := qs.Get(key)
+	if s == "" {
+		return defaultValue
+	}
+
+	i, err := strconv.Atoi(s)
+	if err != nil {
+		v.AddError(key, "must be an integer value")
+		return defaultValue
+	}
+
+	return i
+}
+
+// readOptionalInt returns nil when the key is not in the query string
+func (app *application) readOptionalInt(qs url.Values, key string, v *validator.Validator) *int {
+	s := qs.Get(key)
+	if s == "" {
+		return nil
+	}
+
+	i, err := strconv.Atoi(s)
+	if err != nil {
+		v.AddError(key, "must be an integer value")
+		return nil
+	}
+
+	return &i
+}
+
+// readOptionalInt64 returns nil when the key is not in the query string
+func (app *application) readOptionalInt64(qs url.Values, key string, v *validator.Validator) *int64 {
+	s := qs.Get(key)
+	if s == "" {
+		return nil
+	}
+
+	i, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		v.AddError(key, "must be an integer value")
+		return nil
+	}
+
+	return &i
+}
+
+// readOptionalTime reads an RFC 3339 timestamp in UTC, it returns nil when the key is not in the query string.
+// The timestamp columns hold UTC without a zone, a query would drop the offset of any other zone.
+func (app *application) readOptionalTime(qs url.Values, key string, v *validator.Validator) *time.Time {
+	s := qs.Get(key)
+	if s == "" {
+		return nil
+	}
+
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		v.AddError(key, "must be an RFC 3339 timestamp")
+		return nil
+	}
+
+	t = t.UTC()
+	return &t
+}
+
 // for spin up goroutine
 // implement recover method for panic recovery
 // implement waitGroup for graceful shutdown
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/maxwellkuo47/tradingEngine/internal/validator"
+)
+
+func TestReadOptionalTimeIsUTC(t *testing.T) {
+	app := &application{}
+	tests := []struct {
+		value string
+		want  time.Time
+	}{
+		{"2024-05-01T12:00:00Z", time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)},
+		{"2024-05-01T12:00:00+02:00", time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)},
+		{"2024-05-01T00:30:00-05:00", time.Date(2024, 5, 1, 5, 30, 0, 0, time.UTC)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			v := validator.New()
+			got := app.readOptionalTime(url.Values{"from": {tt.value}}, "from", v)
+			if !v.Valid() || got == nil {
+				t.Fatalf("readOptionalTime(%q) failed: %v", tt.value, v.Errors)
+			}
+			if got.Location() != time.UTC || !got.Equal(tt.want) {
+				t.Errorf("readOptionalTime(%q) = %v, want %v", tt.value, got, tt.want)
+			}
+		})
+	}
+}
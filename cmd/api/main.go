@@ -11,7 +11,10 @@ import (
 
 	_ "github.com/lib/pq"
 	"github.com/maxwellkuo47/tradingEngine/internal/data"
-	"github.com/redis/go-redis/v9"
+	"github.com/maxwellkuo47/tradingEngine/internal/marketdata"
+	"github.com/maxwellkuo47/tradingEngine/internal/orderbook"
+	"github.com/maxwellkuo47/tradingEngine/internal/pricefeed"
+	"github.com/maxwellkuo47/tradingEngine/internal/triggerbook"
 )
 
 type config struct {
@@ -31,17 +34,59 @@ type config struct {
 	consumer struct {
 		frequncy uint
 	}
+	orderBook struct {
+		backend string
+	}
+	settlement struct {
+		consumer    string
+		maxAttempts int
+		backoff     time.Duration
+	}
+	money struct {
+		precision int
+		tickSize  string
+	}
+	expiry struct {
+		interval    time.Duration
+		marketClose time.Duration // since midnight UTC
+	}
+	priceFeed struct {
+		source  string
+		initial string
+		gbm     struct {
+			seed       int64
+			interval   time.Duration
+			drift      float64
+			volatility float64
+			stocks     string
+		}
+		replay struct {
+			file  string
+			speed float64
+		}
+		poll struct {
+			url      string
+			interval time.Duration
+		}
+	}
+	market struct {
+		depthLevels   int
+		depthInterval time.Duration
+	}
 }
 
 type application struct {
-	config          config
-	errorLogger     *slog.Logger
-	infoLogger      *slog.Logger
-	models          data.DBModels
-	wg              sync.WaitGroup
-	redisClient     *redis.Client
-	mockStockPrices sync.Map
-	done            chan bool
+	config      config
+	errorLogger *slog.Logger
+	infoLogger  *slog.Logger
+	models      data.DBModels
+	wg          sync.WaitGroup
+	orderBook   orderbook.OrderBook
+	triggerBook *triggerbook.Book
+	prices      *pricefeed.Feed
+	market      *marketdata.Hub
+	userStreams *userNotifier
+	done        chan bool
 }
 
 func main() {
@@ -64,6 +109,40 @@ func main() {
 	// consumer frequency
 	flag.UintVar(&cfg.consumer.frequncy, "consumer-frequncy", 50, "Consumer frequency")
 
+	// order book
+	flag.StringVar(&cfg.orderBook.backend, "orderbook", "redis", "Order book backend (redis|memory)")
+
+	// settlement
+	hostname, _ := os.Hostname()
+	flag.StringVar(&cfg.settlement.consumer, "settlement-consumer", hostname, "Name of this instance in the settlement consumer group, keep it stable across restarts")
+	flag.IntVar(&cfg.settlement.maxAttempts, "settlement-max-attempts", 5, "Attempts to settle a match before it is dead lettered")
+	flag.DurationVar(&cfg.settlement.backoff, "settlement-backoff", 100*time.Millisecond, "Delay before the first settlement retry, doubled on every retry")
+
+	// money
+	flag.IntVar(&cfg.money.precision, "money-precision", 4, "Number of decimal places of prices and balances")
+	flag.StringVar(&cfg.money.tickSize, "tick-size", "0.01", "Minimum price increment")
+
+	// order expiry
+	flag.DurationVar(&cfg.expiry.interval, "expiry-sweep-interval", time.Second, "How often expired orders are closed")
+	marketClose := flag.String("market-close", "00:00", "Time of day in UTC at which DAY orders expire (HH:MM)")
+
+	// price feed
+	flag.StringVar(&cfg.priceFeed.source, "price-source", "manual", "Source of the stock prices (manual|gbm|replay|poll)")
+	flag.StringVar(&cfg.priceFeed.initial, "price-initial", "100", "Starting price of every stock for the manual and gbm sources")
+	flag.Int64Var(&cfg.priceFeed.gbm.seed, "gbm-seed", 1, "Random seed of the gbm simulator")
+	flag.DurationVar(&cfg.priceFeed.gbm.interval, "gbm-interval", time.Second, "How often the gbm simulator moves the prices")
+	flag.Float64Var(&cfg.priceFeed.gbm.drift, "gbm-drift", 0.05, "Annualized drift of the gbm simulator")
+	flag.Float64Var(&cfg.priceFeed.gbm.volatility, "gbm-volatility", 0.2, "Annualized volatility of the gbm simulator")
+	flag.StringVar(&cfg.priceFeed.gbm.stocks, "gbm-stocks", "", "Per stock gbm drift and volatility (stock_id:drift:volatility,...)")
+	flag.StringVar(&cfg.priceFeed.replay.file, "replay-file", "", "CSV or NDJSON file of the ticks to replay")
+	flag.Float64Var(&cfg.priceFeed.replay.speed, "replay-speed", 1, "Replay speed factor, 0 replays without waiting")
+	flag.StringVar(&cfg.priceFeed.poll.url, "poll-url", "http://localhost:9090/prices", "Exchange URL to poll (http, https, ws or wss)")
+	flag.DurationVar(&cfg.priceFeed.poll.interval, "poll-interval", time.Second, "How often the exchange is polled, or reconnected to over websocket")
+
+	// market data
+	flag.IntVar(&cfg.market.depthLevels, "market-depth-levels", 20, "Price levels per side in the market data depth")
+	flag.DurationVar(&cfg.market.depthInterval, "market-depth-interval", 100*time.Millisecond, "How often the market data depth is read from the order book")
+
 	// parsing flag
 	flag.Parse()
 	infoLogger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{
@@ -75,6 +154,19 @@ func main() {
 		AddSource: true,
 	}))
 
+	closeTime, err := time.Parse("15:04", *marketClose)
+	if err != nil {
+		errorLogger.Error("market-close error", slog.String("msg", err.Error()))
+		os.Exit(1)
+	}
+	cfg.expiry.marketClose = closeTime.Sub(time.Date(0, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	err = data.ConfigureMoney(cfg.money.precision, cfg.money.tickSize)
+	if err != nil {
+		errorLogger.Error("ConfigureMoney error", slog.String("msg", err.Error()))
+		os.Exit(1)
+	}
+
 	db, err := OpenDB(cfg)
 	if err != nil {
 		errorLogger.Error("OpenDB error", slog.String("msg", err.Error()))
@@ -83,25 +175,49 @@ func main() {
 	defer db.Close()
 	infoLogger.Info("DB Connection", slog.String("Status", "OK"))
 
-	redis, err := createRedisClient()
+	orderBook, err := createOrderBook(cfg)
 	if err != nil {
-		errorLogger.Error("createRedisClient error", slog.String("msg", err.Error()))
+		errorLogger.Error("createOrderBook error", slog.String("msg", err.Error()))
 		os.Exit(1)
 	}
-	infoLogger.Info("Redis Connection", slog.String("Status", "OK"))
+	infoLogger.Info("Order Book", slog.String("backend", cfg.orderBook.backend), slog.String("Status", "OK"))
 
 	app := &application{
 		config:      cfg,
 		infoLogger:  infoLogger,
 		errorLogger: errorLogger,
 		models:      data.NewModels(db),
-		redisClient: redis,
+		orderBook:   orderBook,
+		triggerBook: triggerbook.New(),
+		prices:      pricefeed.NewFeed(),
+		market:      marketdata.NewHub(orderBook, cfg.market.depthLevels),
+		userStreams: newUserNotifier(),
 		done:        make(chan bool),
 	}
+	app.market.OnError = func(err error) {
+		errorLogger.Error("error Refresh", slog.String("msg", err.Error()), slog.String("state", "refresh market data depth"))
+	}
+
+	stockIDs, err := app.models.Stock.GetAllStockIDs()
+	if err != nil {
+		errorLogger.Error("GetAllStockIDs error", slog.String("msg", err.Error()))
+		os.Exit(1)
+	}
+	priceSource, err := createPriceSource(cfg, stockIDs, errorLogger)
+	if err != nil {
+		errorLogger.Error("createPriceSource error", slog.String("msg", err.Error()))
+		os.Exit(1)
+	}
+
+	err = app.recoverOrderBook()
+	if err != nil {
+		errorLogger.Error("recoverOrderBook error", slog.String("msg", err.Error()))
+		os.Exit(1)
+	}
 
-	err = app.createFakeStockPricesForTesting()
+	err = app.loadTriggerBook()
 	if err != nil {
-		errorLogger.Error("createFakeStockPricesForTesting error", slog.String("msg", err.Error()))
+		errorLogger.Error("loadTriggerBook error", slog.String("msg", err.Error()))
 		os.Exit(1)
 	}
 
@@ -110,6 +226,10 @@ func main() {
 		errorLogger.Error("spinUpConsumer error", slog.String("msg", err.Error()))
 		os.Exit(1)
 	}
+	app.startExpirySweeper()
+	app.startMarketData()
+	app.startPriceSource(priceSource)
+	infoLogger.Info("Price Source", slog.String("source", cfg.priceFeed.source), slog.String("Status", "OK"))
 
 	err = app.serve()
 	if err != nil {
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+
+	"github.com/maxwellkuo47/tradingEngine/internal/data"
+	"github.com/maxwellkuo47/tradingEngine/internal/orderbook"
+)
+
+// basisPoints in one whole, a slippage of 50 basis points is 0.5%
+const basisPoints = 10_000
+
+// slippageProtection is the worst price a market order accepts when it may move at most slippageBps away from reference,
+// rounded to the tick size towards the reference so the slippage is never exceeded
+func slippageProtection(orderType int, reference data.Money, slippageBps int) data.Money {
+	tick := data.TickSize()
+	switch orderType {
+	case data.ORDER_TYPE_BUY:
+		price := reference * data.Money(basisPoints+slippageBps) / basisPoints
+		return price - price%tick
+	case data.ORDER_TYPE_SELL:
+		price := reference * data.Money(basisPoints-slippageBps) / basisPoints
+		if price%tick != 0 {
+			price += tick - price%tick
+		}
+		return price
+	default:
+		//just ignore because this request would be return by validator
+		return reference
+	}
+}
+
+// marketReference is the price the slippage of a market order is measured from: the trigger price of a stop market order,
+// or the best price on the other side of the book, zero when that side is empty
+func (app *application) marketReference(order data.Order) (data.Money, error) {
+	if order.IsStop() {
+		return order.TriggerPrice, nil
+	}
+	if order.Type != data.ORDER_TYPE_BUY && order.Type != data.ORDER_TYPE_SELL {
+		// rejected by ValidateOrder
+		return 0, nil
+	}
+
+	best, err := app.orderBook.Best(context.Background(), order.StockID, orderbook.Side(order.Type).Opposite())
+	if err != nil || best == nil {
+		return 0, err
+	}
+	return best.Price, nil
+}
+
+// bookSweep is what an order would get from the other side of the book right now
+type bookSweep struct {
+	quantity   int        // shares it could match at its price, at most its quantity
+	worstPrice data.Money // price of the last level it reaches
+}
+
+// sweepBook walks the opposite side of the book best price first, up to the order's price and quantity.
+// It only looks, the book can change before the order gets there.
+func (app *application) sweepBook(order data.Order) (*bookSweep, error) {
+	side := orderbook.Side(order.Type).Opposite()
+	// every level holds at least one share, more levels than the order quantity are never needed
+	levels, err := app.orderBook.Depth(context.Background(), order.StockID, side, order.Quantity)
+	if err != nil {
+		return nil, err
+	}
+
+	sweep := &bookSweep{}
+	for _, level := range levels {
+		crosses := level.Price <= order.Price
+		if order.Type == data.ORDER_TYPE_SELL {
+			crosses = level.Price >= order.Price
+		}
+		if !crosses || sweep.quantity == order.Quantity {
+			break
+		}
+		quantity := min(level.Quantity, order.Quantity-sweep.quantity)
+		sweep.quantity += quantity
+		sweep.worstPrice = level.Price
+	}
+	return sweep, nil
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/maxwellkuo47/tradingEngine/internal/data"
+	"github.com/maxwellkuo47/tradingEngine/internal/marketdata"
+	"github.com/maxwellkuo47/tradingEngine/internal/orderbook"
+)
+
+const (
+	wsWriteWait    = 10 * time.Second
+	wsPongWait     = 60 * time.Second
+	wsPingInterval = wsPongWait * 9 / 10
+	wsReadLimit    = 4096
+
+	// marketClientBuffer is how many messages a market data client may fall behind before it misses some
+	marketClientBuffer = 256
+)
+
+var wsUpgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+}
+
+// wsConn serializes the writes to a websocket connection, a connection allows one writer at a time
+type wsConn struct {
+	*websocket.Conn
+	mu sync.Mutex
+}
+
+func (c *wsConn) send(v any) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.SetWriteDeadline(time.Now().Add(wsWriteWait))
+	return c.WriteJSON(v)
+}
+
+func (c *wsConn) ping() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteWait))
+}
+
+// goingAway tells the client the server is shutting down
+func (c *wsConn) goingAway() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down"), time.Now().Add(wsWriteWait))
+}
+
+// startMarketData keeps the market data hub up to date with the order book and the price feed until shutdown
+func (app *application) startMarketData() {
+	ctx, cancel := context.WithCancel(context.Background())
+	app.background("marketData", func() {
+		<-app.done
+		cancel()
+	})
+
+	app.background("marketDataDepth", func() {
+		app.market.Run(ctx, app.config.market.depthInterval)
+		app.infoLogger.Info("market data stopped")
+	})
+
+	ticks := app.prices.Subscribe(marketClientBuffer)
+	app.background("marketDataTicker", func() {
+		defer ticks.Close()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case tick := <-ticks.C:
+				app.market.PublishTicker(tick.StockID, tick.Price, tick.Time)
+			}
+		}
+	})
+}
+
+// marketTrade is the public view of a match, the taker is the order that reached the book last
+func marketTrade(match orderbook.Match) marketdata.Trade {
+	side := "sell"
+	if match.TakerSide() == orderbook.Buy {
+		side = "buy"
+	}
+	return marketdata.Trade{
+		ID:         match.ID,
+		Price:      match.Price,
+		Quantity:   match.Quantity,
+		Side:       side,
+		ExecutedAt: match.ExecutedAt,
+	}
+}
+
+// marketStreamHandler streams the depth, the trades and the price of the stocks a client subscribes to.
+// The client sends {"op": "subscribe", "stock_id": 1} to get a snapshot followed by the updates of a stock,
+// subscribing again resyncs it with a new snapshot, and {"op": "unsubscribe", "stock_id": 1} to stop.
+func (app *application) marketStreamHandler(w http.ResponseWriter, r *http.Request) {
+	ws, err := wsUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// the upgrader has replied already
+		return
+	}
+	conn := &wsConn{Conn: ws}
+	defer conn.Close()
+
+	client := app.market.Subscribe(marketClientBuffer)
+	defer client.Close()
+
+	// the writer ends when the connection fails or the client is closed, the reader ends when the connection is closed
+	stop := make(chan struct{})
+	go func() {
+		defer conn.Close()
+		ping := time.NewTicker(wsPingInterval)
+		defer ping.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-app.done:
+				conn.goingAway()
+				return
+			case msg, ok := <-client.C:
+				if !ok {
+					return
+				}
+				if conn.send(msg) != nil {
+					return
+				}
+			case <-ping.C:
+				if conn.ping() != nil {
+					return
+				}
+			}
+		}
+	}()
+	defer close(stop)
+
+	conn.SetReadLimit(wsReadLimit)
+	conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	})
+
+	stocks := make(map[int64]bool)
+	for {
+		_, message, err := conn.ReadMessage()
+		if err != nil {
+			// closed by the client, the writer or a missed pong
+			return
+		}
+
+		var input struct {
+			Op      string `json:"op"`
+			StockID int64  `json:"stock_id"`
+		}
+		err = json.Unmarshal(message, &input)
+		if err != nil {
+			app.marketStreamError(conn, fmt.Errorf("message contains badly-formed JSON: %w", err))
+			continue
+		}
+
+		exist, ok := stocks[input.StockID]
+		if !ok {
+			exist, err = app.models.Stock.ConfirmStockExist(input.StockID)
+			if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+				app.logError(r, err)
+				app.marketStreamError(conn, errors.New("the server encountered a problem and could not process your request"))
+				return
+			}
+			stocks[input.StockID] = exist
+		}
+		if !exist {
+			app.marketStreamError(conn, fmt.Errorf("can not find stock with id %d", input.StockID))
+			continue
+		}
+
+		switch input.Op {
+		case "subscribe":
+			err = client.Join(r.Context(), input.StockID)
+			if err != nil {
+				if !errors.Is(err, marketdata.ErrSlowClient) {
+					app.errorLogger.Error("error Join", slog.Int64("stock_id", input.StockID), slog.String("msg", err.Error()), slog.String("state", "subscribe market data"))
+				}
+				app.marketStreamError(conn, err)
+				return
+			}
+		case "unsubscribe":
+			client.Leave(input.StockID)
+		default:
+			app.marketStreamError(conn, fmt.Errorf("unknown op %q, must be subscribe or unsubscribe", input.Op))
+		}
+	}
+}
+
+func (app *application) marketStreamError(conn *wsConn, err error) {
+	conn.send(envelope{"type": "error", "error": err.Error()})
+}
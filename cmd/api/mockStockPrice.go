@@ -3,12 +3,15 @@ package main
 import (
 	"net/http"
 	"slices"
+
+	"github.com/maxwellkuo47/tradingEngine/internal/data"
+	"github.com/maxwellkuo47/tradingEngine/internal/validator"
 )
 
 func (app *application) adjustStockPrice(w http.ResponseWriter, r *http.Request) {
 	var input struct {
-		StockID int64   `json:"stock_id"`
-		Price   float64 `json:"price"`
+		StockID int64      `json:"stock_id"`
+		Price   data.Money `json:"price"`
 	}
 
 	err := app.readJSON(w, r, &input)
@@ -23,15 +26,16 @@ func (app *application) adjustStockPrice(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	if exist := slices.Contains(stockIDs, input.StockID); !exist {
-		app.failedValidationResp(w, r, map[string]string{"stock_id": "stock id does not exist"})
-	}
-
-	if input.Price < 0 {
-		app.failedValidationResp(w, r, map[string]string{"price": "price cannot be negative"})
+	v := validator.New()
+	v.Check(slices.Contains(stockIDs, input.StockID), "stock_id", "stock id does not exist")
+	v.Check(input.Price > 0, "price", "price must be greater than zero")
+	v.Check(input.Price.IsMultipleOf(data.TickSize()), "price", "price must be a multiple of the tick size "+data.TickSize().String())
+	if !v.Valid() {
+		app.failedValidationResp(w, r, v.Errors)
+		return
 	}
 
-	app.mockStockPrices.Store(input.StockID, input.Price)
+	app.updateStockPrice(input.StockID, input.Price)
 
 	err = app.writeJSON(w, http.StatusAccepted, envelope{"params": input}, nil)
 	if err != nil {
@@ -40,17 +44,3 @@ func (app *application) adjustStockPrice(w http.ResponseWriter, r *http.Request)
 	}
 
 }
-
-func (app *application) createFakeStockPricesForTesting() error {
-	ids, err := app.models.Stock.GetAllStockIDs()
-
-	if err != nil {
-		return err
-	}
-
-	for _, id := range ids {
-		app.mockStockPrices.Store(id, 100.0)
-	}
-
-	return nil
-}
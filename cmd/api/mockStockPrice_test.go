@@ -0,0 +1,45 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/maxwellkuo47/tradingEngine/internal/data"
+)
+
+func TestAdjustStockPrice(t *testing.T) {
+	app := newTestApp(t)
+	user := newTestUser(t, app, data.NewMoney(1000), nil)
+
+	tests := []struct {
+		name  string
+		price string
+		want  int
+	}{
+		{name: "on the tick", price: "10.01", want: http.StatusAccepted},
+		{name: "zero", price: "0", want: http.StatusUnprocessableEntity},
+		{name: "negative", price: "-10", want: http.StatusUnprocessableEntity},
+		{name: "between ticks", price: "10.005", want: http.StatusUnprocessableEntity},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before, _ := app.prices.Price(1)
+			w := serveAs(t, app, user, app.adjustStockPrice, map[string]any{"stock_id": 1, "price": tt.price})
+			if w.Code != tt.want {
+				t.Fatalf("adjustStockPrice = %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+			price, _ := app.prices.Price(1)
+			switch {
+			case tt.want == http.StatusAccepted && price.String() != tt.price:
+				t.Errorf("price = %s, want %s", price, tt.price)
+			case tt.want != http.StatusAccepted && price != before:
+				t.Errorf("price = %s, want it left at %s", price, before)
+			}
+		})
+	}
+
+	w := serveAs(t, app, user, app.adjustStockPrice, map[string]any{"stock_id": 999, "price": "10"})
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("adjustStockPrice of an unknown stock = %d, want %d", w.Code, http.StatusUnprocessableEntity)
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/maxwellkuo47/tradingEngine/internal/data"
+	"github.com/maxwellkuo47/tradingEngine/internal/orderbook"
+	"github.com/maxwellkuo47/tradingEngine/internal/triggerbook"
+	"github.com/redis/go-redis/v9"
+)
+
+func createRedisClient() (*redis.Client, error) {
+	client := redis.NewClient(&redis.Options{
+		Addr:     "localhost:6379",
+		Password: "",
+		DB:       0,
+	})
+
+	_, err := client.Ping(context.Background()).Result()
+	if err != nil {
+		return nil, err
+	}
+	return client, nil
+}
+
+// createOrderBook sets up the order book backend selected by the orderbook flag
+func createOrderBook(cfg config) (orderbook.OrderBook, error) {
+	switch cfg.orderBook.backend {
+	case "redis":
+		client, err := createRedisClient()
+		if err != nil {
+			return nil, err
+		}
+		return orderbook.NewRedis(client, cfg.settlement.consumer), nil
+	case "memory":
+		return orderbook.NewMemory(), nil
+	default:
+		return nil, fmt.Errorf("unknown order book backend %q", cfg.orderBook.backend)
+	}
+}
+
+// newBookOrder converts an order record to the open part of it that rests in the book,
+// an iceberg order only shows its first slice
+func newBookOrder(order data.Order) orderbook.Order {
+	bookOrder := orderbook.Order{
+		OrderID:    order.ID,
+		UserID:     order.UserID,
+		StockID:    order.StockID,
+		Side:       orderbook.Side(order.Type),
+		Price:      order.Price,
+		Filled:     order.FilledQuantity,
+		Display:    order.DisplayQuantity,
+		STP:        order.STPMode,
+		CreateTime: time.Now(),
+	}
+	bookOrder.SetOpen(order.RemainingQuantity())
+	return bookOrder
+}
+
+// amendedBookOrder is what is left of an order after an amendment that costs it its place in the queue,
+// it reaches the book anew at price with open shares
+func amendedBookOrder(remaining orderbook.Order, price data.Money, open int) orderbook.Order {
+	amended := remaining
+	amended.Price = price
+	amended.SetOpen(open)
+	amended.Seq = 0
+	amended.CreateTime = time.Now()
+	return amended
+}
+
+// newTrigger converts an untriggered stop order to its entry in the trigger book
+func newTrigger(order data.Order) triggerbook.Trigger {
+	return triggerbook.Trigger{
+		OrderID:   order.ID,
+		StockID:   order.StockID,
+		Price:     order.TriggerPrice,
+		Direction: triggerbook.Direction(order.TriggerDirection),
+
+		TrailAmount: order.TrailAmount,
+		TrailBps:    order.TrailBps,
+		Mark:        order.WaterMark,
+	}
+}
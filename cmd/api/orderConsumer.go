@@ -1,12 +1,14 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"log/slog"
 	"time"
 
 	"github.com/maxwellkuo47/tradingEngine/internal/data"
+	"github.com/maxwellkuo47/tradingEngine/internal/orderbook"
 )
 
 func (app *application) spinUpConsumer() error {
@@ -15,40 +17,37 @@ func (app *application) spinUpConsumer() error {
 		return err
 	}
 	for _, stockID := range stockIDs {
-		app.createBuyOrderConsumer(stockID)
-		app.createSellOrderConsumer(stockID)
+		app.createOrderMatcher(stockID)
+		app.createSettler(stockID)
 	}
 	return nil
 }
-func (app *application) createBuyOrderConsumer(stockID int64) {
-	goroutineName := fmt.Sprintf("buyOrderConsumer_%d", stockID)
+
+// createOrderMatcher runs a continuous double auction for one stock,
+// crossing the best bid against the best ask as long as the book is crossed
+func (app *application) createOrderMatcher(stockID int64) {
+	goroutineName := fmt.Sprintf("orderMatcher_%d", stockID)
 	app.background(goroutineName, func() {
 		for {
 			select {
 
 			case <-app.done: // for gracefully shutdown
-				app.infoLogger.Info("stop buyOrderConsumer", slog.Int64("stock_id", stockID))
+				app.infoLogger.Info("stop orderMatcher", slog.Int64("stock_id", stockID))
 				return
 
 			default:
-				price, _ := app.mockStockPrices.Load(stockID)
-				currentPrice := price.(float64)
-				currentTime := time.Now()
-				redisOrder, err := app.consumeBuyOrder(stockID, currentPrice)
+				match, err := app.orderBook.PopMatch(context.Background(), stockID)
 				if err != nil {
-					app.errorLogger.Error("error consumeBuyOrder", slog.Int64("consumer_stock_id", stockID), slog.String("msg", err.Error()), slog.String("state", "get order from queue"))
-				} else if redisOrder != nil {
-					// pretend we're successfully sending the sell request to the platform for the customer then we need to do
-					// 1. update order status
-					// 2. update user wallet there is difference between actual price and order price
-					// 3. update user stock balance
-					// 4. create trade record
-					// create a goroutine to process db transaction
-					// order consumer just go for next order
-					orderProcessName := fmt.Sprintf("stock_%d_process_buy_order_%d", stockID, redisOrder.OrderID)
-					app.background(orderProcessName, func() {
-						app.processBuyOrder(stockID, redisOrder.OrderID, redisOrder.UserID, currentPrice, currentTime)
-					})
+					app.errorLogger.Error("error PopMatch", slog.Int64("consumer_stock_id", stockID), slog.String("msg", err.Error()), slog.String("state", "match orders from queue"))
+				} else if match != nil {
+					// the trade price becomes the current stock price, orders kept from trading with their own user leave it as it is
+					if match.SelfTrade == nil {
+						app.updateStockPrice(stockID, match.Price)
+					}
+
+					// the matched quantity is already taken out of the book and logged for settlement,
+					// the settler of the stock picks it up, so matcher just go for next match without waiting
+					continue
 				}
 				time.Sleep(time.Millisecond * time.Duration(app.config.consumer.frequncy)) // default 50ms
 			}
@@ -56,56 +55,217 @@ func (app *application) createBuyOrderConsumer(stockID int64) {
 	})
 }
 
-func (app *application) createSellOrderConsumer(stockID int64) {
-	goroutineName := fmt.Sprintf("sellOrderConsumer_%d", stockID)
+// createSettler settles the matches of one stock in the order they were made.
+// A match stays in the settlement log until its db transaction is committed, so a failed settlement
+// or a crash never loses it, and settling the same match again is a no-op.
+// The matches of orders with a dead lettered match are held back in the log and the rest are settled, see parkedOrders.
+func (app *application) createSettler(stockID int64) {
+	goroutineName := fmt.Sprintf("settler_%d", stockID)
 	app.background(goroutineName, func() {
+		var parked parkedOrders
+		// every match up to the last held back one is held back or gone, the log is read on after it
+		after := ""
 		for {
 			select {
 
 			case <-app.done: // for gracefully shutdown
-				app.infoLogger.Info("stop sellOrderConsumer", slog.Int64("stock_id", stockID))
+				app.infoLogger.Info("stop settler", slog.Int64("stock_id", stockID))
 				return
 
 			default:
-				price, _ := app.mockStockPrices.Load(stockID)
-				currentPrice := price.(float64)
-				currentTime := time.Now()
-				redisOrder, err := app.consumeSellOrder(stockID, currentPrice)
+				if parked == nil {
+					deadLetters, err := app.orderBook.DeadLetters(context.Background(), stockID)
+					if err != nil {
+						app.errorLogger.Error("error DeadLetters", slog.Int64("consumer_stock_id", stockID), slog.String("msg", err.Error()), slog.String("state", "read dead letters"))
+						time.Sleep(time.Millisecond * time.Duration(app.config.consumer.frequncy))
+						continue
+					}
+					parked = parkedOrders{}
+					for _, deadLetter := range deadLetters {
+						parked.park(deadLetter.Match, deadLetter.Match.ID)
+					}
+				}
+
+				matches, err := app.orderBook.PendingMatches(context.Background(), stockID, after, 100)
 				if err != nil {
-					app.errorLogger.Error("error consumeSellOrder", slog.Int64("consumer_stock_id", stockID), slog.String("msg", err.Error()), slog.String("state", "get order from queue"))
-				} else if redisOrder != nil {
-					// pretend we're successfully sending the sell request to the platform for the customer then we need to do
-					// 1. update order status
-					// 2. update user wallet with the actual sell price
-					// 3. create trade record
-					// create a goroutine to process db transaction
-					// order consumer just go for next order
-					orderProcessName := fmt.Sprintf("stock_%d_process_sell_order_%d", stockID, redisOrder.OrderID)
-					app.background(orderProcessName, func() {
-						app.processSellOrder(stockID, redisOrder.OrderID, redisOrder.UserID, currentPrice, currentTime)
-					})
+					app.errorLogger.Error("error PendingMatches", slog.Int64("consumer_stock_id", stockID), slog.String("msg", err.Error()), slog.String("state", "read settlement log"))
+				}
+				if len(matches) == 0 {
+					time.Sleep(time.Millisecond * time.Duration(app.config.consumer.frequncy))
+					continue
+				}
+				for _, match := range matches {
+					if deadLetterID, ok := parked.blocking(match); ok {
+						// the counterparty is filled ahead of its settled quantity as well, its later matches wait too
+						parked.park(match, deadLetterID)
+						after = match.ID
+						app.errorLogger.Error(
+							"settlement held back",
+							slog.Int64("consumer_stock_id", stockID),
+							slog.String("match_id", match.ID),
+							slog.String("dead_letter_match_id", deadLetterID),
+							slog.Int64("buy_order_id", match.Buy.OrderID),
+							slog.Int64("sell_order_id", match.Sell.OrderID),
+							slog.String("state", "hold back the matches of a dead lettered order"),
+						)
+						continue
+					}
+					if !app.settleMatch(stockID, match, parked) {
+						// read the log again from the match that is left in it
+						break
+					}
 				}
-				time.Sleep(time.Millisecond * time.Duration(app.config.consumer.frequncy)) // default 50ms
 			}
 		}
 	})
 }
 
-func (app *application) processBuyOrder(stockID, orderID, userID int64, currentPrice float64, currentTime time.Time) {
+// parkedOrders maps each order of a dead lettered match to the id of that match.
+// A later match of such an order cannot be settled before the dead letter is, its fill offset is ahead of the order's filled quantity,
+// so the settler holds it back in the settlement log instead of dead lettering every later match of the order too.
+// The other order of a held back match is parked in turn, under the same dead letter
+type parkedOrders map[int64]string
+
+func (p parkedOrders) park(match orderbook.Match, deadLetterID string) {
+	p[match.Buy.OrderID] = deadLetterID
+	p[match.Sell.OrderID] = deadLetterID
+}
+
+// blocking returns the id of the dead lettered match that holds back the match
+func (p parkedOrders) blocking(match orderbook.Match) (string, bool) {
+	if id, ok := p[match.Buy.OrderID]; ok {
+		return id, true
+	}
+	id, ok := p[match.Sell.OrderID]
+	return id, ok
+}
+
+// settleMatch retries the settlement of a match with exponential backoff and moves it to the dead letters
+// once the attempts are used up, parking both of its orders. It returns false when the match is left in the settlement log,
+// because it could not be acknowledged or dead lettered or because the application shuts down in between.
+func (app *application) settleMatch(stockID int64, match orderbook.Match, parked parkedOrders) bool {
+	backoff := app.config.settlement.backoff
+	for attempt := 1; ; attempt++ {
+		err := app.processMatch(stockID, match)
+		if err == nil {
+			err = app.orderBook.AckMatch(context.Background(), stockID, match.ID)
+			if err != nil {
+				// the match is read again and skipped because both fills are already applied
+				app.errorLogger.Error("error AckMatch", slog.Int64("consumer_stock_id", stockID), slog.String("match_id", match.ID), slog.String("msg", err.Error()), slog.String("state", "acknowledge settlement"))
+				return false
+			}
+			return true
+		}
+
+		if attempt >= app.config.settlement.maxAttempts {
+			err = app.orderBook.DeadLetter(context.Background(), stockID, match, err.Error())
+			if err != nil {
+				app.errorLogger.Error("error DeadLetter", slog.Int64("consumer_stock_id", stockID), slog.String("match_id", match.ID), slog.String("msg", err.Error()), slog.String("state", "dead letter settlement"))
+				return false
+			}
+			parked.park(match, match.ID)
+			app.errorLogger.Error(
+				"settlement dead lettered",
+				slog.Int64("consumer_stock_id", stockID),
+				slog.String("match_id", match.ID),
+				slog.Int64("buy_order_id", match.Buy.OrderID),
+				slog.Int64("sell_order_id", match.Sell.OrderID),
+				slog.Int("attempts", attempt),
+			)
+			return true
+		}
+
+		select {
+		case <-app.done:
+			return false
+		case <-time.After(backoff):
+			backoff *= 2
+		}
+	}
+}
+
+// errSettlementOutOfOrder means an earlier fill of the order has not been settled,
+// applying this one first would corrupt the fill offsets used as idempotency keys
+var errSettlementOutOfOrder = errors.New("an earlier fill of the order is not settled yet")
+
+// fillSettled reports whether the fill of a book order in a match is already written to its order record.
+// The order id and the fill offset of the book order key the settlement, so a retried match is never applied twice.
+func fillSettled(order *data.Order, bookOrder orderbook.Order, quantity int) (bool, error) {
+	switch {
+	case order.FilledQuantity == bookOrder.Filled:
+		return false, nil
+	case order.FilledQuantity >= bookOrder.Filled+quantity:
+		return true, nil
+	default:
+		return false, errSettlementOutOfOrder
+	}
+}
+
+func (app *application) processMatch(stockID int64, match orderbook.Match) error {
 	// begin transaction
 	tx, err := app.models.DBHandler.Begin()
-	defer tx.Rollback()
 	if err != nil {
 		app.errorLogger.Error(
 			"error Begin",
 			slog.Int64("consumer_stock_id", stockID),
-			slog.Int64("order_id", orderID),
+			slog.Int64("buy_order_id", match.Buy.OrderID),
+			slog.Int64("sell_order_id", match.Sell.OrderID),
 			slog.String("msg", err.Error()),
 			slog.String("state", "begin transaction"),
 		)
+		return err
 	}
+	defer tx.Rollback()
 	txModels := data.NewTxModels(tx)
-	// get order and update status
+
+	// a fill may arm or cancel other orders of its group
+	changes := &bookChanges{}
+	committed := false
+	defer func() { changes.finish(committed) }()
+
+	// buy side is always settled first so concurrent settlements lock orders in the same order
+	if match.SelfTrade != nil {
+		err = app.processSelfTrade(txModels, stockID, match, orderbook.Buy, changes)
+		if err != nil {
+			return err
+		}
+		err = app.processSelfTrade(txModels, stockID, match, orderbook.Sell, changes)
+		if err != nil {
+			return err
+		}
+	} else {
+		err = app.processBuyOrder(txModels, stockID, match, changes)
+		if err != nil {
+			return err
+		}
+		err = app.processSellOrder(txModels, stockID, match, changes)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		app.errorLogger.Error(
+			"error Commit",
+			slog.Int64("consumer_stock_id", stockID),
+			slog.Int64("buy_order_id", match.Buy.OrderID),
+			slog.Int64("sell_order_id", match.Sell.OrderID),
+			slog.String("msg", err.Error()),
+			slog.String("state", "commit transaction"),
+		)
+		return err
+	}
+	committed = true
+
+	return nil
+}
+
+func (app *application) processBuyOrder(txModels data.TxModels, stockID int64, match orderbook.Match, changes *bookChanges) error {
+	orderID := match.Buy.OrderID
+	userID := match.Buy.UserID
+
+	// get order and update fill
 	order, err := txModels.Order.GetOrderForUpdate(orderID)
 	if err != nil {
 		app.errorLogger.Error(
@@ -115,13 +275,39 @@ func (app *application) processBuyOrder(stockID, orderID, userID int64, currentP
 			slog.String("msg", err.Error()),
 			slog.String("state", "get order record"),
 		)
-		return
+		return err
+	}
+	settled, err := fillSettled(order, match.Buy, match.Quantity)
+	if err != nil {
+		app.errorLogger.Error(
+			"error fillSettled",
+			slog.Int64("consumer_stock_id", stockID),
+			slog.Int64("order_id", orderID),
+			slog.String("msg", err.Error()),
+			slog.String("state", "check order fill"),
+		)
+		return err
+	}
+	if settled {
+		return nil
+	}
+	// the fill was taken from the book before any cancellation, so it is applied even if the order was killed meanwhile,
+	// orderCancelHandler only refunds what was still left in the book
+	order.UpdatedAt = time.Now()
+	err = txModels.Order.UpdateOrderFill(order, match.Quantity)
+	if err != nil {
+		app.errorLogger.Error(
+			"error UpdateOrderFill",
+			slog.Int64("consumer_stock_id", stockID),
+			slog.Int64("order_id", orderID),
+			slog.String("msg", err.Error()),
+			slog.String("state", "update order fill"),
+		)
+		return err
 	}
-	order.UpdatedAt = currentTime
-	txModels.Order.UpdateOrderStatus(order, data.ORDER_STATUS_FILLED)
 
-	// update user's wallet if actual price is lower than order price
-	if currentPrice < order.Price {
+	// update user's wallet if trade price is lower than order price
+	if match.Price < order.Price {
 		userWallet, err := txModels.UserWallet.GetUserWallet(userID)
 		if err != nil {
 			app.errorLogger.Error(
@@ -131,10 +317,10 @@ func (app *application) processBuyOrder(stockID, orderID, userID int64, currentP
 				slog.String("msg", err.Error()),
 				slog.String("state", "get user wallet"),
 			)
-			return
+			return err
 		}
 		// refund difference to user
-		userWallet.Balance += float64(order.Quantity) * (order.Price - currentPrice)
+		userWallet.Balance += (order.Price - match.Price).Mul(match.Quantity)
 		err = txModels.UserWallet.Update(userWallet)
 		if err != nil {
 			app.errorLogger.Error(
@@ -144,7 +330,7 @@ func (app *application) processBuyOrder(stockID, orderID, userID int64, currentP
 				slog.String("msg", err.Error()),
 				slog.String("state", "update user wallet"),
 			)
-			return
+			return err
 		}
 	}
 
@@ -169,7 +355,7 @@ func (app *application) processBuyOrder(stockID, orderID, userID int64, currentP
 					slog.String("msg", err.Error()),
 					slog.String("state", "create user stock balance record"),
 				)
-				return
+				return err
 			}
 		default:
 			app.errorLogger.Error(
@@ -179,12 +365,12 @@ func (app *application) processBuyOrder(stockID, orderID, userID int64, currentP
 				slog.String("msg", err.Error()),
 				slog.String("state", "get user stock balance record"),
 			)
-			return
+			return err
 		}
 	}
 
 	// update stock balance
-	stockBalance.Quantity += order.Quantity
+	stockBalance.Quantity += match.Quantity
 	err = txModels.UserStockBalance.Update(stockBalance)
 	if err != nil {
 		app.errorLogger.Error(
@@ -194,16 +380,18 @@ func (app *application) processBuyOrder(stockID, orderID, userID int64, currentP
 			slog.String("msg", err.Error()),
 			slog.String("state", "update user stock balance record"),
 		)
-		return
+		return err
 	}
 
 	// create trade record
 	trade := data.Trade{
-		UserID:     order.UserID,
-		OrderID:    order.ID,
-		Quantity:   order.Quantity,
-		Price:      currentPrice,
-		ExecutedAt: currentTime,
+		UserID:      order.UserID,
+		OrderID:     order.ID,
+		BuyOrderID:  match.Buy.OrderID,
+		SellOrderID: match.Sell.OrderID,
+		Quantity:    match.Quantity,
+		Price:       match.Price,
+		ExecutedAt:  match.ExecutedAt,
 	}
 
 	err = txModels.Trade.Insert(trade)
@@ -215,27 +403,62 @@ func (app *application) processBuyOrder(stockID, orderID, userID int64, currentP
 			slog.String("msg", err.Error()),
 			slog.String("state", "insert trade record"),
 		)
-		return
+		return err
 	}
+	// one trade of the match goes on the public tape and into the candles, the one of the buyer that is settled first
+	err = txModels.Candle.Apply(stockID, match.Price, match.Quantity, match.ExecutedAt)
+	if err != nil {
+		app.errorLogger.Error(
+			"error Apply",
+			slog.Int64("consumer_stock_id", stockID),
+			slog.Int64("order_id", orderID),
+			slog.String("msg", err.Error()),
+			slog.String("state", "update candles"),
+		)
+		return err
+	}
+	changes.onCommit(func() {
+		app.market.PublishTrade(stockID, marketTrade(match))
+	})
 
-	tx.Commit()
-}
+	// the cash for the fill was reserved at the order price, the difference to the trade price is refunded
+	reserved := order.Price.Mul(match.Quantity)
+	err = app.recordUserEvent(txModels, fillEventType(order), order, data.UserEventData{
+		Fill:     newFill(match),
+		Wallet:   &data.WalletDelta{Balance: reserved - match.Price.Mul(match.Quantity), Reserved: -reserved},
+		Position: &data.PositionDelta{StockID: stockID, Quantity: match.Quantity},
+	}, changes)
+	if err != nil {
+		app.errorLogger.Error(
+			"error recordUserEvent",
+			slog.Int64("consumer_stock_id", stockID),
+			slog.Int64("order_id", orderID),
+			slog.String("msg", err.Error()),
+			slog.String("state", "record fill event"),
+		)
+		return err
+	}
 
-func (app *application) processSellOrder(stockID, orderID, userID int64, currentPrice float64, currentTime time.Time) {
-	// begin transaction
-	tx, err := app.models.DBHandler.Begin()
-	defer tx.Rollback()
+	err = app.fillGroup(txModels, order, match.Quantity, changes)
 	if err != nil {
 		app.errorLogger.Error(
-			"error Begin",
+			"error fillGroup",
 			slog.Int64("consumer_stock_id", stockID),
 			slog.Int64("order_id", orderID),
 			slog.String("msg", err.Error()),
-			slog.String("state", "begin transaction"),
+			slog.String("state", "update order group"),
 		)
+		return err
 	}
-	txModels := data.NewTxModels(tx)
-	// get order and update status
+
+	return nil
+}
+
+func (app *application) processSellOrder(txModels data.TxModels, stockID int64, match orderbook.Match, changes *bookChanges) error {
+	orderID := match.Sell.OrderID
+	userID := match.Sell.UserID
+
+	// get order and update fill
 	order, err := txModels.Order.GetOrderForUpdate(orderID)
 	if err != nil {
 		app.errorLogger.Error(
@@ -245,10 +468,34 @@ func (app *application) processSellOrder(stockID, orderID, userID int64, current
 			slog.String("msg", err.Error()),
 			slog.String("state", "get order record"),
 		)
-		return
+		return err
+	}
+	settled, err := fillSettled(order, match.Sell, match.Quantity)
+	if err != nil {
+		app.errorLogger.Error(
+			"error fillSettled",
+			slog.Int64("consumer_stock_id", stockID),
+			slog.Int64("order_id", orderID),
+			slog.String("msg", err.Error()),
+			slog.String("state", "check order fill"),
+		)
+		return err
+	}
+	if settled {
+		return nil
+	}
+	order.UpdatedAt = time.Now()
+	err = txModels.Order.UpdateOrderFill(order, match.Quantity)
+	if err != nil {
+		app.errorLogger.Error(
+			"error UpdateOrderFill",
+			slog.Int64("consumer_stock_id", stockID),
+			slog.Int64("order_id", orderID),
+			slog.String("msg", err.Error()),
+			slog.String("state", "update order fill"),
+		)
+		return err
 	}
-	order.UpdatedAt = currentTime
-	txModels.Order.UpdateOrderStatus(order, data.ORDER_STATUS_FILLED)
 
 	// update user's wallet
 	userWallet, err := txModels.UserWallet.GetUserWallet(userID)
@@ -260,11 +507,11 @@ func (app *application) processSellOrder(stockID, orderID, userID int64, current
 			slog.String("msg", err.Error()),
 			slog.String("state", "get user wallet"),
 		)
-		return
+		return err
 	}
 
-	// because currentPrice may higher than order price so using currentPrice to calculate
-	userWallet.Balance += float64(order.Quantity) * currentPrice
+	// trade price may be higher than order price when the buy order was resting, so using trade price to calculate
+	userWallet.Balance += match.Price.Mul(match.Quantity)
 	err = txModels.UserWallet.Update(userWallet)
 	if err != nil {
 		app.errorLogger.Error(
@@ -274,18 +521,20 @@ func (app *application) processSellOrder(stockID, orderID, userID int64, current
 			slog.String("msg", err.Error()),
 			slog.String("state", "update user wallet"),
 		)
-		return
+		return err
 	}
 
 	// no need to update stock balance because it was handled in createOrderHandler
 
 	// create trade record
 	trade := data.Trade{
-		UserID:     order.UserID,
-		OrderID:    order.ID,
-		Quantity:   order.Quantity,
-		Price:      currentPrice,
-		ExecutedAt: currentTime,
+		UserID:      order.UserID,
+		OrderID:     order.ID,
+		BuyOrderID:  match.Buy.OrderID,
+		SellOrderID: match.Sell.OrderID,
+		Quantity:    match.Quantity,
+		Price:       match.Price,
+		ExecutedAt:  match.ExecutedAt,
 	}
 
 	err = txModels.Trade.Insert(trade)
@@ -297,8 +546,36 @@ func (app *application) processSellOrder(stockID, orderID, userID int64, current
 			slog.String("msg", err.Error()),
 			slog.String("state", "insert trade record"),
 		)
-		return
+		return err
 	}
 
-	tx.Commit()
+	err = app.recordUserEvent(txModels, fillEventType(order), order, data.UserEventData{
+		Fill:     newFill(match),
+		Wallet:   &data.WalletDelta{Balance: match.Price.Mul(match.Quantity)},
+		Position: &data.PositionDelta{StockID: stockID, Reserved: -match.Quantity},
+	}, changes)
+	if err != nil {
+		app.errorLogger.Error(
+			"error recordUserEvent",
+			slog.Int64("consumer_stock_id", stockID),
+			slog.Int64("order_id", orderID),
+			slog.String("msg", err.Error()),
+			slog.String("state", "record fill event"),
+		)
+		return err
+	}
+
+	err = app.fillGroup(txModels, order, match.Quantity, changes)
+	if err != nil {
+		app.errorLogger.Error(
+			"error fillGroup",
+			slog.Int64("consumer_stock_id", stockID),
+			slog.Int64("order_id", orderID),
+			slog.String("msg", err.Error()),
+			slog.String("state", "update order group"),
+		)
+		return err
+	}
+
+	return nil
 }
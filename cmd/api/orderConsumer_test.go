@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/maxwellkuo47/tradingEngine/internal/data"
+)
+
+func TestSettlerHoldsBackMatchesOfDeadLetteredOrder(t *testing.T) {
+	ctx := context.Background()
+	app := newTestApp(t)
+	buyer := newTestUser(t, app, data.NewMoney(1000), nil)
+	seller := newTestUser(t, app, data.NewMoney(1000), map[int64]int{1: 10})
+	limit := func(user *data.User, orderType, quantity int, price int64) *data.Order {
+		return placeOrder(t, app, user, orderInput{StockID: 1, Type: orderType, Quantity: quantity, PriceType: data.ORDER_PRICE_TYPE_LIMIT, Price: data.NewMoney(price)})
+	}
+	pop := func() {
+		t.Helper()
+		if match, err := app.orderBook.PopMatch(ctx, 1); err != nil || match == nil {
+			t.Fatalf("PopMatch = %v, %v", match, err)
+		}
+	}
+
+	// the first match of the parked buy order could not be settled
+	parked := limit(buyer, data.ORDER_TYPE_BUY, 2, 10)
+	limit(seller, data.ORDER_TYPE_SELL, 1, 10)
+	deadLetter, err := app.orderBook.PopMatch(ctx, 1)
+	if err != nil || deadLetter == nil {
+		t.Fatalf("PopMatch = %v, %v", deadLetter, err)
+	}
+	if err := app.orderBook.DeadLetter(ctx, 1, *deadLetter, "settlement failed"); err != nil {
+		t.Fatalf("DeadLetter: %v", err)
+	}
+
+	// its next match parks the sell order, whose own next match is held back in turn
+	counterparty := limit(seller, data.ORDER_TYPE_SELL, 2, 10)
+	pop()
+	heldBuy := limit(buyer, data.ORDER_TYPE_BUY, 1, 10)
+	pop()
+
+	// a match of two other orders comes after the held back ones
+	otherBuy := limit(buyer, data.ORDER_TYPE_BUY, 1, 11)
+	otherSell := limit(seller, data.ORDER_TYPE_SELL, 1, 11)
+	pop()
+
+	app.createSettler(1)
+	deadline := time.Now().Add(5 * time.Second)
+	for getOrder(t, app, otherSell.ID, seller.ID).Status != data.ORDER_STATUS_FILLED && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	close(app.done)
+	app.wg.Wait()
+
+	if order := getOrder(t, app, otherBuy.ID, buyer.ID); order.Status != data.ORDER_STATUS_FILLED {
+		t.Errorf("unrelated buy order status = %d, want filled", order.Status)
+	}
+	if order := getOrder(t, app, otherSell.ID, seller.ID); order.Status != data.ORDER_STATUS_FILLED {
+		t.Errorf("unrelated sell order status = %d, want filled", order.Status)
+	}
+	for _, order := range []*data.Order{parked, counterparty, heldBuy} {
+		if settled := getOrder(t, app, order.ID, order.UserID); settled.FilledQuantity != 0 {
+			t.Errorf("order %d filled %d, want its matches held back", order.ID, settled.FilledQuantity)
+		}
+	}
+	pending, err := app.orderBook.Settlements(ctx, 1)
+	if err != nil {
+		t.Fatalf("Settlements: %v", err)
+	}
+	if len(pending) != 2 {
+		t.Errorf("settlement log has %d matches, want the 2 held back matches", len(pending))
+	}
+	deadLetters, err := app.orderBook.DeadLetters(ctx, 1)
+	if err != nil {
+		t.Fatalf("DeadLetters: %v", err)
+	}
+	if len(deadLetters) != 1 {
+		t.Errorf("%d dead letters, want only the first match", len(deadLetters))
+	}
+}
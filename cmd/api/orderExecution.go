@@ -0,0 +1,653 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/maxwellkuo47/tradingEngine/internal/data"
+	"github.com/maxwellkuo47/tradingEngine/internal/orderbook"
+)
+
+var errOrderNotOpen = errors.New("order is not open")
+
+// postOnlyRejection is the reason recorded for a post-only order that the book turned away
+const postOnlyRejection = "post-only order would have taken liquidity from the book"
+
+// submitOrder hands a live order over to the book. Orders that rest are queued,
+// IOC and FOK orders are matched against the book right away and what could not be matched is cancelled.
+// The order has to be committed in db first, the settler may pick up its matches at once.
+func (app *application) submitOrder(order data.Order) error {
+	if order.PostOnly != data.ORDER_POST_ONLY_NONE {
+		// the book may have moved since the order was placed or it fired, a post-only order never takes liquidity
+		err := app.orderBook.AddPassive(context.Background(), newBookOrder(order))
+		if errors.Is(err, orderbook.ErrWouldCross) {
+			_, err = app.rejectOrder(order.ID, postOnlyRejection)
+		}
+		return err
+	}
+	if !order.IsImmediate() {
+		return app.orderBook.Add(context.Background(), newBookOrder(order))
+	}
+
+	allOrNone := order.TimeInForce == data.ORDER_TIF_FOK
+	matches, err := app.orderBook.Take(context.Background(), newBookOrder(order), allOrNone)
+	if err != nil {
+		// nothing was matched, the expiry sweeper closes the order once its grace period is over
+		return err
+	}
+
+	matched := 0
+	for _, match := range matches {
+		matched += match.Quantity
+	}
+	// the last trade sets the stock price, self-trade prevention records are no trades
+	for i := len(matches) - 1; i >= 0; i-- {
+		if matches[i].SelfTrade == nil {
+			app.updateStockPrice(order.StockID, matches[i].Price)
+			break
+		}
+	}
+	if matched == order.RemainingQuantity() {
+		return nil
+	}
+
+	message := "immediate or cancel order cancelled the quantity that could not be matched right away"
+	if allOrNone {
+		message = "fill or kill order could not be filled in full right away"
+	}
+	for _, match := range matches {
+		if match.SelfTrade != nil && match.SelfTrade.Removed(orderbook.Side(order.Type)) > 0 {
+			message = "self-trade prevention cancelled the quantity that was not matched"
+		}
+	}
+	_, err = app.killOrder(order.ID, data.ORDER_EVENT_EXPIRED, message)
+	return err
+}
+
+// bookChanges collects what a transaction does to the order book and the trigger book,
+// the changes are undone when the transaction is not committed and followed up when it is
+type bookChanges struct {
+	undo  []func()
+	after []func()
+}
+
+// onRollback registers fn to undo a change made to the books
+func (c *bookChanges) onRollback(fn func()) {
+	c.undo = append(c.undo, fn)
+}
+
+// onCommit registers fn to run once the transaction is committed
+func (c *bookChanges) onCommit(fn func()) {
+	c.after = append(c.after, fn)
+}
+
+// finish undoes the changes in reverse order or follows them up, depending on whether the transaction was committed
+func (c *bookChanges) finish(committed bool) {
+	if !committed {
+		for i := len(c.undo) - 1; i >= 0; i-- {
+			c.undo[i]()
+		}
+		return
+	}
+	for _, fn := range c.after {
+		fn()
+	}
+}
+
+// killOrder closes an open order together with the open orders of its group, see closeOrder.
+// It returns errOrderNotOpen when the order is no longer open and orderbook.ErrOrderNotFound
+// when it was fully matched but the match is not settled yet.
+func (app *application) killOrder(orderID int64, eventType, message string) (*data.Order, error) {
+	return app.closeGroup(orderID, func(txModels data.TxModels, order *data.Order, changes *bookChanges) error {
+		return app.closeOrder(txModels, order, eventType, message, changes)
+	})
+}
+
+// rejectOrder closes an open order that the order book turned away before it was queued, together with the open orders of its group.
+// It returns errOrderNotOpen when the order is no longer open.
+func (app *application) rejectOrder(orderID int64, message string) (*data.Order, error) {
+	return app.closeGroup(orderID, func(txModels data.TxModels, order *data.Order, changes *bookChanges) error {
+		if !order.IsOpen() {
+			return errOrderNotOpen
+		}
+		return app.closeUnqueued(txModels, order, data.ORDER_EVENT_REJECTED, message, changes)
+	})
+}
+
+// closeGroup locks an order, closes it with closeFn and cancels the open orders of its group in one transaction
+func (app *application) closeGroup(orderID int64, closeFn func(txModels data.TxModels, order *data.Order, changes *bookChanges) error) (*data.Order, error) {
+	tx, err := app.models.DBHandler.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	txModels := data.NewTxModels(tx)
+
+	changes := &bookChanges{}
+	committed := false
+	defer func() { changes.finish(committed) }()
+
+	// lock the order row so the settlement of its matches waits for the cancellation
+	order, err := txModels.Order.GetOrderForUpdate(orderID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = closeFn(txModels, order, changes)
+	if err != nil {
+		return nil, err
+	}
+
+	err = app.closeSiblings(txModels, order, fmt.Sprintf("order %d of the group was closed", order.ID), changes)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	committed = true
+
+	return order, nil
+}
+
+// withdrawOrder closes an order that was committed but could not be handed over to the books, so its reservation
+// is not held until the next startup reconciliation. The hand-over may have reached the book before it failed,
+// the part of the order that was matched is left to its settlement.
+func (app *application) withdrawOrder(orderID int64, message string) error {
+	tx, err := app.models.DBHandler.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	txModels := data.NewTxModels(tx)
+
+	changes := &bookChanges{}
+	committed := false
+	defer func() { changes.finish(committed) }()
+
+	order, err := txModels.Order.GetOrderForUpdate(orderID)
+	if err != nil {
+		return err
+	}
+	if !order.IsOpen() {
+		return nil
+	}
+
+	if order.Status == data.ORDER_STATUS_UNTRIGGERED || order.Status == data.ORDER_STATUS_INACTIVE || order.IsImmediate() {
+		err = app.closeOrder(txModels, order, data.ORDER_EVENT_REJECTED, message, changes)
+		if err != nil {
+			return err
+		}
+	} else {
+		var remaining *orderbook.Order
+		remaining, err = app.orderBook.Cancel(context.Background(), order.StockID, orderbook.Side(order.Type), order.Price, order.ID)
+		switch {
+		case err == nil:
+			changes.onRollback(func() {
+				if err := app.orderBook.Add(context.Background(), *remaining); err != nil {
+					app.errorLogger.Error("error Add", slog.Int64("order_id", remaining.OrderID), slog.String("msg", err.Error()), slog.String("state", "restore order"))
+				}
+			})
+			err = app.retireOrder(txModels, order, remaining.Open(), data.ORDER_EVENT_REJECTED, message, changes)
+		case errors.Is(err, orderbook.ErrOrderNotFound):
+			// never queued, or matched in full and waiting for settlement
+			err = app.closeUnqueued(txModels, order, data.ORDER_EVENT_REJECTED, message, changes)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+	committed = true
+
+	return nil
+}
+
+// killOrders closes the open orders of a user that match the stock and type of filter in one transaction,
+// together with the open orders of their groups. The resting orders leave the book in one round trip.
+// An order that was fully matched but is not settled yet is left to its settlement.
+// It returns the ids of the orders that were closed.
+func (app *application) killOrders(userID int64, filter data.OrderFilter, eventType, message string) ([]int64, error) {
+	tx, err := app.models.DBHandler.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	txModels := data.NewTxModels(tx)
+
+	changes := &bookChanges{}
+	committed := false
+	defer func() { changes.finish(committed) }()
+
+	// lock the orders so the settlement of their matches waits for the cancellation
+	orders, err := txModels.Order.GetOpenForUpdate(userID, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	resting := []orderbook.Order{}
+	for _, order := range orders {
+		if order.Status != data.ORDER_STATUS_UNTRIGGERED && order.Status != data.ORDER_STATUS_INACTIVE && !order.IsImmediate() {
+			resting = append(resting, newBookOrder(*order))
+		}
+	}
+	removed, err := app.orderBook.CancelBatch(context.Background(), resting)
+	if err != nil {
+		return nil, err
+	}
+	remaining := make(map[int64]orderbook.Order, len(removed))
+	restore := []orderbook.Order{}
+	for _, order := range removed {
+		if order != nil {
+			remaining[order.OrderID] = *order
+			restore = append(restore, *order)
+		}
+	}
+	changes.onRollback(func() {
+		// the orders are still open in db, put them back so they can be matched or cancelled again
+		if err := app.orderBook.AddBatch(context.Background(), restore); err != nil {
+			app.errorLogger.Error("error AddBatch", slog.Int64("user_id", userID), slog.String("msg", err.Error()), slog.String("state", "restore orders"))
+		}
+	})
+
+	closed := []*data.Order{}
+	for _, order := range orders {
+		switch order.Status {
+		case data.ORDER_STATUS_UNTRIGGERED, data.ORDER_STATUS_INACTIVE:
+			err = app.closeOrder(txModels, order, eventType, message, changes)
+		default:
+			if order.IsImmediate() {
+				err = app.closeOrder(txModels, order, eventType, message, changes)
+				break
+			}
+			left, ok := remaining[order.ID]
+			if !ok {
+				continue
+			}
+			err = app.retireOrder(txModels, order, left.Open(), eventType, message, changes)
+		}
+		if err != nil {
+			return nil, err
+		}
+		closed = append(closed, order)
+	}
+	// the groups go after the orders, their legs may be among them
+	ids := make([]int64, 0, len(closed))
+	for _, order := range closed {
+		err = app.closeSiblings(txModels, order, fmt.Sprintf("order %d of the group was closed", order.ID), changes)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, order.ID)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	committed = true
+
+	return ids, nil
+}
+
+// closeOrder closes an open order locked by txModels: what is left of it is taken out of the order book or the trigger book,
+// its reservation is refunded, it is marked killed and an event records why.
+// The book changes are undone through changes when the transaction is not committed.
+func (app *application) closeOrder(txModels data.TxModels, order *data.Order, eventType, message string, changes *bookChanges) error {
+	if !order.IsOpen() {
+		return errOrderNotOpen
+	}
+
+	var quantity int
+	switch {
+	case order.Status == data.ORDER_STATUS_INACTIVE:
+		// an exit leg that was never armed is in neither book
+	case order.Status == data.ORDER_STATUS_UNTRIGGERED:
+		// a trigger firing at the same time waits for the row lock and then finds the order killed
+		if app.triggerBook.Remove(order.StockID, order.ID) {
+			trigger := newTrigger(*order)
+			changes.onRollback(func() {
+				if err := app.triggerBook.Add(trigger); err != nil {
+					app.errorLogger.Error("error Add", slog.Int64("order_id", trigger.OrderID), slog.String("msg", err.Error()), slog.String("state", "restore trigger"))
+				}
+			})
+		}
+		quantity = order.Quantity
+	case order.IsImmediate():
+		return app.closeUnqueued(txModels, order, eventType, message, changes)
+	default:
+		// pull the order out of the book first so the matcher can not fill it any more,
+		// matches taken before this point are still settled by the settler
+		remaining, err := app.orderBook.Cancel(context.Background(), order.StockID, orderbook.Side(order.Type), order.Price, order.ID)
+		if err != nil {
+			return err
+		}
+		changes.onRollback(func() {
+			// the order is still open in db, put it back so it can be matched or cancelled again
+			if err := app.orderBook.Add(context.Background(), *remaining); err != nil {
+				app.errorLogger.Error("error Add", slog.Int64("order_id", remaining.OrderID), slog.String("msg", err.Error()), slog.String("state", "restore order"))
+			}
+		})
+		quantity = remaining.Open()
+	}
+
+	return app.retireOrder(txModels, order, quantity, eventType, message, changes)
+}
+
+// closeUnqueued closes an open order that is in neither book, like an IOC or FOK order after its match
+// or a post-only order the book turned away. Everything that was matched of it is either settled
+// or waiting in the settlement log, the rest is retired.
+func (app *application) closeUnqueued(txModels data.TxModels, order *data.Order, eventType, message string, changes *bookChanges) error {
+	unsettled, err := app.unsettledQuantity(order)
+	if err != nil {
+		return err
+	}
+	return app.retireOrder(txModels, order, order.RemainingQuantity()-unsettled, eventType, message, changes)
+}
+
+// retireOrder finishes closing an order that is out of the books already, quantity is what was left of it.
+// The reservation for quantity is refunded, the order is marked killed and an event records why,
+// in the order events and in the stream of the user.
+func (app *application) retireOrder(txModels data.TxModels, order *data.Order, quantity int, eventType, message string, changes *bookChanges) error {
+	// refund what was reserved for the quantity that will never be matched
+	if order.HoldsReservation() {
+		err := app.releaseOrder(txModels, order, quantity)
+		if err != nil {
+			return err
+		}
+	}
+
+	order.UpdatedAt = time.Now()
+	err := txModels.Order.UpdateOrderStatus(order, data.ORDER_STATUS_KILLED)
+	if err != nil {
+		return err
+	}
+
+	event := &data.OrderEvent{
+		OrderID:   order.ID,
+		Type:      eventType,
+		Message:   message,
+		CreatedAt: order.UpdatedAt,
+	}
+	err = txModels.OrderEvent.Insert(event)
+	if err != nil {
+		return err
+	}
+
+	var eventData data.UserEventData
+	if order.HoldsReservation() {
+		eventData = reservationDeltas(order, -quantity)
+	}
+	eventData.Reason = eventType
+	eventData.Message = message
+	return app.recordUserEvent(txModels, data.USER_EVENT_ORDER_CLOSED, order, eventData, changes)
+}
+
+// closeSiblings cancels the open orders in the group of order, nothing is done for an order without a group.
+// A sibling that was fully matched but is not settled yet is left to its settlement.
+func (app *application) closeSiblings(txModels data.TxModels, order *data.Order, message string, changes *bookChanges) error {
+	if order.GroupID == nil {
+		return nil
+	}
+
+	siblings, err := txModels.Order.GetAllForGroup(*order.GroupID)
+	if err != nil {
+		return err
+	}
+	for _, sibling := range siblings {
+		if sibling.ID == order.ID || !sibling.IsOpen() {
+			continue
+		}
+		// lock the sibling and read it again, it may have been filled or closed since
+		locked, err := txModels.Order.GetOrderForUpdate(sibling.ID)
+		if err != nil {
+			return err
+		}
+		err = app.closeOrder(txModels, locked, data.ORDER_EVENT_CANCELLED, message, changes)
+		if err != nil && !errors.Is(err, errOrderNotOpen) && !errors.Is(err, orderbook.ErrOrderNotFound) {
+			return err
+		}
+	}
+	return nil
+}
+
+// unsettledQuantity is how much of an order was matched but is still waiting in the settlement log
+func (app *application) unsettledQuantity(order *data.Order) (int, error) {
+	settlements, err := app.orderBook.Settlements(context.Background(), order.StockID)
+	if err != nil {
+		return 0, err
+	}
+
+	quantity := 0
+	for _, match := range settlements {
+		matched := match.Sell
+		if order.Type == data.ORDER_TYPE_BUY {
+			matched = match.Buy
+		}
+		// fills below the order's filled quantity are settled already
+		if match.SelfTrade == nil && matched.OrderID == order.ID && matched.Filled >= order.FilledQuantity {
+			quantity += match.Quantity
+		}
+	}
+	return quantity, nil
+}
+
+var (
+	errOrderNotAmendable = errors.New("only resting limit and stop limit orders can be amended")
+	errAmendBelowFilled  = errors.New("the new quantity must exceed the quantity that was filled already")
+	errUnsettledFills    = errors.New("the order has fills waiting for settlement, try again shortly")
+	errGroupedQuantity   = errors.New("the quantity of an order in a group can not be amended")
+	errPostOnlyCross     = errors.New("the new price of a post-only order would take liquidity from the book")
+)
+
+// amendOrder changes the price or the quantity of an open limit or stop limit order, nil keeps the current value.
+// A smaller quantity at the same price keeps the order's place in its queue,
+// a new price or a larger quantity moves it to the back of the queue at the new price.
+// The reservation is adjusted in the same transaction and the change is recorded as an order event.
+func (app *application) amendOrder(orderID int64, newPrice *data.Money, newQuantity *int) (*data.Order, error) {
+	tx, err := app.models.DBHandler.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	txModels := data.NewTxModels(tx)
+
+	// lock the order row so the settlement of its matches waits for the amendment
+	order, err := txModels.Order.GetOrderForUpdate(orderID)
+	if err != nil {
+		return nil, err
+	}
+	if order.Status != data.ORDER_STATUS_PENDING && order.Status != data.ORDER_STATUS_PARTIALLY_FILLED && order.Status != data.ORDER_STATUS_UNTRIGGERED {
+		return nil, errOrderNotOpen
+	}
+	if order.PriceType != data.ORDER_PRICE_TYPE_LIMIT && order.PriceType != data.ORDER_PRICE_TYPE_STOP_LIMIT || order.IsImmediate() {
+		return nil, errOrderNotAmendable
+	}
+	// the legs of a group are sized together
+	if order.GroupID != nil && newQuantity != nil && *newQuantity != order.Quantity {
+		return nil, errGroupedQuantity
+	}
+
+	previous := *order
+	price, quantity := order.Price, order.Quantity
+	if newPrice != nil {
+		price = *newPrice
+	}
+	if newQuantity != nil {
+		quantity = *newQuantity
+	}
+	if quantity <= order.FilledQuantity {
+		return nil, errAmendBelowFilled
+	}
+	if price == order.Price && quantity == order.Quantity {
+		return order, nil
+	}
+
+	side := orderbook.Side(order.Type)
+	if order.PostOnly != data.ORDER_POST_ONLY_NONE && order.Status != data.ORDER_STATUS_UNTRIGGERED && price != order.Price {
+		best, err := app.orderBook.Best(context.Background(), order.StockID, side.Opposite())
+		if err != nil {
+			return nil, err
+		}
+		if best != nil && (side == orderbook.Buy && price >= best.Price || side == orderbook.Sell && price <= best.Price) {
+			return nil, errPostOnlyCross
+		}
+	}
+	var requeue *orderbook.Order
+	// puts the book back the way it was when the amendment is not committed
+	changes := &bookChanges{}
+	committed := false
+	defer func() { changes.finish(committed) }()
+	// the shares of the order as it was that are given back and those of the amended order that are reserved
+	released, reserved := 0, 0
+
+	switch {
+	case order.Status == data.ORDER_STATUS_UNTRIGGERED:
+		// not in the order book yet and the trigger does not depend on price or quantity,
+		// a stop-loss leg holds nothing of its own until it fires
+		if !order.HoldsReservation() {
+			order.Price, order.Quantity = price, quantity
+			break
+		}
+		err = app.releaseOrder(txModels, order, order.Quantity)
+		if err != nil {
+			return nil, err
+		}
+		released = order.Quantity
+		order.Price, order.Quantity = price, quantity
+		err = app.reserveOrder(txModels, order, quantity)
+		if err != nil {
+			return nil, err
+		}
+		reserved = quantity
+	case price == order.Price && quantity < order.Quantity:
+		// the reduction is taken from the open quantity in the book, fills waiting for settlement are not touched
+		reduction := order.Quantity - quantity
+		_, err := app.orderBook.Reduce(context.Background(), order.StockID, side, order.Price, order.ID, reduction)
+		if err != nil {
+			if errors.Is(err, orderbook.ErrReduceExceeds) {
+				return nil, errAmendBelowFilled
+			}
+			return nil, err
+		}
+		changes.onRollback(func() {
+			// the place in the queue is lost, but the order is open with its old quantity again
+			remaining, err := app.orderBook.Cancel(context.Background(), order.StockID, side, order.Price, order.ID)
+			if err == nil {
+				remaining.SetOpen(remaining.Open() + reduction)
+				err = app.orderBook.Add(context.Background(), *remaining)
+			}
+			if err != nil {
+				app.errorLogger.Error("error restore", slog.Int64("order_id", order.ID), slog.String("msg", err.Error()), slog.String("state", "restore reduced order"))
+			}
+		})
+		err = app.releaseOrder(txModels, order, reduction)
+		if err != nil {
+			return nil, err
+		}
+		released = reduction
+		order.Quantity = quantity
+	default:
+		// pull the order out of the book first so the matcher can not fill it while it is amended
+		remaining, err := app.orderBook.Cancel(context.Background(), order.StockID, side, order.Price, order.ID)
+		if err != nil {
+			return nil, err
+		}
+		changes.onRollback(func() {
+			if err := app.orderBook.Add(context.Background(), *remaining); err != nil {
+				app.errorLogger.Error("error Add", slog.Int64("order_id", order.ID), slog.String("msg", err.Error()), slog.String("state", "restore order"))
+			}
+		})
+
+		if price != order.Price {
+			// pending fills are settled against the order price, they have to settle at the price they were reserved at
+			unsettled, err := app.unsettledQuantity(order)
+			if err != nil {
+				return nil, err
+			}
+			if unsettled > 0 {
+				return nil, errUnsettledFills
+			}
+		}
+		open := remaining.Open() + quantity - order.Quantity
+		if open <= 0 {
+			return nil, errAmendBelowFilled
+		}
+
+		err = app.releaseOrder(txModels, order, remaining.Open())
+		if err != nil {
+			return nil, err
+		}
+		released = remaining.Open()
+		order.Price, order.Quantity = price, quantity
+		err = app.reserveOrder(txModels, order, open)
+		if err != nil {
+			return nil, err
+		}
+		reserved = open
+
+		amended := amendedBookOrder(*remaining, price, open)
+		requeue = &amended
+	}
+
+	order.UpdatedAt = time.Now()
+	if requeue != nil {
+		order.QueuedAt = order.UpdatedAt
+	}
+	err = txModels.Order.Amend(order)
+	if err != nil {
+		return nil, err
+	}
+
+	event := &data.OrderEvent{
+		OrderID:   order.ID,
+		Type:      data.ORDER_EVENT_AMENDED,
+		Message:   fmt.Sprintf("price %s to %s, quantity %d to %d", previous.Price, order.Price, previous.Quantity, order.Quantity),
+		Price:     order.Price,
+		CreatedAt: order.UpdatedAt,
+	}
+	err = txModels.OrderEvent.Insert(event)
+	if err != nil {
+		return nil, err
+	}
+	err = app.recordUserEvent(txModels, data.USER_EVENT_ORDER_AMENDED, order, amendmentDeltas(&previous, order, released, reserved), changes)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	committed = true
+
+	if requeue != nil {
+		// the amendment is committed, a failure here is repaired by the startup reconciliation
+		if order.PostOnly != data.ORDER_POST_ONLY_NONE {
+			err = app.orderBook.AddPassive(context.Background(), *requeue)
+			if errors.Is(err, orderbook.ErrWouldCross) {
+				var killed *data.Order
+				killed, err = app.rejectOrder(order.ID, postOnlyRejection)
+				if err == nil {
+					order = killed
+				}
+			}
+		} else {
+			err = app.orderBook.Add(context.Background(), *requeue)
+		}
+		if err != nil {
+			app.errorLogger.Error("error Add", slog.Int64("order_id", order.ID), slog.String("msg", err.Error()), slog.String("state", "queue amended order"))
+		}
+	}
+
+	return order, nil
+}
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/maxwellkuo47/tradingEngine/internal/data"
+	"github.com/maxwellkuo47/tradingEngine/internal/orderbook"
+)
+
+// popPrice crosses the book of the stock once and returns the trade price
+func popPrice(t *testing.T, book orderbook.OrderBook, stockID int64) data.Money {
+	t.Helper()
+	match, err := book.PopMatch(context.Background(), stockID)
+	if err != nil {
+		t.Fatalf("PopMatch: %v", err)
+	}
+	if match == nil {
+		t.Fatal("the book is not crossed")
+	}
+	return match.Price
+}
+
+func TestSubmitFiredStopLimitTradesAtRestingPrice(t *testing.T) {
+	app := &application{orderBook: orderbook.NewMemory()}
+
+	// the stop limit buy was placed before the sell, so its id is older, but it only reaches the book when it fires
+	triggeredAt := time.Now()
+	stop := data.Order{
+		ID:           3,
+		UserID:       1,
+		StockID:      1,
+		Type:         data.ORDER_TYPE_BUY,
+		PriceType:    data.ORDER_PRICE_TYPE_STOP_LIMIT,
+		Quantity:     1,
+		Price:        data.NewMoney(12),
+		TriggerPrice: data.NewMoney(10),
+		TriggeredAt:  &triggeredAt,
+		Status:       data.ORDER_STATUS_PENDING,
+	}
+	sell := data.Order{
+		ID:        5,
+		UserID:    2,
+		StockID:   1,
+		Type:      data.ORDER_TYPE_SELL,
+		PriceType: data.ORDER_PRICE_TYPE_LIMIT,
+		Quantity:  1,
+		Price:     data.NewMoney(11),
+		Status:    data.ORDER_STATUS_PENDING,
+	}
+
+	if err := app.submitOrder(sell); err != nil {
+		t.Fatalf("submitOrder(sell): %v", err)
+	}
+	if err := app.submitOrder(stop); err != nil {
+		t.Fatalf("submitOrder(stop): %v", err)
+	}
+
+	if price := popPrice(t, app.orderBook, 1); price != sell.Price {
+		t.Errorf("trade price = %s, want the resting sell price %s", price, sell.Price)
+	}
+}
+
+func TestAmendedOrderAcrossSpreadTradesAtRestingPrice(t *testing.T) {
+	ctx := context.Background()
+	book := orderbook.NewMemory()
+
+	// the buy order rests first, the sell order arrives later with a newer id
+	buy := orderbook.Order{OrderID: 1, UserID: 1, StockID: 1, Side: orderbook.Buy, Price: data.NewMoney(10), Quantity: 2}
+	sell := orderbook.Order{OrderID: 2, UserID: 2, StockID: 1, Side: orderbook.Sell, Price: data.NewMoney(11), Quantity: 2}
+	for _, order := range []orderbook.Order{buy, sell} {
+		if err := book.Add(ctx, order); err != nil {
+			t.Fatalf("Add(%d): %v", order.OrderID, err)
+		}
+	}
+
+	// amending the buy order to 12 takes it out of the book and queues it again like amendOrder does
+	remaining, err := book.Cancel(ctx, 1, orderbook.Buy, buy.Price, buy.OrderID)
+	if err != nil {
+		t.Fatalf("Cancel: %v", err)
+	}
+	if err := book.Add(ctx, amendedBookOrder(*remaining, data.NewMoney(12), remaining.Open())); err != nil {
+		t.Fatalf("Add amended: %v", err)
+	}
+
+	if price := popPrice(t, book, 1); price != sell.Price {
+		t.Errorf("trade price = %s, want the resting sell price %s", price, sell.Price)
+	}
+}
+
+// crossingBook turns every post-only order away while cross is set, as if the book moved after the order was checked
+type crossingBook struct {
+	orderbook.OrderBook
+	cross bool
+}
+
+func (b *crossingBook) AddPassive(ctx context.Context, order orderbook.Order) error {
+	if b.cross {
+		return orderbook.ErrWouldCross
+	}
+	return b.OrderBook.AddPassive(ctx, order)
+}
+
+// errBookDown is what failingBook answers
+var errBookDown = errors.New("order book is down")
+
+// failingBook can not queue any order, as if the backend went away after the orders were committed
+type failingBook struct {
+	orderbook.OrderBook
+}
+
+func (b failingBook) Add(ctx context.Context, order orderbook.Order) error {
+	return errBookDown
+}
+
+func (b failingBook) AddBatch(ctx context.Context, orders []orderbook.Order) error {
+	return errBookDown
+}
+
+// postOnlyBuy is a post-only limit buy order of 2 shares at 10
+var postOnlyBuy = orderInput{
+	StockID:   1,
+	Type:      data.ORDER_TYPE_BUY,
+	Quantity:  2,
+	PriceType: data.ORDER_PRICE_TYPE_LIMIT,
+	Price:     data.NewMoney(10),
+	PostOnly:  data.ORDER_POST_ONLY_REJECT,
+}
+
+// checkRejected checks that a post-only order turned away by the book is closed, refunded and in no book
+func checkRejected(t *testing.T, app *application, order *data.Order, cash data.Money) {
+	t.Helper()
+	if order.Status != data.ORDER_STATUS_KILLED {
+		t.Errorf("order status = %d, want killed", order.Status)
+	}
+	if stored := getOrder(t, app, order.ID, order.UserID); stored.Status != data.ORDER_STATUS_KILLED {
+		t.Errorf("stored order status = %d, want killed", stored.Status)
+	}
+	if !hasOrderEvent(t, app, order.ID, data.ORDER_EVENT_REJECTED) {
+		t.Errorf("no %s event for order %d", data.ORDER_EVENT_REJECTED, order.ID)
+	}
+	if balance := walletBalance(t, app, order.UserID); balance != cash {
+		t.Errorf("wallet balance = %s, want the reservation refunded to %s", balance, cash)
+	}
+	snapshot, err := app.orderBook.Snapshot(context.Background(), order.StockID)
+	if err != nil {
+		t.Fatalf("Snapshot: %v", err)
+	}
+	if len(snapshot.Bids)+len(snapshot.Asks) != 0 || app.triggerBook.Len(order.StockID) != 0 {
+		t.Errorf("order is still in a book")
+	}
+}
+
+func TestPostOnlyCrossingOnCreateIsRejected(t *testing.T) {
+	app := newTestApp(t)
+	app.orderBook = &crossingBook{OrderBook: app.orderBook, cross: true}
+	cash := data.NewMoney(1000)
+	user := newTestUser(t, app, cash, nil)
+
+	order := placeOrder(t, app, user, postOnlyBuy)
+	checkRejected(t, app, order, cash)
+}
+
+func TestPostOnlyCrossingWhenStopFiresIsRejected(t *testing.T) {
+	app := newTestApp(t)
+	app.orderBook = &crossingBook{OrderBook: app.orderBook, cross: true}
+	cash := data.NewMoney(1000)
+	user := newTestUser(t, app, cash, nil)
+
+	input := postOnlyBuy
+	input.PriceType = data.ORDER_PRICE_TYPE_STOP_LIMIT
+	input.TriggerPrice = data.NewMoney(9)
+	stop := placeOrder(t, app, user, input)
+	if stop.Status != data.ORDER_STATUS_UNTRIGGERED {
+		t.Fatalf("stop order status = %d, want untriggered", stop.Status)
+	}
+
+	fired := app.triggerBook.Fire(1, input.TriggerPrice)
+	if len(fired) != 1 {
+		t.Fatalf("%d triggers fired, want 1", len(fired))
+	}
+	if err := app.fireStop(fired[0], input.TriggerPrice); err != nil {
+		t.Fatalf("fireStop: %v", err)
+	}
+	checkRejected(t, app, getOrder(t, app, stop.ID, user.ID), cash)
+}
+
+func TestPostOnlyCrossingWhenAmendedIsRejected(t *testing.T) {
+	app := newTestApp(t)
+	book := &crossingBook{OrderBook: app.orderBook}
+	app.orderBook = book
+	cash := data.NewMoney(1000)
+	user := newTestUser(t, app, cash, nil)
+
+	order := placeOrder(t, app, user, postOnlyBuy)
+	if order.Status != data.ORDER_STATUS_PENDING {
+		t.Fatalf("order status = %d, want pending", order.Status)
+	}
+
+	// the new price moves the order to the back of its queue, where the book turns it away
+	book.cross = true
+	price := data.NewMoney(11)
+	amended, err := app.amendOrder(order.ID, &price, nil)
+	if err != nil {
+		t.Fatalf("amendOrder: %v", err)
+	}
+	checkRejected(t, app, amended, cash)
+}
+
+func TestPostOnlyCrossingInBatchIsRejected(t *testing.T) {
+	app := newTestApp(t)
+	app.orderBook = &crossingBook{OrderBook: app.orderBook, cross: true}
+	cash := data.NewMoney(1000)
+	user := newTestUser(t, app, cash, nil)
+
+	w := serveAs(t, app, user, app.orderBatchCreateHandler, map[string]any{"orders": []orderInput{postOnlyBuy}})
+	if w.Code != http.StatusOK {
+		t.Fatalf("orderBatchCreateHandler = %d: %s", w.Code, w.Body)
+	}
+	var response struct {
+		Results []batchOrderResult `json:"results"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Fatalf("decode results: %v", err)
+	}
+	if len(response.Results) != 1 || response.Results[0].Order == nil {
+		t.Fatalf("results = %+v, want the placed order", response.Results)
+	}
+	checkRejected(t, app, response.Results[0].Order, cash)
+}
+
+func TestBatchOrdersNotHandedOverAreWithdrawn(t *testing.T) {
+	app := newTestApp(t)
+	app.orderBook = failingBook{OrderBook: app.orderBook}
+	user := newTestUser(t, app, data.NewMoney(1000), nil)
+
+	resting := orderInput{StockID: 1, Type: data.ORDER_TYPE_BUY, Quantity: 2, PriceType: data.ORDER_PRICE_TYPE_LIMIT, Price: data.NewMoney(10)}
+	stop := orderInput{StockID: 1, Type: data.ORDER_TYPE_BUY, Quantity: 1, PriceType: data.ORDER_PRICE_TYPE_STOP_LIMIT, Price: data.NewMoney(12), TriggerPrice: data.NewMoney(11)}
+	w := serveAs(t, app, user, app.orderBatchCreateHandler, map[string]any{"orders": []orderInput{resting, stop}})
+	if w.Code != http.StatusOK {
+		t.Fatalf("orderBatchCreateHandler = %d: %s", w.Code, w.Body)
+	}
+	var response struct {
+		Results []batchOrderResult `json:"results"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Fatalf("decode results: %v", err)
+	}
+	if len(response.Results) != 2 {
+		t.Fatalf("results = %+v, want one per order", response.Results)
+	}
+
+	// the resting order never reached the book and is withdrawn, the stop order is in the trigger book
+	withdrawn := response.Results[0]
+	if withdrawn.Status != batchOrderRejected || withdrawn.Errors["order"] == "" || withdrawn.Order == nil {
+		t.Fatalf("result of the resting order = %+v, want it rejected", withdrawn)
+	}
+	if withdrawn.Order.Status != data.ORDER_STATUS_KILLED {
+		t.Errorf("resting order status = %d, want killed", withdrawn.Order.Status)
+	}
+	if !hasOrderEvent(t, app, withdrawn.Order.ID, data.ORDER_EVENT_REJECTED) {
+		t.Errorf("no %s event for the resting order", data.ORDER_EVENT_REJECTED)
+	}
+	if response.Results[1].Status != batchOrderCreated {
+		t.Errorf("result of the stop order = %+v, want it created", response.Results[1])
+	}
+	if balance := walletBalance(t, app, user.ID); balance != data.NewMoney(1000-12) {
+		t.Errorf("wallet balance = %s, want only the stop order reserved", balance)
+	}
+	if app.triggerBook.Len(1) != 1 {
+		t.Errorf("trigger book holds %d triggers, want the stop order", app.triggerBook.Len(1))
+	}
+}
+
+func TestAmendOrderStreamsReservationChange(t *testing.T) {
+	app := newTestApp(t)
+	user := newTestUser(t, app, data.NewMoney(1000), nil)
+	order := placeOrder(t, app, user, orderInput{StockID: 1, Type: data.ORDER_TYPE_BUY, Quantity: 2, PriceType: data.ORDER_PRICE_TYPE_LIMIT, Price: data.NewMoney(10)})
+
+	// 2 shares reserved at 10 are given back and 3 shares are reserved at 9
+	price, quantity := data.NewMoney(9), 3
+	if _, err := app.amendOrder(order.ID, &price, &quantity); err != nil {
+		t.Fatalf("amendOrder: %v", err)
+	}
+
+	events, err := app.models.UserEvent.GetAfter(user.ID, data.UserEventCursor{XactID: "0"}, 100)
+	if err != nil {
+		t.Fatalf("GetAfter: %v", err)
+	}
+	var amended *data.UserEventData
+	for _, event := range events {
+		if event.Type != data.USER_EVENT_ORDER_AMENDED {
+			continue
+		}
+		amended = &data.UserEventData{}
+		if err := json.Unmarshal(event.Data, amended); err != nil {
+			t.Fatalf("decode event data: %v", err)
+		}
+	}
+	if amended == nil {
+		t.Fatalf("no %s event in %d events", data.USER_EVENT_ORDER_AMENDED, len(events))
+	}
+	want := data.WalletDelta{Balance: data.NewMoney(-7), Reserved: data.NewMoney(7)}
+	if amended.Wallet == nil || *amended.Wallet != want {
+		t.Errorf("wallet delta = %+v, want %+v", amended.Wallet, want)
+	}
+	if amended.Order == nil || amended.Order.Price != price || amended.Order.Quantity != quantity {
+		t.Errorf("event order = %+v, want the amended order", amended.Order)
+	}
+	if balance := walletBalance(t, app, user.ID); balance != data.NewMoney(1000-27) {
+		t.Errorf("wallet balance = %s, want %s", balance, data.NewMoney(1000-27))
+	}
+}
@@ -0,0 +1,417 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/maxwellkuo47/tradingEngine/internal/data"
+	"github.com/maxwellkuo47/tradingEngine/internal/orderbook"
+	"github.com/maxwellkuo47/tradingEngine/internal/triggerbook"
+	"github.com/maxwellkuo47/tradingEngine/internal/validator"
+)
+
+// fillGroup applies a settled fill of quantity shares of order to the rest of its group:
+// a bracket entry that is filled in full arms its exit legs, the first fill of an exit leg cancels the other one
+func (app *application) fillGroup(txModels data.TxModels, order *data.Order, quantity int, changes *bookChanges) error {
+	if order.GroupID == nil {
+		return nil
+	}
+
+	switch order.Leg {
+	case data.ORDER_LEG_ENTRY:
+		if order.Status != data.ORDER_STATUS_FILLED {
+			return nil
+		}
+		return app.armExits(txModels, order, changes)
+	case data.ORDER_LEG_TAKE_PROFIT, data.ORDER_LEG_STOP_LOSS:
+		if order.FilledQuantity != quantity {
+			return nil
+		}
+		return app.closeSiblings(txModels, order, fmt.Sprintf("order %d of the group was filled", order.ID), changes)
+	default:
+		return nil
+	}
+}
+
+// armExits makes the exit legs of a filled bracket entry live: the take-profit leg reserves and goes to the order book,
+// the stop-loss leg goes to the trigger book covered by that reservation.
+// Both exit legs are killed when the take-profit leg can not be reserved, for lack of balance
+// or because a reduce-only leg would exceed the position.
+func (app *application) armExits(txModels data.TxModels, entry *data.Order, changes *bookChanges) error {
+	legs, err := txModels.Order.GetAllForGroup(*entry.GroupID)
+	if err != nil {
+		return err
+	}
+
+	exits := []*data.Order{}
+	for _, leg := range legs {
+		if leg.Status != data.ORDER_STATUS_INACTIVE {
+			continue
+		}
+		locked, err := txModels.Order.GetOrderForUpdate(leg.ID)
+		if err != nil {
+			return err
+		}
+		if locked.Status == data.ORDER_STATUS_INACTIVE {
+			exits = append(exits, locked)
+		}
+	}
+
+	for _, exit := range exits {
+		if exit.Leg != data.ORDER_LEG_TAKE_PROFIT {
+			continue
+		}
+		err := app.reserveOrder(txModels, exit, exit.Quantity)
+		if errors.Is(err, errInsufficientBalance) || errors.Is(err, errReduceOnlyExceeds) {
+			message := "not enough balance to arm the exit legs"
+			if errors.Is(err, errReduceOnlyExceeds) {
+				message = "the reduce-only take-profit leg would exceed the position net of the other open sell orders"
+			}
+			for _, exit := range exits {
+				err := app.closeOrder(txModels, exit, data.ORDER_EVENT_CANCELLED, message, changes)
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	now := time.Now()
+	for _, exit := range exits {
+		status := data.ORDER_STATUS_PENDING
+		if exit.IsStop() {
+			status = data.ORDER_STATUS_UNTRIGGERED
+		}
+		exit.UpdatedAt = now
+		if status == data.ORDER_STATUS_PENDING {
+			exit.QueuedAt = now
+		}
+		if exit.IsTrailing() {
+			// the trail starts from the price the leg is armed at, not the one the group was placed at
+			if currentPrice := app.currentPrice(exit.StockID); currentPrice > 0 {
+				exit.WaterMark = currentPrice
+				moveTrigger(exit, triggerbook.TrailPrice(triggerbook.Direction(exit.TriggerDirection), currentPrice, exit.TrailAmount, exit.TrailBps))
+				err := txModels.Order.Trail(exit)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		err := txModels.Order.UpdateOrderStatus(exit, status)
+		if err != nil {
+			return err
+		}
+
+		event := &data.OrderEvent{
+			OrderID:   exit.ID,
+			Type:      data.ORDER_EVENT_ARMED,
+			Message:   fmt.Sprintf("armed by the fill of entry order %d", entry.ID),
+			CreatedAt: now,
+		}
+		err = txModels.OrderEvent.Insert(event)
+		if err != nil {
+			return err
+		}
+
+		armed := *exit
+		changes.onCommit(func() {
+			// the leg is live in db now, a failure here is repaired by the startup reconciliation
+			var err error
+			if armed.Status == data.ORDER_STATUS_UNTRIGGERED {
+				err = app.triggerBook.Add(newTrigger(armed))
+			} else {
+				err = app.submitOrder(armed)
+			}
+			if err != nil {
+				app.errorLogger.Error("error arm", slog.Int64("order_id", armed.ID), slog.String("msg", err.Error()), slog.String("state", "arm exit leg"))
+			}
+		})
+	}
+
+	return nil
+}
+
+// fireStopLoss lets a stop-loss leg that just fired take over from the take-profit leg: the take-profit leg is cancelled
+// and refunded, then the stop-loss leg reserves for itself. When that reservation fails the stop-loss leg is killed
+// as well and false is returned, otherwise the leg is ready to be submitted once the transaction is committed.
+func (app *application) fireStopLoss(txModels data.TxModels, order *data.Order, changes *bookChanges) (bool, error) {
+	err := app.closeSiblings(txModels, order, fmt.Sprintf("order %d of the group was triggered", order.ID), changes)
+	if err != nil {
+		return false, err
+	}
+
+	err = app.reserveOrder(txModels, order, order.Quantity)
+	if !errors.Is(err, errInsufficientBalance) && !errors.Is(err, errReduceOnlyExceeds) {
+		return err == nil, err
+	}
+	message := "not enough balance to cover the stop-loss leg once it fired"
+	if errors.Is(err, errReduceOnlyExceeds) {
+		message = "the reduce-only stop-loss leg would exceed the position net of the other open sell orders once it fired"
+	}
+
+	// not in any book yet, there is nothing to take out or refund
+	order.UpdatedAt = time.Now()
+	err = txModels.Order.UpdateOrderStatus(order, data.ORDER_STATUS_KILLED)
+	if err != nil {
+		return false, err
+	}
+	event := &data.OrderEvent{
+		OrderID:   order.ID,
+		Type:      data.ORDER_EVENT_CANCELLED,
+		Message:   message,
+		CreatedAt: order.UpdatedAt,
+	}
+	return false, txModels.OrderEvent.Insert(event)
+}
+
+// newLeg builds a leg of an order group with newOrder, its validation errors are added to v under the name of the leg
+func (app *application) newLeg(v *validator.Validator, user *data.User, name string, input orderInput, leg int) (*data.Order, error) {
+	legValidator := validator.New()
+	order, err := app.newOrder(legValidator, user, input, leg)
+	for key, message := range legValidator.Errors {
+		v.AddError(name+"."+key, message)
+	}
+	return &order, err
+}
+
+// orderGroupForUser reads a group of the user with its orders
+func (app *application) orderGroupForUser(groupID, userID int64) (*data.OrderGroup, []*data.Order, error) {
+	group, err := app.models.OrderGroup.GetForUser(groupID, userID)
+	if err != nil {
+		return nil, nil, err
+	}
+	orders, err := app.models.Order.GetAllForGroup(group.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+	group.SetOrders(orders)
+	return group, orders, nil
+}
+
+func (app *application) orderGroupCreateHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Type       int         `json:"type"`
+		StockID    int64       `json:"stock_id"`
+		Entry      *orderInput `json:"entry"`
+		TakeProfit orderInput  `json:"take_profit"`
+		StopLoss   orderInput  `json:"stop_loss"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badReqResp(w, r, err)
+		return
+	}
+
+	if !app.stockExists(w, r, input.StockID) {
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	// every leg trades the stock of the group
+	v := validator.New()
+	var entry *data.Order
+	if input.Entry != nil {
+		input.Entry.StockID = input.StockID
+		entry, err = app.newLeg(v, user, "entry", *input.Entry, data.ORDER_LEG_ENTRY)
+		if err != nil {
+			app.serverErrResp(w, r, err)
+			return
+		}
+	}
+	input.TakeProfit.StockID = input.StockID
+	takeProfit, err := app.newLeg(v, user, "take_profit", input.TakeProfit, data.ORDER_LEG_TAKE_PROFIT)
+	if err != nil {
+		app.serverErrResp(w, r, err)
+		return
+	}
+	input.StopLoss.StockID = input.StockID
+	stopLoss, err := app.newLeg(v, user, "stop_loss", input.StopLoss, data.ORDER_LEG_STOP_LOSS)
+	if err != nil {
+		app.serverErrResp(w, r, err)
+		return
+	}
+
+	if data.ValidateOrderGroup(v, input.Type, entry, takeProfit, stopLoss); !v.Valid() {
+		app.failedValidationResp(w, r, v.Errors)
+		return
+	}
+
+	legs := []*data.Order{takeProfit, stopLoss}
+	if input.Type == data.ORDER_GROUP_BRACKET {
+		// the exit legs wait for the entry to fill, they hold nothing until then
+		takeProfit.Status = data.ORDER_STATUS_INACTIVE
+		stopLoss.Status = data.ORDER_STATUS_INACTIVE
+		legs = append([]*data.Order{entry}, legs...)
+	}
+
+	group := &data.OrderGroup{
+		UserID:  user.ID,
+		StockID: input.StockID,
+		Type:    input.Type,
+	}
+
+	tx, err := app.models.DBHandler.Begin()
+	if err != nil {
+		app.serverErrResp(w, r, err)
+		return
+	}
+	defer tx.Rollback()
+	txModels := data.NewTxModels(tx)
+
+	err = txModels.OrderGroup.Insert(group)
+	if err != nil {
+		app.serverErrResp(w, r, err)
+		return
+	}
+
+	for _, leg := range legs {
+		leg.GroupID = &group.ID
+		// the stop-loss leg is covered by the take-profit leg, only one of them can be filled
+		if leg.HoldsReservation() {
+			err = app.reserveOrder(txModels, leg, leg.Quantity)
+			if err != nil {
+				app.reservationErrResp(w, r, err)
+				return
+			}
+		}
+		err = txModels.Order.Insert(leg)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrDuplicateClientOrderID):
+				v.AddError("client_order_id", "every leg needs a client_order_id that was not used before")
+				app.failedValidationResp(w, r, v.Errors)
+			default:
+				app.serverErrResp(w, r, err)
+			}
+			return
+		}
+		var eventData data.UserEventData
+		if leg.HoldsReservation() {
+			eventData = reservationDeltas(leg, leg.Quantity)
+		}
+		err = app.recordUserEvent(txModels, data.USER_EVENT_ORDER_ACCEPTED, leg, eventData, nil)
+		if err != nil {
+			app.serverErrResp(w, r, err)
+			return
+		}
+	}
+
+	// the legs have to be visible in db before they reach the books, the matcher may settle them right away
+	err = tx.Commit()
+	if err != nil {
+		app.serverErrResp(w, r, err)
+		return
+	}
+	app.userStreams.notify(user.ID)
+
+	for i, leg := range legs {
+		switch leg.Status {
+		case data.ORDER_STATUS_UNTRIGGERED:
+			err = app.triggerBook.Add(newTrigger(*leg))
+		case data.ORDER_STATUS_PENDING:
+			err = app.submitOrder(*leg)
+		}
+		if err != nil {
+			// the legs are committed, give back what the failing leg and the ones after it hold
+			// instead of leaving them to the startup reconciliation
+			for _, leg := range legs[i:] {
+				if withdrawErr := app.withdrawOrder(leg.ID, "the order could not be handed over to the order book"); withdrawErr != nil {
+					app.errorLogger.Error("error withdrawOrder", slog.Int64("order_id", leg.ID), slog.String("msg", withdrawErr.Error()), slog.String("state", "withdraw order group leg"))
+				}
+			}
+			app.serverErrResp(w, r, err)
+			return
+		}
+	}
+
+	// an immediate entry is matched by now and may have armed its exit legs
+	created, orders, err := app.orderGroupForUser(group.ID, user.ID)
+	if err != nil {
+		app.serverErrResp(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"message": "order group create successfully", "order_group": created, "orders": orders}, nil)
+	if err != nil {
+		app.serverErrResp(w, r, err)
+	}
+}
+
+func (app *application) orderGroupShowHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResp(w, r)
+		return
+	}
+
+	user := app.contextGetUser(r)
+	group, _, err := app.orderGroupForUser(id, user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResp(w, r)
+		default:
+			app.serverErrResp(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"order_group": group}, nil)
+	if err != nil {
+		app.serverErrResp(w, r, err)
+	}
+}
+
+func (app *application) orderGroupCancelHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResp(w, r)
+		return
+	}
+
+	// do not leak other users' groups
+	user := app.contextGetUser(r)
+	group, orders, err := app.orderGroupForUser(id, user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResp(w, r)
+		default:
+			app.serverErrResp(w, r, err)
+		}
+		return
+	}
+	if group.Status != data.ORDER_GROUP_STATUS_ACTIVE {
+		app.orderNotCancelableResp(w, r)
+		return
+	}
+
+	// killing one leg closes the others with it, the rest is only tried when a leg closed meanwhile
+	for _, order := range orders {
+		if !order.IsOpen() {
+			continue
+		}
+		_, err = app.killOrder(order.ID, data.ORDER_EVENT_CANCELLED, "order group cancelled by the user")
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, errOrderNotOpen) && !errors.Is(err, orderbook.ErrOrderNotFound) {
+			app.reservationErrResp(w, r, err)
+			return
+		}
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "order group cancelled successfully"}, nil)
+	if err != nil {
+		app.serverErrResp(w, r, err)
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/maxwellkuo47/tradingEngine/internal/data"
+)
+
+// bracketInput is a bracket buying 2 shares at entryPrice with a reduce-only take-profit leg at 12 and a stop-loss leg at 8
+func bracketInput(entryPrice data.Money) map[string]any {
+	return map[string]any{
+		"type":     data.ORDER_GROUP_BRACKET,
+		"stock_id": 1,
+		"entry": orderInput{
+			Type:      data.ORDER_TYPE_BUY,
+			Quantity:  2,
+			PriceType: data.ORDER_PRICE_TYPE_LIMIT,
+			Price:     entryPrice,
+		},
+		"take_profit": orderInput{
+			Type:       data.ORDER_TYPE_SELL,
+			Quantity:   2,
+			PriceType:  data.ORDER_PRICE_TYPE_LIMIT,
+			Price:      data.NewMoney(12),
+			ReduceOnly: true,
+		},
+		"stop_loss": orderInput{
+			Type:         data.ORDER_TYPE_SELL,
+			Quantity:     2,
+			PriceType:    data.ORDER_PRICE_TYPE_STOP_LIMIT,
+			Price:        data.NewMoney(8),
+			TriggerPrice: data.NewMoney(9),
+		},
+	}
+}
+
+// placeGroup places an order group of user through orderGroupCreateHandler and returns its orders by leg
+func placeGroup(t *testing.T, app *application, user *data.User, input map[string]any) map[int]*data.Order {
+	t.Helper()
+	w := serveAs(t, app, user, app.orderGroupCreateHandler, input)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("orderGroupCreateHandler = %d: %s", w.Code, w.Body)
+	}
+	var response struct {
+		Orders []*data.Order `json:"orders"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Fatalf("decode orders: %v", err)
+	}
+	legs := make(map[int]*data.Order, len(response.Orders))
+	for _, order := range response.Orders {
+		legs[order.Leg] = order
+	}
+	return legs
+}
+
+func TestReduceOnlyTakeProfitIsArmed(t *testing.T) {
+	app := newTestApp(t)
+	buyer := newTestUser(t, app, data.NewMoney(1000), nil)
+	seller := newTestUser(t, app, data.NewMoney(1000), map[int64]int{1: 2})
+
+	legs := placeGroup(t, app, buyer, bracketInput(data.NewMoney(10)))
+	placeOrder(t, app, seller, orderInput{StockID: 1, Type: data.ORDER_TYPE_SELL, Quantity: 2, PriceType: data.ORDER_PRICE_TYPE_LIMIT, Price: data.NewMoney(10)})
+
+	// the fill of the entry arms the exits, the stop-loss leg of the same group does not count against the take-profit leg
+	settleNext(t, app, 1)
+
+	takeProfit := getOrder(t, app, legs[data.ORDER_LEG_TAKE_PROFIT].ID, buyer.ID)
+	if takeProfit.Status != data.ORDER_STATUS_PENDING {
+		t.Errorf("take-profit status = %d, want pending", takeProfit.Status)
+	}
+	stopLoss := getOrder(t, app, legs[data.ORDER_LEG_STOP_LOSS].ID, buyer.ID)
+	if stopLoss.Status != data.ORDER_STATUS_UNTRIGGERED {
+		t.Errorf("stop-loss status = %d, want untriggered", stopLoss.Status)
+	}
+	if shares := stockBalance(t, app, buyer.ID, 1); shares != 0 {
+		t.Errorf("available shares = %d, want both reserved by the take-profit leg", shares)
+	}
+	snapshot, err := app.orderBook.Snapshot(context.Background(), 1)
+	if err != nil {
+		t.Fatalf("Snapshot: %v", err)
+	}
+	if len(snapshot.Asks) != 1 || snapshot.Asks[0].OrderID != takeProfit.ID {
+		t.Errorf("asks = %+v, want the take-profit leg", snapshot.Asks)
+	}
+	if app.triggerBook.Len(1) != 1 {
+		t.Errorf("trigger book holds %d triggers, want the stop-loss leg", app.triggerBook.Len(1))
+	}
+}
+
+func TestReduceOnlyTakeProfitOverPositionCancelsExits(t *testing.T) {
+	app := newTestApp(t)
+	buyer := newTestUser(t, app, data.NewMoney(1000), nil)
+	seller := newTestUser(t, app, data.NewMoney(1000), map[int64]int{1: 2})
+
+	// the waiting exit legs of another bracket will sell the shares the first bracket buys
+	placeGroup(t, app, buyer, bracketInput(data.NewMoney(5)))
+	legs := placeGroup(t, app, buyer, bracketInput(data.NewMoney(10)))
+	placeOrder(t, app, seller, orderInput{StockID: 1, Type: data.ORDER_TYPE_SELL, Quantity: 2, PriceType: data.ORDER_PRICE_TYPE_LIMIT, Price: data.NewMoney(10)})
+
+	// the entry settles and its exits are cancelled instead of failing the settlement
+	settleNext(t, app, 1)
+
+	if entry := getOrder(t, app, legs[data.ORDER_LEG_ENTRY].ID, buyer.ID); entry.Status != data.ORDER_STATUS_FILLED {
+		t.Errorf("entry status = %d, want filled", entry.Status)
+	}
+	for _, leg := range []int{data.ORDER_LEG_TAKE_PROFIT, data.ORDER_LEG_STOP_LOSS} {
+		exit := getOrder(t, app, legs[leg].ID, buyer.ID)
+		if exit.Status != data.ORDER_STATUS_KILLED {
+			t.Errorf("exit leg %d status = %d, want killed", leg, exit.Status)
+		}
+		if !hasOrderEvent(t, app, exit.ID, data.ORDER_EVENT_CANCELLED) {
+			t.Errorf("no %s event for exit leg %d", data.ORDER_EVENT_CANCELLED, leg)
+		}
+	}
+	if shares := stockBalance(t, app, buyer.ID, 1); shares != 2 {
+		t.Errorf("available shares = %d, want the 2 bought shares", shares)
+	}
+}
+
+func TestGroupLegsNotHandedOverAreWithdrawn(t *testing.T) {
+	app := newTestApp(t)
+	app.orderBook = failingBook{OrderBook: app.orderBook}
+	seller := newTestUser(t, app, data.NewMoney(1000), map[int64]int{1: 2})
+
+	input := bracketInput(data.NewMoney(10))
+	input["type"] = data.ORDER_GROUP_OCO
+	delete(input, "entry")
+	w := serveAs(t, app, seller, app.orderGroupCreateHandler, input)
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("orderGroupCreateHandler = %d, want %d", w.Code, http.StatusInternalServerError)
+	}
+
+	// the take-profit leg could not be queued, neither it nor the stop-loss leg after it is left open
+	legs, err := app.models.Order.GetAllForGroup(1)
+	if err != nil {
+		t.Fatalf("GetAllForGroup: %v", err)
+	}
+	if len(legs) != 2 {
+		t.Fatalf("group has %d legs, want 2", len(legs))
+	}
+	for _, leg := range legs {
+		if leg.Status != data.ORDER_STATUS_KILLED {
+			t.Errorf("leg %d status = %d, want killed", leg.Leg, leg.Status)
+		}
+		if !hasOrderEvent(t, app, leg.ID, data.ORDER_EVENT_REJECTED) {
+			t.Errorf("no %s event for leg %d", data.ORDER_EVENT_REJECTED, leg.Leg)
+		}
+	}
+	if shares := stockBalance(t, app, seller.ID, 1); shares != 2 {
+		t.Errorf("available shares = %d, want the reservation of the take-profit leg refunded", shares)
+	}
+	if app.triggerBook.Len(1) != 0 {
+		t.Errorf("trigger book holds %d triggers, want none", app.triggerBook.Len(1))
+	}
+}
@@ -1,70 +1,264 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"log/slog"
 	"net/http"
+	"time"
 
 	"github.com/maxwellkuo47/tradingEngine/internal/data"
+	"github.com/maxwellkuo47/tradingEngine/internal/orderbook"
+	"github.com/maxwellkuo47/tradingEngine/internal/triggerbook"
 	"github.com/maxwellkuo47/tradingEngine/internal/validator"
 )
 
-func (app *application) orderCreateHandler(w http.ResponseWriter, r *http.Request) {
-	var input struct {
-		StockID   int64   `json:"stock_id"`
-		Type      int     `json:"type"`
-		Quantity  int     `json:"quantity"`
-		PriceType int     `json:"price_type"`
-		Price     float64 `json:"price"`
+// orderInput is an order as it is placed through the api, on its own or as a leg of an order group
+type orderInput struct {
+	StockID         int64      `json:"stock_id"`
+	Type            int        `json:"type"`
+	Quantity        int        `json:"quantity"`
+	DisplayQuantity int        `json:"display_quantity"`
+	PriceType       int        `json:"price_type"`
+	Price           data.Money `json:"price"`
+	TriggerPrice    data.Money `json:"trigger_price"`
+	TimeInForce     int        `json:"time_in_force"`
+	ExpiresAt       *time.Time `json:"expires_at"`
+	ProtectionPrice data.Money `json:"protection_price"`
+	MaxSlippageBps  int        `json:"max_slippage_bps"`
+	TrailAmount     data.Money `json:"trail_amount"`
+	TrailBps        int        `json:"trail_bps"`
+	LimitOffset     data.Money `json:"limit_offset"`
+	PostOnly        int        `json:"post_only"`
+	ReduceOnly      bool       `json:"reduce_only"`
+	STPMode         *int       `json:"stp_mode"`
+	ClientOrderID   string     `json:"client_order_id"`
+}
+
+// newOrder turns the input into an order that is ready to be reserved and inserted.
+// Market orders are priced from the book and orders that can not be matched right away are rejected,
+// problems with the input are added to v and the returned error is only set when the book can not be read.
+// A stop-loss leg and a trailing stop fire when the price moves against the position they protect
+// instead of towards their trigger price, a trailing stop starts one trail away from the current price.
+// Without a self-trade prevention mode of its own the order gets the one of the user's account.
+func (app *application) newOrder(v *validator.Validator, user *data.User, input orderInput, leg int) (data.Order, error) {
+	order := data.Order{
+		UserID:          user.ID,
+		ClientOrderID:   input.ClientOrderID,
+		StockID:         input.StockID,
+		Type:            input.Type,
+		Quantity:        input.Quantity,
+		DisplayQuantity: input.DisplayQuantity,
+		PriceType:       input.PriceType,
+		Price:           input.Price,
+		TriggerPrice:    input.TriggerPrice,
+		TimeInForce:     input.TimeInForce,
+		ExpiresAt:       input.ExpiresAt,
+		ProtectionPrice: input.ProtectionPrice,
+		TrailAmount:     input.TrailAmount,
+		TrailBps:        input.TrailBps,
+		PostOnly:        input.PostOnly,
+		ReduceOnly:      input.ReduceOnly,
+		STPMode:         user.STPMode,
+		Leg:             leg,
+		Status:          data.ORDER_STATUS_PENDING,
+	}
+	if input.STPMode != nil {
+		order.STPMode = *input.STPMode
 	}
 
-	err := app.readJSON(w, r, &input)
-	if err != nil {
-		app.badReqResp(w, r, err)
-		return
+	currentPrice := app.currentPrice(order.StockID)
+	if order.IsStop() {
+		// the stop fires when the price moves from where it is now to the trigger price
+		order.Status = data.ORDER_STATUS_UNTRIGGERED
+		protective := leg == data.ORDER_LEG_STOP_LOSS || order.IsTrailing()
+		switch {
+		case protective && order.Type == data.ORDER_TYPE_SELL:
+			order.TriggerDirection = data.TRIGGER_DIRECTION_FALL
+		case protective && order.Type == data.ORDER_TYPE_BUY:
+			order.TriggerDirection = data.TRIGGER_DIRECTION_RISE
+		case order.TriggerPrice > currentPrice:
+			order.TriggerDirection = data.TRIGGER_DIRECTION_RISE
+		case order.TriggerPrice < currentPrice:
+			order.TriggerDirection = data.TRIGGER_DIRECTION_FALL
+		}
 	}
-	order := data.Order{
-		StockID:   input.StockID,
-		Type:      input.Type,
-		Quantity:  input.Quantity,
-		PriceType: input.PriceType,
-		Price:     input.Price,
-		Status:    data.ORDER_STATUS_PENDING,
-	}
-
-	if order.PriceType == data.ORDER_PRCIE_TYPE_MARKET {
-		currentStockPrice, _ := app.mockStockPrices.Load(order.StockID)
-		// let the order could be consumed immediately
-		switch order.Type {
-		case data.ORDER_TYPE_BUY:
-			order.Price = currentStockPrice.(float64) + 10.0
-		case data.ORDER_TYPE_SELL:
-			order.Price = currentStockPrice.(float64) - 10.0
-		default:
-			//just ignore because this request would be return by validator
+
+	if order.IsTrailing() {
+		v.Check(input.TriggerPrice == 0, "trigger_price", "the trigger price of a trailing stop follows the stock price")
+		v.Check(currentPrice > 0, "trail_amount", "the stock has no price to trail yet")
+		order.WaterMark = currentPrice
+		order.TriggerPrice = triggerbook.TrailPrice(triggerbook.Direction(order.TriggerDirection), currentPrice, order.TrailAmount, order.TrailBps)
+		if order.PriceType == data.ORDER_PRICE_TYPE_STOP_LIMIT {
+			// the limit price keeps its distance to the trigger price as the trigger price moves
+			v.Check(input.Price == 0, "price", "the limit price of a trailing stop limit order is set by limit_offset")
+			v.Check(input.LimitOffset >= 0, "limit_offset", "limit offset must not be negative")
+			order.Price = order.TriggerPrice - input.LimitOffset
+			if order.Type == data.ORDER_TYPE_BUY {
+				order.Price = order.TriggerPrice + input.LimitOffset
+			}
 		}
 	}
+	v.Check(input.LimitOffset == 0 || order.IsTrailing() && order.PriceType == data.ORDER_PRICE_TYPE_STOP_LIMIT, "limit_offset", "limit offset is only allowed for trailing stop limit orders")
+
+	if order.IsMarket() {
+		// a market order never rests in the book
+		if order.TimeInForce == data.ORDER_TIF_GTC {
+			order.TimeInForce = data.ORDER_TIF_IOC
+		}
+		if input.MaxSlippageBps != 0 {
+			v.Check(input.ProtectionPrice == 0, "max_slippage_bps", "either protection_price or max_slippage_bps can be given, not both")
+			v.Check(input.MaxSlippageBps > 0 && input.MaxSlippageBps < basisPoints, "max_slippage_bps", "max slippage must be between 1 and 9999 basis points")
+			reference, err := app.marketReference(order)
+			if err != nil {
+				return order, err
+			}
+			if reference == 0 {
+				v.AddError("quantity", "no liquidity to match the order right away")
+			}
+			order.ProtectionPrice = slippageProtection(order.Type, reference, input.MaxSlippageBps)
+		}
+		// the order may go as far as its protection price, a market order is priced from the book below
+		order.Price = order.ProtectionPrice
+	}
 
 	// validate input data
-	v := validator.New()
 	if data.ValidateOrder(v, order); !v.Valid() {
+		return order, nil
+	}
+
+	if order.PostOnly != data.ORDER_POST_ONLY_NONE && order.Status == data.ORDER_STATUS_PENDING {
+		err := app.postOnlyPrice(v, &order)
+		if err != nil {
+			return order, err
+		}
+	}
+
+	switch order.TimeInForce {
+	case data.ORDER_TIF_DAY:
+		expiresAt := app.dayOrderExpiry(time.Now())
+		order.ExpiresAt = &expiresAt
+	case data.ORDER_TIF_IOC, data.ORDER_TIF_FOK:
+		// the order is closed right after it is matched, the expiry only cleans up after a crash in between
+		expiresAt := time.Now().Add(data.ImmediateOrderGrace)
+		order.ExpiresAt = &expiresAt
+
+		// a stop order starts its grace period when it fires
+		if order.Status == data.ORDER_STATUS_UNTRIGGERED {
+			order.ExpiresAt = nil
+			break
+		}
+
+		// reject an order that has no chance before anything is reserved
+		sweep, err := app.sweepBook(order)
+		if err != nil {
+			return order, err
+		}
+		if order.TimeInForce == data.ORDER_TIF_FOK && sweep.quantity < order.Quantity {
+			v.AddError("quantity", "not enough liquidity to fill the order in full right away")
+		} else if sweep.quantity == 0 {
+			v.AddError("quantity", "no liquidity to match the order right away")
+		}
+		if order.PriceType == data.ORDER_PRCIE_TYPE_MARKET {
+			// reserve for the levels the order is expected to reach instead of its protection price,
+			// the book can only move against it up to this price, what is left of it is cancelled and refunded
+			order.Price = sweep.worstPrice
+		}
+	}
+
+	return order, nil
+}
+
+// postOnlyPrice checks a post-only order against the best price on the other side of the book before anything is reserved.
+// An order that would take liquidity is rejected, or moved one tick behind the best opposing price when it asks to be repriced.
+// The book is checked again when the order is queued, see submitOrder.
+func (app *application) postOnlyPrice(v *validator.Validator, order *data.Order) error {
+	side := orderbook.Side(order.Type)
+	best, err := app.orderBook.Best(context.Background(), order.StockID, side.Opposite())
+	if err != nil || best == nil {
+		return err
+	}
+	if side == orderbook.Buy && order.Price < best.Price || side == orderbook.Sell && order.Price > best.Price {
+		return nil
+	}
+
+	if order.PostOnly == data.ORDER_POST_ONLY_REJECT {
+		v.AddError("post_only", "the order would take liquidity from the book")
+		return nil
+	}
+	order.Price = best.Price + data.TickSize()
+	if side == orderbook.Buy {
+		order.Price = best.Price - data.TickSize()
+	}
+	v.Check(order.Price > 0, "post_only", "there is no price behind the best opposing price")
+	return nil
+}
+
+// stockExists answers a request for a stock that does not exist, it reports whether the request can go on
+func (app *application) stockExists(w http.ResponseWriter, r *http.Request, stockID int64) bool {
+	exist, err := app.models.Stock.ConfirmStockExist(stockID)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		app.serverErrResp(w, r, err)
+		return false
+	}
+	if err != nil || !exist {
+		v := validator.New()
+		v.AddError("stock", fmt.Sprintf("can not find stock with id %d", stockID))
 		app.failedValidationResp(w, r, v.Errors)
+		return false
+	}
+	return true
+}
+
+func (app *application) orderCreateHandler(w http.ResponseWriter, r *http.Request) {
+	var input orderInput
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badReqResp(w, r, err)
 		return
 	}
 
-	if exist, err := app.models.Stock.ConfirmStockExist(order.StockID); err != nil || !exist {
+	// get user data
+	user := app.contextGetUser(r)
+
+	// a retried submission names the order it placed before, in the body or in the Idempotency-Key header
+	v := validator.New()
+	if key := r.Header.Get("Idempotency-Key"); key != "" {
+		v.Check(input.ClientOrderID == "" || input.ClientOrderID == key, "client_order_id", "must match the Idempotency-Key header")
+		input.ClientOrderID = key
+	}
+	if !v.Valid() {
+		app.failedValidationResp(w, r, v.Errors)
+		return
+	}
+	if input.ClientOrderID != "" {
+		placed, err := app.models.Order.GetForClientOrderID(input.ClientOrderID, user.ID)
 		switch {
-		case errors.Is(err, data.ErrRecordNotFound) || !exist:
-			v.AddError("stock", fmt.Sprintf("can not find stock with id %d", order.StockID))
-			app.failedValidationResp(w, r, v.Errors)
-		default:
+		case err == nil:
+			app.orderReplayResp(w, r, placed)
+			return
+		case !errors.Is(err, data.ErrRecordNotFound):
 			app.serverErrResp(w, r, err)
+			return
 		}
+	}
+
+	if !app.stockExists(w, r, input.StockID) {
+		return
+	}
+
+	order, err := app.newOrder(v, user, input, data.ORDER_LEG_NONE)
+	if err != nil {
+		app.serverErrResp(w, r, err)
+		return
+	}
+	if !v.Valid() {
+		app.failedValidationResp(w, r, v.Errors)
 		return
 	}
 
-	// get user data
-	user := app.contextGetUser(r)
 	tx, err := app.models.DBHandler.Begin()
 	if err != nil {
 		app.serverErrResp(w, r, err)
@@ -72,95 +266,279 @@ func (app *application) orderCreateHandler(w http.ResponseWriter, r *http.Reques
 	}
 	defer tx.Rollback()
 	txModels := data.NewTxModels(tx)
+
 	//check and update wallet/stock Balance
-	switch order.Type {
-	case data.ORDER_TYPE_BUY: // BUY
-		wallet, err := txModels.UserWallet.GetUserWallet(user.ID)
-		if err != nil {
-			switch {
-			case errors.Is(err, data.ErrRecordNotFound):
-				app.balanceRecordNotFoundResp(w, r)
-			default:
+	err = app.reserveOrder(txModels, &order, order.Quantity)
+	if err != nil {
+		app.reservationErrResp(w, r, err)
+		return
+	}
+
+	err = txModels.Order.Insert(&order)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateClientOrderID):
+			// a retry raced the first submission, nothing of this one is kept
+			tx.Rollback()
+			placed, err := app.models.Order.GetForClientOrderID(order.ClientOrderID, user.ID)
+			if err != nil {
 				app.serverErrResp(w, r, err)
+				return
 			}
-			return
-		}
-		if order.Price*float64(order.Quantity) > wallet.Balance {
-			app.insufficientBalanceResp(w, r)
-			return
+			app.orderReplayResp(w, r, placed)
+		default:
+			app.serverErrResp(w, r, err)
 		}
+		return
+	}
 
-		// update wallet
-		wallet.Balance -= order.Price * float64(order.Quantity)
-		err = txModels.UserWallet.Update(wallet)
-		if err != nil {
-			switch {
-			case errors.Is(err, data.ErrEditConflict):
-				app.editConflictResp(w, r)
-			default:
-				app.serverErrResp(w, r, err)
-			}
-			return
+	err = app.recordUserEvent(txModels, data.USER_EVENT_ORDER_ACCEPTED, &order, reservationDeltas(&order, order.Quantity), nil)
+	if err != nil {
+		app.serverErrResp(w, r, err)
+		return
+	}
+
+	// the order has to be visible in db before it reaches the book, the matcher may settle it right away
+	err = tx.Commit()
+	if err != nil {
+		app.serverErrResp(w, r, err)
+		return
+	}
+	app.userStreams.notify(user.ID)
+
+	if order.Status == data.ORDER_STATUS_UNTRIGGERED {
+		err = app.triggerBook.Add(newTrigger(order))
+	} else {
+		err = app.submitOrder(order)
+	}
+	if err != nil {
+		// the order is committed, give its reservation back instead of leaving it to the startup reconciliation
+		if withdrawErr := app.withdrawOrder(order.ID, "the order could not be handed over to the order book"); withdrawErr != nil {
+			app.errorLogger.Error("error withdrawOrder", slog.Int64("order_id", order.ID), slog.String("msg", withdrawErr.Error()), slog.String("state", "withdraw order"))
 		}
-	case data.ORDER_TYPE_SELL: // SELL
-		stockBalance, err := txModels.UserStockBalance.GetUserStockBalance(user.ID, order.StockID)
-		if err != nil {
-			switch {
-			case errors.Is(err, data.ErrRecordNotFound):
-				app.balanceRecordNotFoundResp(w, r)
-			default:
-				app.serverErrResp(w, r, err)
-			}
-			return
+		app.serverErrResp(w, r, err)
+		return
+	}
+
+	// an IOC or FOK order is matched and closed by now, show where it ended up
+	created, err := app.models.Order.GetForUser(order.ID, user.ID)
+	if err != nil {
+		app.serverErrResp(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"message": "order create successfully", "order": created}, nil)
+	if err != nil {
+		app.serverErrResp(w, r, err)
+		return
+	}
+}
+
+// orderReplayResp answers a submission with a client order id that was used before with the order it placed
+func (app *application) orderReplayResp(w http.ResponseWriter, r *http.Request, order *data.Order) {
+	headers := make(http.Header)
+	headers.Set("Idempotent-Replayed", "true")
+
+	err := app.writeJSON(w, http.StatusOK, envelope{"message": "order was placed before", "order": order}, headers)
+	if err != nil {
+		app.serverErrResp(w, r, err)
+	}
+}
+
+func (app *application) orderCancelHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResp(w, r)
+		return
+	}
+
+	// do not leak other users' orders
+	user := app.contextGetUser(r)
+	_, err = app.models.Order.GetForUser(id, user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResp(w, r)
+		default:
+			app.serverErrResp(w, r, err)
 		}
-		if stockBalance.Quantity < order.Quantity {
-			app.insufficientBalanceResp(w, r)
-			return
+		return
+	}
+
+	app.cancelOrder(w, r, id)
+}
+
+func (app *application) orderCancelByClientIDHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+	order, err := app.models.Order.GetForClientOrderID(app.readClientOrderIDParam(r), user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResp(w, r)
+		default:
+			app.serverErrResp(w, r, err)
 		}
+		return
+	}
 
-		// update stock balance
-		stockBalance.Quantity -= order.Quantity
-		err = txModels.UserStockBalance.Update(stockBalance)
-		if err != nil {
-			switch {
-			case errors.Is(err, data.ErrEditConflict):
-				app.editConflictResp(w, r)
-			default:
-				app.serverErrResp(w, r, err)
-			}
-			return
+	app.cancelOrder(w, r, order.ID)
+}
+
+// cancelOrder kills an order of the user on request and writes the response
+func (app *application) cancelOrder(w http.ResponseWriter, r *http.Request, id int64) {
+	_, err := app.killOrder(id, data.ORDER_EVENT_CANCELLED, "cancelled by the user")
+	if err != nil {
+		switch {
+		case errors.Is(err, errOrderNotOpen), errors.Is(err, orderbook.ErrOrderNotFound):
+			app.orderNotCancelableResp(w, r)
+		default:
+			app.reservationErrResp(w, r, err)
 		}
-	default:
-		panic("invalid type should be eliminate at validate state")
+		return
 	}
-	order.UserID = user.ID
 
-	err = txModels.Order.Insert(&order)
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "order cancelled successfully"}, nil)
 	if err != nil {
 		app.serverErrResp(w, r, err)
 		return
 	}
+}
 
-	switch order.Type {
-	case data.ORDER_TYPE_BUY:
-		err = app.insertBuyOrder(order)
-		if err != nil {
+func (app *application) orderAmendHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResp(w, r)
+		return
+	}
+
+	var input struct {
+		Price    *data.Money `json:"price"`
+		Quantity *int        `json:"quantity"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badReqResp(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if data.ValidateAmendment(v, input.Price, input.Quantity); !v.Valid() {
+		app.failedValidationResp(w, r, v.Errors)
+		return
+	}
+
+	// do not leak other users' orders
+	user := app.contextGetUser(r)
+	_, err = app.models.Order.GetForUser(id, user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResp(w, r)
+		default:
 			app.serverErrResp(w, r, err)
-			return
 		}
-	case data.ORDER_TYPE_SELL:
-		err = app.insertSellOrder(order)
-		if err != nil {
+		return
+	}
+
+	order, err := app.amendOrder(id, input.Price, input.Quantity)
+	if err != nil {
+		switch {
+		case errors.Is(err, errOrderNotOpen), errors.Is(err, orderbook.ErrOrderNotFound):
+			app.orderNotAmendableResp(w, r, "the order is no longer open")
+		case errors.Is(err, errOrderNotAmendable), errors.Is(err, errAmendBelowFilled), errors.Is(err, errUnsettledFills), errors.Is(err, errGroupedQuantity), errors.Is(err, errPostOnlyCross):
+			app.orderNotAmendableResp(w, r, err.Error())
+		default:
+			app.reservationErrResp(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"order": order}, nil)
+	if err != nil {
+		app.serverErrResp(w, r, err)
+	}
+}
+
+func (app *application) orderListHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		data.OrderFilter
+		data.Filters
+	}
+
+	v := validator.New()
+	qs := r.URL.Query()
+
+	input.StockID = app.readOptionalInt64(qs, "stock_id", v)
+	input.Status = app.readOptionalInt(qs, "status", v)
+	input.Type = app.readOptionalInt(qs, "type", v)
+	input.From = app.readOptionalTime(qs, "from", v)
+	input.To = app.readOptionalTime(qs, "to", v)
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = app.readString(qs, "sort", "-id")
+	input.Filters.SortSafelist = []string{"id", "created_at", "updated_at", "stock_id", "price", "quantity", "-id", "-created_at", "-updated_at", "-stock_id", "-price", "-quantity"}
+
+	data.ValidateFilters(v, input.Filters)
+	if data.ValidateOrderFilter(v, input.OrderFilter); !v.Valid() {
+		app.failedValidationResp(w, r, v.Errors)
+		return
+	}
+
+	user := app.contextGetUser(r)
+	orders, metadata, err := app.models.Order.GetAllForUser(user.ID, input.OrderFilter, input.Filters)
+	if err != nil {
+		app.serverErrResp(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"orders": orders, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrResp(w, r, err)
+	}
+}
+
+func (app *application) orderShowHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResp(w, r)
+		return
+	}
+
+	user := app.contextGetUser(r)
+	order, err := app.models.Order.GetForUser(id, user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResp(w, r)
+		default:
 			app.serverErrResp(w, r, err)
-			return
 		}
-	default:
-		panic("invalid type should be eliminate at insert state")
+		return
 	}
-	tx.Commit()
-	err = app.writeJSON(w, http.StatusCreated, envelope{"message": "order create successfully"}, nil)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"order": order}, nil)
 	if err != nil {
 		app.serverErrResp(w, r, err)
+	}
+}
+
+func (app *application) orderShowByClientIDHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+	order, err := app.models.Order.GetForClientOrderID(app.readClientOrderIDParam(r), user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResp(w, r)
+		default:
+			app.serverErrResp(w, r, err)
+		}
 		return
 	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"order": order}, nil)
+	if err != nil {
+		app.serverErrResp(w, r, err)
+	}
 }
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"cmp"
+	"errors"
+	"net/http"
+	"slices"
+
+	"github.com/maxwellkuo47/tradingEngine/internal/data"
+)
+
+type position struct {
+	StockID          int64      `json:"stock_id"`
+	Quantity         int        `json:"quantity"`
+	ReservedQuantity int        `json:"reserved_quantity"`
+	TotalQuantity    int        `json:"total_quantity"`
+	AverageCost      data.Money `json:"average_cost"`
+	CurrentPrice     data.Money `json:"current_price"`
+	MarketValue      data.Money `json:"market_value"`
+	UnrealizedPnL    data.Money `json:"unrealized_pnl"`
+	RealizedPnL      data.Money `json:"realized_pnl"`
+}
+
+type portfolio struct {
+	Cash          data.Money  `json:"cash"`
+	ReservedCash  data.Money  `json:"reserved_cash"`
+	TotalCash     data.Money  `json:"total_cash"`
+	MarketValue   data.Money  `json:"market_value"`
+	TotalValue    data.Money  `json:"total_value"`
+	UnrealizedPnL data.Money  `json:"unrealized_pnl"`
+	RealizedPnL   data.Money  `json:"realized_pnl"`
+	Positions     []*position `json:"positions"`
+}
+
+// costBasis follows a position through its trades with the average cost method,
+// the average cost is rounded to the currency precision while the total cost stays exact
+type costBasis struct {
+	quantity    int
+	cost        data.Money
+	realizedPnL data.Money
+}
+
+func (c *costBasis) apply(trade *data.Trade) {
+	switch trade.Type {
+	case data.ORDER_TYPE_BUY:
+		c.quantity += trade.Quantity
+		c.cost += trade.Price.Mul(trade.Quantity)
+	case data.ORDER_TYPE_SELL:
+		averageCost := c.averageCost()
+		c.realizedPnL += (trade.Price - averageCost).Mul(trade.Quantity)
+		c.quantity -= trade.Quantity
+		c.cost -= averageCost.Mul(trade.Quantity)
+		if c.quantity <= 0 {
+			c.quantity, c.cost = 0, 0
+		}
+	}
+}
+
+func (c *costBasis) averageCost() data.Money {
+	return c.cost.Div(c.quantity)
+}
+
+// buildPortfolio combines wallet, stock balances and open order reservations of a user,
+// valued at the current stock prices with cost basis and realized P&L from the trade history
+func (app *application) buildPortfolio(userID int64) (*portfolio, error) {
+	wallet, err := app.models.UserWallet.GetUserWallet(userID)
+	if err != nil {
+		return nil, err
+	}
+	stockBalances, err := app.models.UserStockBalance.GetAllForUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	reservations, err := app.models.Order.GetOpenReservations(userID)
+	if err != nil {
+		return nil, err
+	}
+	trades, err := app.models.Trade.GetHistoryForUser(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &portfolio{
+		Cash:      wallet.Balance,
+		Positions: []*position{},
+	}
+	positions := make(map[int64]*position)
+	getPosition := func(stockID int64) *position {
+		pos, ok := positions[stockID]
+		if !ok {
+			pos = &position{StockID: stockID}
+			positions[stockID] = pos
+			p.Positions = append(p.Positions, pos)
+		}
+		return pos
+	}
+
+	for _, stockBalance := range stockBalances {
+		getPosition(stockBalance.StockID).Quantity = stockBalance.Quantity
+	}
+	for _, reservation := range reservations {
+		switch reservation.Type {
+		case data.ORDER_TYPE_BUY:
+			p.ReservedCash += reservation.Amount
+		case data.ORDER_TYPE_SELL:
+			getPosition(reservation.StockID).ReservedQuantity += reservation.Quantity
+		}
+	}
+
+	costBases := make(map[int64]*costBasis)
+	for _, trade := range trades {
+		basis, ok := costBases[trade.StockID]
+		if !ok {
+			basis = &costBasis{}
+			costBases[trade.StockID] = basis
+		}
+		basis.apply(trade)
+	}
+
+	slices.SortFunc(p.Positions, func(a, b *position) int {
+		return cmp.Compare(a.StockID, b.StockID)
+	})
+	for _, pos := range p.Positions {
+		pos.TotalQuantity = pos.Quantity + pos.ReservedQuantity
+		pos.CurrentPrice = app.currentPrice(pos.StockID)
+		pos.MarketValue = pos.CurrentPrice.Mul(pos.TotalQuantity)
+		if basis, ok := costBases[pos.StockID]; ok {
+			pos.AverageCost = basis.averageCost()
+			pos.RealizedPnL = basis.realizedPnL
+		}
+		pos.UnrealizedPnL = (pos.CurrentPrice - pos.AverageCost).Mul(pos.TotalQuantity)
+
+		p.MarketValue += pos.MarketValue
+		p.UnrealizedPnL += pos.UnrealizedPnL
+		p.RealizedPnL += pos.RealizedPnL
+	}
+	// stocks that were sold out still carry realized P&L
+	for stockID, basis := range costBases {
+		if _, ok := positions[stockID]; !ok {
+			p.RealizedPnL += basis.realizedPnL
+		}
+	}
+
+	p.TotalCash = p.Cash + p.ReservedCash
+	p.TotalValue = p.TotalCash + p.MarketValue
+
+	return p, nil
+}
+
+func (app *application) walletShowHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	wallet, err := app.models.UserWallet.GetUserWallet(user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.balanceRecordNotFoundResp(w, r)
+		default:
+			app.serverErrResp(w, r, err)
+		}
+		return
+	}
+	reservations, err := app.models.Order.GetOpenReservations(user.ID)
+	if err != nil {
+		app.serverErrResp(w, r, err)
+		return
+	}
+
+	var reservedCash data.Money
+	for _, reservation := range reservations {
+		if reservation.Type == data.ORDER_TYPE_BUY {
+			reservedCash += reservation.Amount
+		}
+	}
+
+	env := envelope{
+		"wallet":           wallet,
+		"reserved_balance": reservedCash,
+	}
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrResp(w, r, err)
+	}
+}
+
+func (app *application) positionListHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	p, err := app.buildPortfolio(user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.balanceRecordNotFoundResp(w, r)
+		default:
+			app.serverErrResp(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"positions": p.Positions}, nil)
+	if err != nil {
+		app.serverErrResp(w, r, err)
+	}
+}
+
+func (app *application) portfolioShowHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	p, err := app.buildPortfolio(user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.balanceRecordNotFoundResp(w, r)
+		default:
+			app.serverErrResp(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"portfolio": p}, nil)
+	if err != nil {
+		app.serverErrResp(w, r, err)
+	}
+}
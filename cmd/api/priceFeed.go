@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/maxwellkuo47/tradingEngine/internal/data"
+	"github.com/maxwellkuo47/tradingEngine/internal/pricefeed"
+)
+
+// createPriceSource builds the price source chosen with -price-source
+func createPriceSource(cfg config, stockIDs []int64, errorLogger *slog.Logger) (pricefeed.PriceSource, error) {
+	initial, err := data.ParseMoney(cfg.priceFeed.initial)
+	if err != nil {
+		return nil, fmt.Errorf("initial price %q: %w", cfg.priceFeed.initial, err)
+	}
+	if initial <= 0 || !initial.IsMultipleOf(data.TickSize()) {
+		return nil, fmt.Errorf("initial price must be a positive multiple of the tick size, got %s", cfg.priceFeed.initial)
+	}
+
+	switch cfg.priceFeed.source {
+	case "manual":
+		return &pricefeed.Manual{StockIDs: stockIDs, Initial: initial}, nil
+	case "gbm":
+		stocks, err := pricefeed.ParseParams(cfg.priceFeed.gbm.stocks)
+		if err != nil {
+			return nil, err
+		}
+		return &pricefeed.Simulator{
+			StockIDs: stockIDs,
+			Initial:  initial,
+			Interval: cfg.priceFeed.gbm.interval,
+			Seed:     cfg.priceFeed.gbm.seed,
+			Default:  pricefeed.Params{Drift: cfg.priceFeed.gbm.drift, Volatility: cfg.priceFeed.gbm.volatility},
+			Stocks:   stocks,
+		}, nil
+	case "replay":
+		return &pricefeed.Replay{Path: cfg.priceFeed.replay.file, Speed: cfg.priceFeed.replay.speed}, nil
+	case "poll":
+		return &pricefeed.Poller{
+			URL:      cfg.priceFeed.poll.url,
+			Interval: cfg.priceFeed.poll.interval,
+			OnError: func(err error) {
+				errorLogger.Error("error poll", slog.String("url", cfg.priceFeed.poll.url), slog.String("msg", err.Error()), slog.String("state", "poll stock prices"))
+			},
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown price source %q", cfg.priceFeed.source)
+	}
+}
+
+// priceSink moves the stop orders along with the prices of the price source
+type priceSink struct {
+	app *application
+}
+
+func (s priceSink) Price(stockID int64) (data.Money, bool) {
+	return s.app.prices.Price(stockID)
+}
+
+func (s priceSink) Publish(tick pricefeed.Tick) {
+	s.app.publishPrice(tick)
+}
+
+// startPriceSource runs the price source until shutdown
+func (app *application) startPriceSource(source pricefeed.PriceSource) {
+	ctx, cancel := context.WithCancel(context.Background())
+	app.background("priceSource", func() {
+		<-app.done
+		cancel()
+	})
+
+	app.background("priceSource", func() {
+		defer cancel()
+
+		err := source.Run(ctx, priceSink{app: app})
+		if err != nil {
+			app.errorLogger.Error("error Run", slog.String("source", app.config.priceFeed.source), slog.String("msg", err.Error()), slog.String("state", "run price source"))
+			return
+		}
+		app.infoLogger.Info("price source stopped", slog.String("source", app.config.priceFeed.source))
+	})
+}
+
+// currentPrice is the last price of a stock, 0 when it has none yet
+func (app *application) currentPrice(stockID int64) data.Money {
+	price, _ := app.prices.Price(stockID)
+	return price
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/maxwellkuo47/tradingEngine/internal/reconcile"
+)
+
+// recoverOrderBook rebuilds the order book from the open orders in postgres before the matchers start,
+// so orders survive a redis flush or restart and the memory backend starts with every open order
+func (app *application) recoverOrderBook() error {
+	stockIDs, err := app.models.Stock.GetAllStockIDs()
+	if err != nil {
+		return err
+	}
+
+	reconciler := reconcile.New(app.models, app.orderBook)
+	for _, stockID := range stockIDs {
+		report, err := reconciler.Stock(context.Background(), stockID, true)
+		if err != nil {
+			return err
+		}
+		for _, discrepancy := range report.Discrepancies {
+			app.infoLogger.Info(
+				"order book repaired",
+				slog.Int64("stock_id", stockID),
+				slog.String("kind", discrepancy.Kind),
+				slog.Int64("order_id", discrepancy.OrderID),
+				slog.Int("expected", discrepancy.Expected),
+				slog.Int("actual", discrepancy.Actual),
+				slog.String("detail", discrepancy.Detail),
+			)
+		}
+		app.infoLogger.Info(
+			"order book recovered",
+			slog.Int64("stock_id", stockID),
+			slog.Int("open_orders", report.OpenOrders),
+			slog.Int("discrepancies", len(report.Discrepancies)),
+		)
+	}
+
+	return nil
+}
+
+// loadTriggerBook puts every stop order that is still waiting for its trigger price back into the trigger book
+func (app *application) loadTriggerBook() error {
+	orders, err := app.models.Order.GetUntriggered()
+	if err != nil {
+		return err
+	}
+	for _, order := range orders {
+		err = app.triggerBook.Add(newTrigger(*order))
+		if err != nil {
+			return err
+		}
+	}
+	app.infoLogger.Info("trigger book loaded", slog.Int("stop_orders", len(orders)))
+	return nil
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/maxwellkuo47/tradingEngine/internal/data"
+)
+
+var (
+	errInsufficientBalance = errors.New("insufficient balance")
+	errReduceOnlyExceeds   = errors.New("a reduce-only order can not exceed the position net of the other open sell orders")
+)
+
+// reserveOrder holds back what an order may spend while it is open,
+// the cash for quantity shares at the order price of a buy order or the shares of a sell order
+func (app *application) reserveOrder(txModels data.TxModels, order *data.Order, quantity int) error {
+	switch order.Type {
+	case data.ORDER_TYPE_BUY:
+		wallet, err := txModels.UserWallet.GetUserWallet(order.UserID)
+		if err != nil {
+			return err
+		}
+		amount := order.Price.Mul(quantity)
+		if amount > wallet.Balance {
+			return errInsufficientBalance
+		}
+		wallet.Balance -= amount
+		return txModels.UserWallet.Update(wallet)
+	case data.ORDER_TYPE_SELL:
+		stockBalance, err := txModels.UserStockBalance.GetUserStockBalance(order.UserID, order.StockID)
+		if err != nil {
+			return err
+		}
+		// the balance is what is left after the reservations of the other sell orders
+		if stockBalance.Quantity < quantity {
+			if order.ReduceOnly {
+				return errReduceOnlyExceeds
+			}
+			return errInsufficientBalance
+		}
+		if order.ReduceOnly {
+			// exit legs without a reservation will sell from the same position, those of the order's own group exit it together with the order
+			unreserved, err := txModels.Order.GetUnreservedSellQuantity(order.UserID, order.StockID, order.GroupID)
+			if err != nil {
+				return err
+			}
+			if stockBalance.Quantity-unreserved < quantity {
+				return errReduceOnlyExceeds
+			}
+		}
+		stockBalance.Quantity -= quantity
+		return txModels.UserStockBalance.Update(stockBalance)
+	default:
+		panic("invalid type should be eliminate at validate state")
+	}
+}
+
+// reserveOrders reserves a batch of orders of one user like reserveOrder, in the order they are given.
+// The wallet and each stock balance are read and written once for the whole batch.
+// It returns the reservation error of every order at its index, nil for the orders that were reserved
+func (app *application) reserveOrders(txModels data.TxModels, userID int64, orders []*data.Order) ([]error, error) {
+	results := make([]error, len(orders))
+	var wallet *data.UserWallet
+	balances := make(map[int64]*data.UserStockBalance)
+	unreserved := make(map[int64]int)
+	changed := make(map[int64]bool)
+	walletChanged := false
+
+	for i, order := range orders {
+		switch order.Type {
+		case data.ORDER_TYPE_BUY:
+			if wallet == nil {
+				var err error
+				wallet, err = txModels.UserWallet.GetUserWallet(userID)
+				if err != nil {
+					return nil, err
+				}
+			}
+			amount := order.Price.Mul(order.Quantity)
+			if amount > wallet.Balance {
+				results[i] = errInsufficientBalance
+				continue
+			}
+			wallet.Balance -= amount
+			walletChanged = true
+		case data.ORDER_TYPE_SELL:
+			stockBalance, ok := balances[order.StockID]
+			if !ok {
+				var err error
+				stockBalance, err = txModels.UserStockBalance.GetUserStockBalance(userID, order.StockID)
+				if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+					return nil, err
+				}
+				balances[order.StockID] = stockBalance
+			}
+			if stockBalance == nil {
+				results[i] = data.ErrRecordNotFound
+				continue
+			}
+			if stockBalance.Quantity < order.Quantity {
+				results[i] = errInsufficientBalance
+				if order.ReduceOnly {
+					results[i] = errReduceOnlyExceeds
+				}
+				continue
+			}
+			if order.ReduceOnly {
+				quantity, ok := unreserved[order.StockID]
+				if !ok {
+					var err error
+					// the orders of a batch belong to no group
+					quantity, err = txModels.Order.GetUnreservedSellQuantity(userID, order.StockID, nil)
+					if err != nil {
+						return nil, err
+					}
+					unreserved[order.StockID] = quantity
+				}
+				if stockBalance.Quantity-quantity < order.Quantity {
+					results[i] = errReduceOnlyExceeds
+					continue
+				}
+			}
+			stockBalance.Quantity -= order.Quantity
+			changed[order.StockID] = true
+		default:
+			panic("invalid type should be eliminate at validate state")
+		}
+	}
+
+	if walletChanged {
+		err := txModels.UserWallet.Update(wallet)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for stockID := range changed {
+		err := txModels.UserStockBalance.Update(balances[stockID])
+		if err != nil {
+			return nil, err
+		}
+	}
+	return results, nil
+}
+
+// releaseOrder gives back what reserveOrder held for quantity shares of the order
+func (app *application) releaseOrder(txModels data.TxModels, order *data.Order, quantity int) error {
+	switch order.Type {
+	case data.ORDER_TYPE_BUY:
+		wallet, err := txModels.UserWallet.GetUserWallet(order.UserID)
+		if err != nil {
+			return err
+		}
+		wallet.Balance += order.Price.Mul(quantity)
+		return txModels.UserWallet.Update(wallet)
+	case data.ORDER_TYPE_SELL:
+		stockBalance, err := txModels.UserStockBalance.GetUserStockBalance(order.UserID, order.StockID)
+		if err != nil {
+			return err
+		}
+		stockBalance.Quantity += quantity
+		return txModels.UserStockBalance.Update(stockBalance)
+	default:
+		panic("invalid type should be eliminate when the order was created")
+	}
+}
+
+// reservationErrResp answers a failed reserveOrder or releaseOrder
+func (app *application) reservationErrResp(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, errInsufficientBalance):
+		app.insufficientBalanceResp(w, r)
+	case errors.Is(err, errReduceOnlyExceeds):
+		app.failedValidationResp(w, r, map[string]string{"reduce_only": err.Error()})
+	case errors.Is(err, data.ErrRecordNotFound):
+		app.balanceRecordNotFoundResp(w, r)
+	case errors.Is(err, data.ErrEditConflict):
+		app.editConflictResp(w, r)
+	default:
+		app.serverErrResp(w, r, err)
+	}
+}
@@ -18,9 +18,39 @@ func (app *application) routes() http.Handler {
 	// user
 	router.HandlerFunc(http.MethodPost, "/v1/users", app.userRegisterHandler)
 	router.HandlerFunc(http.MethodPost, "/v1/users/authentication", app.userLoginHandler)
+	router.HandlerFunc(http.MethodPatch, "/v1/me", app.requireAuthenticatedUser(app.userUpdateHandler))
+
+	// portfolio
+	router.HandlerFunc(http.MethodGet, "/v1/me/wallet", app.requireAuthenticatedUser(app.walletShowHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/me/positions", app.requireAuthenticatedUser(app.positionListHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/me/portfolio", app.requireAuthenticatedUser(app.portfolioShowHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/me/events", app.requireAuthenticatedUser(app.userStreamHandler))
 
 	// order
+	router.HandlerFunc(http.MethodGet, "/v1/orders", app.requireAuthenticatedUser(app.orderListHandler))
 	router.HandlerFunc(http.MethodPost, "/v1/orders", app.requireAuthenticatedUser(app.orderCreateHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/orders/batch", app.requireAuthenticatedUser(app.orderBatchCreateHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/orders", app.requireAuthenticatedUser(app.orderMassCancelHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/orders/:id", app.requireAuthenticatedUser(app.orderShowHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/orders/:id", app.requireAuthenticatedUser(app.orderAmendHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/orders/:id", app.requireAuthenticatedUser(app.orderCancelHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/orders/:id/events", app.requireAuthenticatedUser(app.orderEventListHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/client-orders/:client_order_id", app.requireAuthenticatedUser(app.orderShowByClientIDHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/client-orders/:client_order_id", app.requireAuthenticatedUser(app.orderCancelByClientIDHandler))
+
+	// order group
+	router.HandlerFunc(http.MethodPost, "/v1/order-groups", app.requireAuthenticatedUser(app.orderGroupCreateHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/order-groups/:id", app.requireAuthenticatedUser(app.orderGroupShowHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/order-groups/:id", app.requireAuthenticatedUser(app.orderGroupCancelHandler))
+
+	// trade
+	router.HandlerFunc(http.MethodGet, "/v1/trades", app.requireAuthenticatedUser(app.tradeListHandler))
+
+	// market data
+	router.HandlerFunc(http.MethodGet, "/v1/stocks/:id/book", app.bookShowHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/stocks/:id/ticker", app.tickerShowHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/stocks/:id/candles", app.candleListHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/ws/market", app.marketStreamHandler)
 
 	// for adjust fake stock value
 	router.HandlerFunc(http.MethodPost, "/v1/stockValueChangeHandler", app.adjustStockPrice)
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/maxwellkuo47/tradingEngine/internal/data"
+	"github.com/maxwellkuo47/tradingEngine/internal/orderbook"
+)
+
+// selfTradeReason is the reason code of the event of an order that lost removed shares to self-trade prevention
+func selfTradeReason(mode, removed int, bookOrder orderbook.Order) string {
+	switch mode {
+	case data.ORDER_STP_CANCEL_NEWEST:
+		return data.STP_REASON_CANCEL_NEWEST
+	case data.ORDER_STP_CANCEL_OLDEST:
+		return data.STP_REASON_CANCEL_OLDEST
+	case data.ORDER_STP_CANCEL_BOTH:
+		return data.STP_REASON_CANCEL_BOTH
+	default:
+		if removed >= bookOrder.Open() {
+			return data.STP_REASON_DECREMENT_CANCEL
+		}
+		return data.STP_REASON_DECREMENT
+	}
+}
+
+// processSelfTrade settles one side of a self-trade prevention record: the shares taken out of the book are refunded,
+// the order is killed when nothing of it is left and cut down otherwise, and an event with the reason code tells the user.
+// The event is keyed by the settlement id, so a retried record is applied once.
+func (app *application) processSelfTrade(txModels data.TxModels, stockID int64, match orderbook.Match, side orderbook.Side, changes *bookChanges) error {
+	bookOrder, other := match.Buy, match.Sell
+	if side == orderbook.Sell {
+		bookOrder, other = match.Sell, match.Buy
+	}
+	removed := match.SelfTrade.Removed(side)
+	if removed == 0 {
+		return nil
+	}
+
+	order, err := txModels.Order.GetOrderForUpdate(bookOrder.OrderID)
+	if err != nil {
+		app.errorLogger.Error(
+			"error GetOrderForUpdate",
+			slog.Int64("consumer_stock_id", stockID),
+			slog.Int64("order_id", bookOrder.OrderID),
+			slog.String("msg", err.Error()),
+			slog.String("state", "get order record"),
+		)
+		return err
+	}
+
+	event := &data.OrderEvent{
+		OrderID:   order.ID,
+		Type:      data.ORDER_EVENT_SELF_TRADE,
+		Message:   fmt.Sprintf("%d shares were not traded against order %d of the same user", removed, other.OrderID),
+		Reason:    selfTradeReason(match.SelfTrade.Mode, removed, bookOrder),
+		MatchID:   match.ID,
+		CreatedAt: time.Now(),
+	}
+	inserted, err := txModels.OrderEvent.InsertForMatch(event)
+	if err != nil {
+		app.errorLogger.Error(
+			"error InsertForMatch",
+			slog.Int64("consumer_stock_id", stockID),
+			slog.Int64("order_id", order.ID),
+			slog.String("msg", err.Error()),
+			slog.String("state", "insert self-trade event"),
+		)
+		return err
+	}
+	// settled before, or an immediate order that submitOrder closes with its unmatched quantity
+	if !inserted || order.IsImmediate() || !order.IsOpen() {
+		return nil
+	}
+
+	var eventData data.UserEventData
+	if order.HoldsReservation() {
+		eventData = reservationDeltas(order, -removed)
+		err = app.releaseOrder(txModels, order, removed)
+		if err != nil {
+			app.errorLogger.Error(
+				"error releaseOrder",
+				slog.Int64("consumer_stock_id", stockID),
+				slog.Int64("order_id", order.ID),
+				slog.String("msg", err.Error()),
+				slog.String("state", "release self-trade quantity"),
+			)
+			return err
+		}
+	}
+
+	eventData.Reason = data.ORDER_EVENT_SELF_TRADE
+	eventData.Message = event.Message
+
+	order.UpdatedAt = time.Now()
+	switch {
+	case removed >= bookOrder.Open():
+		// the book dropped the order already
+		err = txModels.Order.UpdateOrderStatus(order, data.ORDER_STATUS_KILLED)
+		if err == nil {
+			err = app.recordUserEvent(txModels, data.USER_EVENT_ORDER_CLOSED, order, eventData, changes)
+		}
+		if err == nil {
+			err = app.closeSiblings(txModels, order, fmt.Sprintf("order %d of the group was cancelled by self-trade prevention", order.ID), changes)
+		}
+	case order.GroupID != nil:
+		// the legs of a group are sized together, the rest of a decremented leg is cancelled as well
+		order.Quantity -= removed
+		err = txModels.Order.Amend(order)
+		if err == nil {
+			err = app.recordUserEvent(txModels, data.USER_EVENT_ORDER_REDUCED, order, eventData, changes)
+		}
+		if err == nil {
+			err = app.closeOrder(txModels, order, data.ORDER_EVENT_CANCELLED, "the rest of the order was cancelled, the legs of a group can not be decremented", changes)
+		}
+		if err == nil {
+			err = app.closeSiblings(txModels, order, fmt.Sprintf("order %d of the group was cancelled by self-trade prevention", order.ID), changes)
+		}
+	default:
+		order.Quantity -= removed
+		err = txModels.Order.Amend(order)
+		if err == nil {
+			err = app.recordUserEvent(txModels, data.USER_EVENT_ORDER_REDUCED, order, eventData, changes)
+		}
+	}
+	if err != nil {
+		app.errorLogger.Error(
+			"error update",
+			slog.Int64("consumer_stock_id", stockID),
+			slog.Int64("order_id", order.ID),
+			slog.String("msg", err.Error()),
+			slog.String("state", "apply self-trade prevention"),
+		)
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/maxwellkuo47/tradingEngine/internal/data"
+	"github.com/maxwellkuo47/tradingEngine/internal/pricefeed"
+	"github.com/maxwellkuo47/tradingEngine/internal/triggerbook"
+)
+
+// updateStockPrice sets the current price of a stock and fires the stop orders it reaches
+func (app *application) updateStockPrice(stockID int64, price data.Money) {
+	app.publishPrice(pricefeed.Tick{StockID: stockID, Price: price, Time: time.Now()})
+}
+
+// publishPrice passes a tick on to the subscribers of the price feed and fires the stop orders it reaches
+func (app *application) publishPrice(tick pricefeed.Tick) {
+	stockID, price := tick.StockID, tick.Price
+	app.prices.Publish(tick)
+
+	// trailing stops follow the price before it is checked against the triggers
+	trailed := app.triggerBook.Trail(stockID, price)
+	fired := app.triggerBook.Fire(stockID, price)
+	if len(trailed) == 0 && len(fired) == 0 {
+		return
+	}
+	app.background(fmt.Sprintf("stock_%d_fire_stops", stockID), func() {
+		for _, trigger := range trailed {
+			// a trigger price that is not written is written with the next move or lost on a restart
+			err := app.trailStop(trigger)
+			if err != nil {
+				app.errorLogger.Error(
+					"error trailStop",
+					slog.Int64("stock_id", stockID),
+					slog.Int64("order_id", trigger.OrderID),
+					slog.String("msg", err.Error()),
+					slog.String("state", "trail stop order"),
+				)
+			}
+		}
+		for _, trigger := range fired {
+			err := app.fireStop(trigger, price)
+			if err != nil {
+				app.errorLogger.Error(
+					"error fireStop",
+					slog.Int64("stock_id", stockID),
+					slog.Int64("order_id", trigger.OrderID),
+					slog.String("msg", err.Error()),
+					slog.String("state", "trigger stop order"),
+				)
+				// try again on the next price update
+				if err := app.triggerBook.Add(trigger); err != nil {
+					app.errorLogger.Error("error Add", slog.Int64("order_id", trigger.OrderID), slog.String("msg", err.Error()), slog.String("state", "restore trigger"))
+				}
+			}
+		}
+	})
+}
+
+// moveTrigger moves the trigger price of a stop order, its limit or protection price keeps its distance to the trigger price
+func moveTrigger(order *data.Order, trigger data.Money) {
+	price := order.Price + trigger - order.TriggerPrice
+	if price < data.TickSize() {
+		price = data.TickSize()
+	}
+	order.TriggerPrice = trigger
+	order.Price = price
+	if order.IsMarket() {
+		order.ProtectionPrice = price
+	}
+}
+
+// trailStop writes the trigger price a trailing stop moved to in the trigger book,
+// what a buy order reserved is adjusted to the limit or protection price that moved with it
+func (app *application) trailStop(trigger triggerbook.Trigger) error {
+	tx, err := app.models.DBHandler.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	txModels := data.NewTxModels(tx)
+
+	order, err := txModels.Order.GetOrderForUpdate(trigger.OrderID)
+	if err != nil {
+		return err
+	}
+	// moves of several price updates may be written out of order, the trigger price only moves towards the price
+	ahead := trigger.Price > order.TriggerPrice
+	if trigger.Direction == triggerbook.Rise {
+		ahead = trigger.Price < order.TriggerPrice
+	}
+	if order.Status != data.ORDER_STATUS_UNTRIGGERED || !ahead {
+		return nil
+	}
+
+	// only the cash held for a buy order depends on its price
+	holds := order.HoldsReservation() && order.Type == data.ORDER_TYPE_BUY
+	if holds {
+		err = app.releaseOrder(txModels, order, order.Quantity)
+		if err != nil {
+			return err
+		}
+	}
+	moveTrigger(order, trigger.Price)
+	order.WaterMark = trigger.Mark
+	if holds {
+		err = app.reserveOrder(txModels, order, order.Quantity)
+		if err != nil {
+			return err
+		}
+	}
+
+	order.UpdatedAt = time.Now()
+	err = txModels.Order.Trail(order)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// fireStop turns a stop order whose trigger price was reached into a live order and records why it fired,
+// an order that was cancelled in the meantime is left alone
+func (app *application) fireStop(trigger triggerbook.Trigger, price data.Money) error {
+	tx, err := app.models.DBHandler.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	txModels := data.NewTxModels(tx)
+
+	changes := &bookChanges{}
+	committed := false
+	defer func() { changes.finish(committed) }()
+
+	order, err := txModels.Order.GetOrderForUpdate(trigger.OrderID)
+	if err != nil {
+		return err
+	}
+	if order.Status != data.ORDER_STATUS_UNTRIGGERED {
+		return nil
+	}
+
+	// a stop market order is priced at its protection price, the reservation was made for it when it was placed
+	now := time.Now()
+	err = txModels.Order.Trigger(order, now)
+	if err != nil {
+		return err
+	}
+
+	move := "rose to or above"
+	if trigger.Direction == triggerbook.Fall {
+		move = "fell to or below"
+	}
+	event := &data.OrderEvent{
+		OrderID:   order.ID,
+		Type:      data.ORDER_EVENT_TRIGGERED,
+		Message:   fmt.Sprintf("stock price %s %s the trigger price %s", price, move, order.TriggerPrice),
+		Price:     price,
+		CreatedAt: now,
+	}
+	err = txModels.OrderEvent.Insert(event)
+	if err != nil {
+		return err
+	}
+
+	live := true
+	if order.Leg == data.ORDER_LEG_STOP_LOSS {
+		live, err = app.fireStopLoss(txModels, order, changes)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+	committed = true
+	if !live {
+		return nil
+	}
+
+	// the order is live in db now, a failure here is repaired by the startup reconciliation
+	// or, for an IOC or FOK order, by the expiry sweeper
+	err = app.submitOrder(*order)
+	if err != nil {
+		app.errorLogger.Error("error submitOrder", slog.Int64("order_id", order.ID), slog.String("msg", err.Error()), slog.String("state", "submit triggered order"))
+	}
+
+	return nil
+}
+
+func (app *application) orderEventListHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResp(w, r)
+		return
+	}
+
+	user := app.contextGetUser(r)
+	_, err = app.models.Order.GetForUser(id, user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResp(w, r)
+		default:
+			app.serverErrResp(w, r, err)
+		}
+		return
+	}
+
+	events, err := app.models.OrderEvent.GetAllForOrder(id)
+	if err != nil {
+		app.serverErrResp(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"events": events}, nil)
+	if err != nil {
+		app.serverErrResp(w, r, err)
+	}
+}
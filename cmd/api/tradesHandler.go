@@ -0,0 +1,46 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/maxwellkuo47/tradingEngine/internal/data"
+	"github.com/maxwellkuo47/tradingEngine/internal/validator"
+)
+
+func (app *application) tradeListHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		data.TradeFilter
+		data.Filters
+	}
+
+	v := validator.New()
+	qs := r.URL.Query()
+
+	input.OrderID = app.readOptionalInt64(qs, "order_id", v)
+	input.StockID = app.readOptionalInt64(qs, "stock_id", v)
+	input.From = app.readOptionalTime(qs, "from", v)
+	input.To = app.readOptionalTime(qs, "to", v)
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = app.readString(qs, "sort", "-executed_at")
+	input.Filters.SortSafelist = []string{"id", "executed_at", "price", "quantity", "-id", "-executed_at", "-price", "-quantity"}
+
+	data.ValidateFilters(v, input.Filters)
+	if data.ValidateTradeFilter(v, input.TradeFilter); !v.Valid() {
+		app.failedValidationResp(w, r, v.Errors)
+		return
+	}
+
+	user := app.contextGetUser(r)
+	trades, metadata, err := app.models.Trade.GetAllForUser(user.ID, input.TradeFilter, input.Filters)
+	if err != nil {
+		app.serverErrResp(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"trades": trades, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrResp(w, r, err)
+	}
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/maxwellkuo47/tradingEngine/internal/data"
+	"github.com/maxwellkuo47/tradingEngine/internal/orderbook"
+	"github.com/maxwellkuo47/tradingEngine/internal/validator"
+)
+
+const (
+	// userEventBatch is how many events the stream reads at once
+	userEventBatch = 100
+	// userStreamPoll is how often an idle stream looks for events, it catches the events of other instances
+	// and those held back by a transaction that was still running
+	userStreamPoll = 500 * time.Millisecond
+	// userStreamHeartbeat keeps proxies from closing an idle stream
+	userStreamHeartbeat = 15 * time.Second
+)
+
+// userNotifier wakes up the streams of a user when an event of the user is committed
+type userNotifier struct {
+	mu      sync.Mutex
+	waiters map[int64]map[chan struct{}]struct{}
+}
+
+func newUserNotifier() *userNotifier {
+	return &userNotifier{waiters: make(map[int64]map[chan struct{}]struct{})}
+}
+
+// subscribe returns a channel that receives a signal when there are new events of the user and a function to stop it
+func (n *userNotifier) subscribe(userID int64) (<-chan struct{}, func()) {
+	c := make(chan struct{}, 1)
+
+	n.mu.Lock()
+	if n.waiters[userID] == nil {
+		n.waiters[userID] = make(map[chan struct{}]struct{})
+	}
+	n.waiters[userID][c] = struct{}{}
+	n.mu.Unlock()
+
+	return c, func() {
+		n.mu.Lock()
+		defer n.mu.Unlock()
+
+		delete(n.waiters[userID], c)
+		if len(n.waiters[userID]) == 0 {
+			delete(n.waiters, userID)
+		}
+	}
+}
+
+func (n *userNotifier) notify(userID int64) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	for c := range n.waiters[userID] {
+		select {
+		case c <- struct{}{}:
+		default:
+			// a signal is pending already
+		}
+	}
+}
+
+// recordUserEvent writes an event of order to the private stream of its user in the transaction of txModels.
+// The streams of the user are woken up once changes is committed, without changes the caller does it after the commit.
+func (app *application) recordUserEvent(txModels data.TxModels, eventType string, order *data.Order, eventData data.UserEventData, changes *bookChanges) error {
+	eventData.Order = order
+	event := &data.UserEvent{
+		UserID:    order.UserID,
+		Type:      eventType,
+		OrderID:   order.ID,
+		CreatedAt: time.Now(),
+	}
+	err := txModels.UserEvent.Insert(event, eventData)
+	if err != nil {
+		return err
+	}
+
+	if changes != nil {
+		changes.onCommit(func() {
+			app.userStreams.notify(order.UserID)
+		})
+	}
+	return nil
+}
+
+// reservationDeltas are the changes of the wallet or the position when quantity shares of order are reserved,
+// negative quantity for a release
+func reservationDeltas(order *data.Order, quantity int) data.UserEventData {
+	var eventData data.UserEventData
+	switch order.Type {
+	case data.ORDER_TYPE_BUY:
+		amount := order.Price.Mul(quantity)
+		eventData.Wallet = &data.WalletDelta{Balance: -amount, Reserved: amount}
+	case data.ORDER_TYPE_SELL:
+		eventData.Position = &data.PositionDelta{StockID: order.StockID, Quantity: -quantity, Reserved: quantity}
+	}
+	return eventData
+}
+
+// amendmentDeltas are the changes of the wallet or the position when an amendment releases released shares
+// of the order as it was before and reserves reserved shares of the amended order
+func amendmentDeltas(previous, order *data.Order, released, reserved int) data.UserEventData {
+	var eventData data.UserEventData
+	if released == 0 && reserved == 0 {
+		return eventData
+	}
+	switch order.Type {
+	case data.ORDER_TYPE_BUY:
+		amount := order.Price.Mul(reserved) - previous.Price.Mul(released)
+		eventData.Wallet = &data.WalletDelta{Balance: -amount, Reserved: amount}
+	case data.ORDER_TYPE_SELL:
+		quantity := reserved - released
+		eventData.Position = &data.PositionDelta{StockID: order.StockID, Quantity: -quantity, Reserved: quantity}
+	}
+	return eventData
+}
+
+// newFill is the execution of a match
+func newFill(match orderbook.Match) *data.Fill {
+	return &data.Fill{MatchID: match.ID, Quantity: match.Quantity, Price: match.Price, ExecutedAt: match.ExecutedAt}
+}
+
+// fillEventType tells a fill that completes an order from one that leaves some of it open
+func fillEventType(order *data.Order) string {
+	if order.FilledQuantity == order.Quantity {
+		return data.USER_EVENT_ORDER_FILLED
+	}
+	return data.USER_EVENT_ORDER_PARTIALLY_FILLED
+}
+
+// userStreamHandler streams the events of the user as server-sent events. Every event carries its id,
+// a client that reconnects with it in the Last-Event-ID header, or the last_event_id query parameter,
+// gets every event after it. Without one the stream starts with the next event.
+func (app *application) userStreamHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	v := validator.New()
+	lastEventID := r.Header.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = r.URL.Query().Get("last_event_id")
+	}
+
+	var cursor data.UserEventCursor
+	var err error
+	if lastEventID != "" {
+		id, err := strconv.ParseInt(lastEventID, 10, 64)
+		if err != nil || id < 1 {
+			v.AddError("last_event_id", "must be an event id")
+			app.failedValidationResp(w, r, v.Errors)
+			return
+		}
+		cursor, err = app.models.UserEvent.GetCursor(user.ID, id)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				v.AddError("last_event_id", "can not find the event, reconnect without it")
+				app.failedValidationResp(w, r, v.Errors)
+			default:
+				app.serverErrResp(w, r, err)
+			}
+			return
+		}
+	} else {
+		cursor, err = app.models.UserEvent.GetLatestCursor(user.ID)
+		if err != nil {
+			app.serverErrResp(w, r, err)
+			return
+		}
+	}
+
+	// subscribed before the first read so no commit slips in between
+	wake, stop := app.userStreams.subscribe(user.ID)
+	defer stop()
+
+	// the stream outlives the write timeout of the server
+	rc := http.NewResponseController(w)
+	err = rc.SetWriteDeadline(time.Time{})
+	if err != nil {
+		app.serverErrResp(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "retry: %d\n\n", time.Second.Milliseconds())
+	if rc.Flush() != nil {
+		return
+	}
+
+	poll := time.NewTicker(userStreamPoll)
+	defer poll.Stop()
+	heartbeat := time.NewTicker(userStreamHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		events, err := app.models.UserEvent.GetAfter(user.ID, cursor, userEventBatch)
+		if err != nil {
+			// the client reconnects with the last event it got
+			app.errorLogger.Error("error GetAfter", slog.Int64("user_id", user.ID), slog.String("msg", err.Error()), slog.String("state", "read user events"))
+			return
+		}
+		for _, event := range events {
+			payload, err := json.Marshal(event)
+			if err != nil {
+				app.errorLogger.Error("error Marshal", slog.Int64("user_id", user.ID), slog.Int64("event_id", event.ID), slog.String("msg", err.Error()), slog.String("state", "encode user event"))
+				return
+			}
+			_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, payload)
+			if err != nil {
+				return
+			}
+			cursor = event.Cursor()
+		}
+		if len(events) > 0 {
+			if rc.Flush() != nil {
+				return
+			}
+			heartbeat.Reset(userStreamHeartbeat)
+		}
+		if len(events) == userEventBatch {
+			continue
+		}
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-app.done:
+			return
+		case <-wake:
+		case <-poll.C:
+		case <-heartbeat.C:
+			_, err = fmt.Fprint(w, ": heartbeat\n\n")
+			if err != nil || rc.Flush() != nil {
+				return
+			}
+		}
+	}
+}
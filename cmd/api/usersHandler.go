@@ -119,3 +119,44 @@ func (app *application) userLoginHandler(w http.ResponseWriter, r *http.Request)
 	}
 
 }
+
+// userUpdateHandler changes the account settings of the user,
+// stp_mode is the self-trade prevention mode of new orders that do not bring their own
+func (app *application) userUpdateHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		STPMode *int `json:"stp_mode"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badReqResp(w, r, err)
+		return
+	}
+
+	user := app.contextGetUser(r)
+	if input.STPMode != nil {
+		user.STPMode = *input.STPMode
+	}
+
+	v := validator.New()
+	if data.ValidateSTPMode(v, user.STPMode); !v.Valid() {
+		app.failedValidationResp(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Users.UpdateSTPMode(user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResp(w, r)
+		default:
+			app.serverErrResp(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"user": user}, nil)
+	if err != nil {
+		app.serverErrResp(w, r, err)
+	}
+}
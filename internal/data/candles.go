@@ -0,0 +1,189 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/maxwellkuo47/tradingEngine/internal/validator"
+)
+
+// CandlePeriod is the time span of the candles of one interval, the candles start at multiples of it since the unix epoch
+type CandlePeriod struct {
+	Name     string
+	Duration time.Duration
+}
+
+// CandlePeriods are the intervals every trade is aggregated into
+var CandlePeriods = []CandlePeriod{
+	{Name: "1m", Duration: time.Minute},
+	{Name: "5m", Duration: 5 * time.Minute},
+	{Name: "1h", Duration: time.Hour},
+	{Name: "1d", Duration: 24 * time.Hour},
+}
+
+// MaxCandles is how many candles one request returns at most
+const MaxCandles = 1000
+
+// Candle is the open, high, low and close price and the traded volume of a stock in one interval
+type Candle struct {
+	StartTime time.Time `json:"time"`
+	Open      Money     `json:"open"`
+	High      Money     `json:"high"`
+	Low       Money     `json:"low"`
+	Close     Money     `json:"close"`
+	Volume    int64     `json:"volume"`
+	Trades    int       `json:"trades"`
+}
+
+// CandleFilter selects the candles of a stock in one interval, nil times are not filtered on
+type CandleFilter struct {
+	Period string
+	From   *time.Time
+	To     *time.Time
+}
+
+func ValidateCandleFilter(v *validator.Validator, filter CandleFilter) {
+	names := make([]string, 0, len(CandlePeriods))
+	for _, period := range CandlePeriods {
+		names = append(names, period.Name)
+	}
+	v.Check(validator.PermittedValue(filter.Period, names...), "interval", "must be one of "+strings.Join(names, ", "))
+	if filter.From != nil && filter.To != nil {
+		v.Check(!filter.From.After(*filter.To), "from", "must not be after to")
+	}
+}
+
+// candlePeriodsSQL lists CandlePeriods as a table of name and seconds
+func candlePeriodsSQL() string {
+	rows := make([]string, 0, len(CandlePeriods))
+	for _, period := range CandlePeriods {
+		rows = append(rows, fmt.Sprintf("('%s', %d)", period.Name, int64(period.Duration.Seconds())))
+	}
+	return "(VALUES " + strings.Join(rows, ", ") + ") AS periods (name, seconds)"
+}
+
+// candleStartSQL is the start of the candle of each period that the timestamp expression falls into
+func candleStartSQL(timestamp string) string {
+	return fmt.Sprintf("to_timestamp(floor(extract(epoch FROM %s) / periods.seconds) * periods.seconds) AT TIME ZONE 'UTC'", timestamp)
+}
+
+type CandleModel struct {
+	DB DBTX
+}
+
+// Apply adds a trade to the candles of every period of the stock.
+// Open and close follow the execution time, so a trade that is settled late still lands where it belongs.
+func (m CandleModel) Apply(stockID int64, price Money, quantity int, executedAt time.Time) error {
+	query := fmt.Sprintf(`INSERT INTO candles (stock_id, period, start_time, open, high, low, close, volume, trades, open_at, close_at)
+						SELECT $1, periods.name, %s, $3::decimal, $3::decimal, $3::decimal, $3::decimal, $4, 1, $2, $2
+						FROM %s
+						ON CONFLICT (stock_id, period, start_time) DO UPDATE SET
+							open = CASE WHEN EXCLUDED.open_at < candles.open_at THEN EXCLUDED.open ELSE candles.open END,
+							open_at = LEAST(candles.open_at, EXCLUDED.open_at),
+							high = GREATEST(candles.high, EXCLUDED.high),
+							low = LEAST(candles.low, EXCLUDED.low),
+							close = CASE WHEN EXCLUDED.close_at >= candles.close_at THEN EXCLUDED.close ELSE candles.close END,
+							close_at = GREATEST(candles.close_at, EXCLUDED.close_at),
+							volume = candles.volume + EXCLUDED.volume,
+							trades = candles.trades + 1`, candleStartSQL("$2::timestamp"), candlePeriodsSQL())
+
+	args := []any{stockID, executedAt, price, quantity}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, args...)
+	return err
+}
+
+// Rebuild regenerates the candles of a stock, or of every stock when stockID is nil, from the trades.
+// Every match is counted once, through the trade of its buy order. It returns how many candles were written.
+func (m CandleModel) Rebuild(stockID *int64) (int64, error) {
+	// a whole trade history takes longer than a single statement of the api
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `DELETE FROM candles WHERE $1::bigint IS NULL OR stock_id = $1`, stockID)
+	if err != nil {
+		return 0, err
+	}
+
+	// a trade settled while the candles are rebuilt may have written its candle already, it is part of the aggregate
+	query := fmt.Sprintf(`INSERT INTO candles (stock_id, period, start_time, open, high, low, close, volume, trades, open_at, close_at)
+						SELECT orders.stock_id, periods.name, %s AS start_time,
+							(array_agg(trades.price ORDER BY trades.executed_at, trades.id))[1],
+							max(trades.price),
+							min(trades.price),
+							(array_agg(trades.price ORDER BY trades.executed_at DESC, trades.id DESC))[1],
+							sum(trades.quantity),
+							count(*),
+							min(trades.executed_at),
+							max(trades.executed_at)
+						FROM trades
+						INNER JOIN orders
+						ON orders.id = trades.order_id
+						CROSS JOIN %s
+						WHERE orders.type = $1
+						AND ($2::bigint IS NULL OR orders.stock_id = $2)
+						GROUP BY orders.stock_id, periods.name, start_time
+						ON CONFLICT (stock_id, period, start_time) DO UPDATE SET
+							open = EXCLUDED.open, high = EXCLUDED.high, low = EXCLUDED.low, close = EXCLUDED.close,
+							volume = EXCLUDED.volume, trades = EXCLUDED.trades, open_at = EXCLUDED.open_at, close_at = EXCLUDED.close_at`,
+		candleStartSQL("trades.executed_at"), candlePeriodsSQL())
+
+	result, err := m.DB.ExecContext(ctx, query, ORDER_TYPE_BUY, stockID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// GetForStock returns the last MaxCandles candles of the stock in the filter, oldest first
+func (m CandleModel) GetForStock(stockID int64, filter CandleFilter) ([]*Candle, error) {
+	query := `SELECT start_time, open, high, low, close, volume, trades
+						FROM (
+							SELECT start_time, open, high, low, close, volume, trades
+							FROM candles
+							WHERE stock_id = $1 AND period = $2
+							AND ($3::timestamp IS NULL OR start_time >= $3)
+							AND ($4::timestamp IS NULL OR start_time <= $4)
+							ORDER BY start_time DESC
+							LIMIT $5
+						) AS latest
+						ORDER BY start_time ASC`
+
+	args := []any{stockID, filter.Period, filter.From, filter.To, MaxCandles}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	candles := []*Candle{}
+	for rows.Next() {
+		var candle Candle
+		err := rows.Scan(
+			&candle.StartTime,
+			&candle.Open,
+			&candle.High,
+			&candle.Low,
+			&candle.Close,
+			&candle.Volume,
+			&candle.Trades,
+		)
+		if err != nil {
+			return nil, err
+		}
+		candles = append(candles, &candle)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return candles, nil
+}
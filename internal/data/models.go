@@ -11,19 +11,27 @@ type DBModels struct {
 	Users            UserModel
 	Token            TokenModel
 	Order            OrderModel
+	OrderEvent       OrderEventModel
+	OrderGroup       OrderGroupModel
 	Trade            TradeModel
 	Stock            StockModel
 	UserWallet       UserWalletModel
 	UserStockBalance UserStockBalanceModel
+	UserEvent        UserEventModel
+	Candle           CandleModel
 }
 type TxModels struct {
 	Users            UserModel
 	Token            TokenModel
 	Order            OrderModel
+	OrderEvent       OrderEventModel
+	OrderGroup       OrderGroupModel
 	Trade            TradeModel
 	Stock            StockModel
 	UserWallet       UserWalletModel
 	UserStockBalance UserStockBalanceModel
+	UserEvent        UserEventModel
+	Candle           CandleModel
 }
 
 var (
@@ -48,10 +56,14 @@ func NewModels(db *sql.DB) DBModels {
 		Users:            UserModel{DB: db},
 		Token:            TokenModel{DB: db},
 		Order:            OrderModel{DB: db},
+		OrderEvent:       OrderEventModel{DB: db},
+		OrderGroup:       OrderGroupModel{DB: db},
 		Trade:            TradeModel{DB: db},
 		Stock:            StockModel{DB: db},
 		UserWallet:       UserWalletModel{DB: db},
 		UserStockBalance: UserStockBalanceModel{DB: db},
+		UserEvent:        UserEventModel{DB: db},
+		Candle:           CandleModel{DB: db},
 	}
 }
 
@@ -60,9 +72,13 @@ func NewTxModels(tx *sql.Tx) TxModels {
 		Users:            UserModel{DB: tx},
 		Token:            TokenModel{DB: tx},
 		Order:            OrderModel{DB: tx},
+		OrderEvent:       OrderEventModel{DB: tx},
+		OrderGroup:       OrderGroupModel{DB: tx},
 		Trade:            TradeModel{DB: tx},
 		Stock:            StockModel{DB: tx},
 		UserWallet:       UserWalletModel{DB: tx},
 		UserStockBalance: UserStockBalanceModel{DB: tx},
+		UserEvent:        UserEventModel{DB: tx},
+		Candle:           CandleModel{DB: tx},
 	}
 }
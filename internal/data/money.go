@@ -0,0 +1,199 @@
+package data
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Money is a fixed-point decimal amount holding an integer number of 10^-precision currency units,
+// prices, balances and trade amounts are added and multiplied exactly without float rounding.
+// The precision and the price tick size are set once at startup with ConfigureMoney.
+type Money int64
+
+var (
+	ErrInvalidMoney   = errors.New("invalid decimal amount")
+	ErrMoneyPrecision = errors.New("decimal amount has more decimal places than the currency precision")
+	ErrMoneyOverflow  = errors.New("decimal amount out of range")
+)
+
+var (
+	moneyPrecision       = 4
+	moneyScale     int64 = 10_000
+	tickSize       Money = 100 // 0.01
+)
+
+// ConfigureMoney sets the number of decimal places of every amount and the minimum price increment.
+// It must be called before any amount is parsed.
+func ConfigureMoney(precision int, tick string) error {
+	if precision < 0 || precision > 8 {
+		return fmt.Errorf("currency precision must be between 0 and 8, got %d", precision)
+	}
+	moneyPrecision = precision
+	moneyScale = int64(math.Pow10(precision))
+
+	t, err := ParseMoney(tick)
+	if err != nil {
+		return fmt.Errorf("tick size %q: %w", tick, err)
+	}
+	if t <= 0 {
+		return fmt.Errorf("tick size must be positive, got %s", tick)
+	}
+	tickSize = t
+
+	return nil
+}
+
+// TickSize is the minimum price increment
+func TickSize() Money {
+	return tickSize
+}
+
+// NewMoney returns a whole amount of currency units
+func NewMoney(units int64) Money {
+	return Money(units * moneyScale)
+}
+
+// ParseMoney reads a decimal text such as "90", "90.25" or "1e2" exactly
+func ParseMoney(s string) (Money, error) {
+	s = strings.TrimSpace(s)
+	if s == "" || strings.Contains(s, "/") {
+		return 0, ErrInvalidMoney
+	}
+
+	r, ok := new(big.Rat).SetString(s)
+	if !ok {
+		return 0, ErrInvalidMoney
+	}
+	r.Mul(r, new(big.Rat).SetInt64(moneyScale))
+	if !r.IsInt() {
+		return 0, ErrMoneyPrecision
+	}
+	if !r.Num().IsInt64() {
+		return 0, ErrMoneyOverflow
+	}
+
+	return Money(r.Num().Int64()), nil
+}
+
+// String formats the amount without trailing zeros, e.g. "90" or "90.25"
+func (m Money) String() string {
+	sign := ""
+	units := uint64(m)
+	if m < 0 {
+		sign = "-"
+		units = uint64(-m)
+	}
+
+	whole := units / uint64(moneyScale)
+	fraction := units % uint64(moneyScale)
+	if fraction == 0 {
+		return sign + strconv.FormatUint(whole, 10)
+	}
+
+	fractionText := strings.TrimRight(fmt.Sprintf("%0*d", moneyPrecision, fraction), "0")
+	return sign + strconv.FormatUint(whole, 10) + "." + fractionText
+}
+
+// Float64 is only meant for ordering, e.g. as a redis sorted set score, never for arithmetic
+func (m Money) Float64() float64 {
+	return float64(m) / float64(moneyScale)
+}
+
+// Mul returns the amount of quantity items priced at m
+func (m Money) Mul(quantity int) Money {
+	return m * Money(quantity)
+}
+
+// Div splits the amount into quantity parts, rounding half away from zero
+func (m Money) Div(quantity int) Money {
+	if quantity == 0 {
+		return 0
+	}
+	q := Money(quantity)
+	result := m / q
+	remainder := m % q
+	if remainder < 0 {
+		remainder = -remainder
+	}
+	if q < 0 {
+		q = -q
+	}
+	if remainder*2 >= q {
+		if (m < 0) != (quantity < 0) {
+			result--
+		} else {
+			result++
+		}
+	}
+	return result
+}
+
+// IsMultipleOf reports whether the amount is a whole number of increments, e.g. of the tick size
+func (m Money) IsMultipleOf(increment Money) bool {
+	return increment != 0 && m%increment == 0
+}
+
+// MarshalJSON writes the amount as an exact JSON number
+func (m Money) MarshalJSON() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+// UnmarshalJSON accepts a JSON number or a quoted decimal text
+func (m *Money) UnmarshalJSON(b []byte) error {
+	if bytes.Equal(b, []byte("null")) {
+		return nil
+	}
+
+	text := string(b)
+	if unquoted, err := strconv.Unquote(text); err == nil {
+		text = unquoted
+	}
+
+	parsed, err := ParseMoney(text)
+	if err != nil {
+		return &json.UnmarshalTypeError{Value: "number " + text, Type: reflect.TypeOf(m).Elem()}
+	}
+	*m = parsed
+	return nil
+}
+
+// Scan reads a postgres decimal column losslessly
+func (m *Money) Scan(value any) error {
+	switch v := value.(type) {
+	case nil:
+		*m = 0
+		return nil
+	case []byte:
+		parsed, err := ParseMoney(string(v))
+		if err != nil {
+			return err
+		}
+		*m = parsed
+		return nil
+	case string:
+		parsed, err := ParseMoney(v)
+		if err != nil {
+			return err
+		}
+		*m = parsed
+		return nil
+	case int64:
+		*m = NewMoney(v)
+		return nil
+	default:
+		return fmt.Errorf("cannot scan %T into Money", value)
+	}
+}
+
+// Value stores the amount as decimal text so the decimal column keeps every digit
+func (m Money) Value() (driver.Value, error) {
+	return m.String(), nil
+}
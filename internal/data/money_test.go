@@ -0,0 +1,127 @@
+package data
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestParseMoney(t *testing.T) {
+	tests := []struct {
+		text string
+		want Money
+		err  error
+	}{
+		{text: "90", want: 900_000},
+		{text: "90.25", want: 902_500},
+		{text: " 0.0001 ", want: 1},
+		{text: "-1.5", want: -15_000},
+		{text: "1e2", want: 1_000_000},
+		{text: "0.00001", err: ErrMoneyPrecision},
+		{text: "1e30", err: ErrMoneyOverflow},
+		{text: "", err: ErrInvalidMoney},
+		{text: "ten", err: ErrInvalidMoney},
+		{text: "1/3", err: ErrInvalidMoney},
+	}
+	for _, tt := range tests {
+		got, err := ParseMoney(tt.text)
+		if !errors.Is(err, tt.err) {
+			t.Errorf("ParseMoney(%q) error = %v, want %v", tt.text, err, tt.err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseMoney(%q) = %d, want %d", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestMoneyString(t *testing.T) {
+	tests := []struct {
+		money Money
+		want  string
+	}{
+		{money: NewMoney(90), want: "90"},
+		{money: 902_500, want: "90.25"},
+		{money: 1, want: "0.0001"},
+		{money: -15_000, want: "-1.5"},
+		{money: 0, want: "0"},
+	}
+	for _, tt := range tests {
+		if got := tt.money.String(); got != tt.want {
+			t.Errorf("Money(%d).String() = %q, want %q", int64(tt.money), got, tt.want)
+		}
+	}
+}
+
+func TestMoneyMulDiv(t *testing.T) {
+	price, _ := ParseMoney("10.01")
+	if got := price.Mul(3); got.String() != "30.03" {
+		t.Errorf("10.01 * 3 = %s, want 30.03", got)
+	}
+
+	tests := []struct {
+		money    Money
+		quantity int
+		want     Money
+	}{
+		{money: NewMoney(10), quantity: 4, want: 25_000},
+		{money: 10, quantity: 4, want: 3},   // 2.5 rounds up
+		{money: 9, quantity: 4, want: 2},    // 2.25 rounds down
+		{money: -10, quantity: 4, want: -3}, // half away from zero
+		{money: 10, quantity: -4, want: -3},
+		{money: 10, quantity: 0, want: 0},
+	}
+	for _, tt := range tests {
+		if got := tt.money.Div(tt.quantity); got != tt.want {
+			t.Errorf("Money(%d).Div(%d) = %d, want %d", int64(tt.money), tt.quantity, got, tt.want)
+		}
+	}
+}
+
+func TestMoneyIsMultipleOf(t *testing.T) {
+	tests := []struct {
+		text      string
+		increment Money
+		want      bool
+	}{
+		{text: "10.01", increment: TickSize(), want: true},
+		{text: "10.005", increment: TickSize(), want: false},
+		{text: "-0.02", increment: TickSize(), want: true},
+		{text: "10", increment: 0, want: false},
+	}
+	for _, tt := range tests {
+		money, err := ParseMoney(tt.text)
+		if err != nil {
+			t.Fatalf("ParseMoney(%q): %v", tt.text, err)
+		}
+		if got := money.IsMultipleOf(tt.increment); got != tt.want {
+			t.Errorf("%s.IsMultipleOf(%s) = %v, want %v", money, tt.increment, got, tt.want)
+		}
+	}
+}
+
+func TestMoneyJSON(t *testing.T) {
+	var input struct {
+		Number Money `json:"number"`
+		Text   Money `json:"text"`
+	}
+	err := json.Unmarshal([]byte(`{"number": 90.25, "text": "0.1"}`), &input)
+	if err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	if input.Number != 902_500 || input.Text != 1_000 {
+		t.Errorf("decoded %d and %d, want 902500 and 1000", input.Number, input.Text)
+	}
+
+	if err := json.Unmarshal([]byte(`{"number": 0.00001}`), &input); err == nil {
+		t.Error("Unmarshal accepted an amount below the currency precision")
+	}
+
+	output, err := json.Marshal(input)
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	if string(output) != `{"number":90.25,"text":0.1}` {
+		t.Errorf("Marshal = %s", output)
+	}
+}
@@ -0,0 +1,137 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// kinds of order event
+const (
+	ORDER_EVENT_TRIGGERED  = "triggered"
+	ORDER_EVENT_CANCELLED  = "cancelled"
+	ORDER_EVENT_EXPIRED    = "expired"
+	ORDER_EVENT_AMENDED    = "amended"
+	ORDER_EVENT_ARMED      = "armed"
+	ORDER_EVENT_REJECTED   = "rejected"
+	ORDER_EVENT_SELF_TRADE = "self_trade_prevented"
+)
+
+// reason codes of self-trade prevention events
+const (
+	STP_REASON_CANCEL_NEWEST    = "stp_cancel_newest"
+	STP_REASON_CANCEL_OLDEST    = "stp_cancel_oldest"
+	STP_REASON_CANCEL_BOTH      = "stp_cancel_both"
+	STP_REASON_DECREMENT        = "stp_decrement"
+	STP_REASON_DECREMENT_CANCEL = "stp_decrement_cancel"
+)
+
+// OrderEvent records something that happened to an order outside of its fills, e.g. a stop order firing,
+// Price is the stock price that caused it when there is one
+type OrderEvent struct {
+	ID        int64     `json:"id"`
+	OrderID   int64     `json:"order_id"`
+	Type      string    `json:"type"`
+	Message   string    `json:"message"`
+	Price     Money     `json:"price,omitempty"`
+	Reason    string    `json:"reason,omitempty"`
+	MatchID   string    `json:"-"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type OrderEventModel struct {
+	DB DBTX
+}
+
+func (m OrderEventModel) Insert(event *OrderEvent) error {
+	query := `INSERT INTO order_events (order_id, type, message, price, reason, created_at)
+						VALUES ($1, $2, $3, NULLIF($4::decimal, 0), NULLIF($5, ''), $6)
+						RETURNING id`
+
+	args := []any{
+		event.OrderID,
+		event.Type,
+		event.Message,
+		event.Price,
+		event.Reason,
+		event.CreatedAt,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&event.ID)
+}
+
+// InsertForMatch writes an event caused by an entry of the settlement log once,
+// it returns false when the event of the order for event.MatchID was written before
+func (m OrderEventModel) InsertForMatch(event *OrderEvent) (bool, error) {
+	query := `INSERT INTO order_events (order_id, type, message, price, reason, match_id, created_at)
+						VALUES ($1, $2, $3, NULLIF($4::decimal, 0), NULLIF($5, ''), $6, $7)
+						ON CONFLICT (order_id, match_id) WHERE match_id IS NOT NULL DO NOTHING
+						RETURNING id`
+
+	args := []any{
+		event.OrderID,
+		event.Type,
+		event.Message,
+		event.Price,
+		event.Reason,
+		event.MatchID,
+		event.CreatedAt,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&event.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return false, nil
+		default:
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// GetAllForOrder returns the events of an order, oldest first
+func (m OrderEventModel) GetAllForOrder(orderID int64) ([]*OrderEvent, error) {
+	query := `SELECT id, order_id, type, message, price, COALESCE(reason, ''), created_at
+						FROM order_events
+						WHERE order_id = $1
+						ORDER BY created_at, id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, orderID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []*OrderEvent{}
+	for rows.Next() {
+		var event OrderEvent
+		err := rows.Scan(
+			&event.ID,
+			&event.OrderID,
+			&event.Type,
+			&event.Message,
+			&event.Price,
+			&event.Reason,
+			&event.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, &event)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
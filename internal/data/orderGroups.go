@@ -0,0 +1,118 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/maxwellkuo47/tradingEngine/internal/validator"
+)
+
+// kinds of order group
+const (
+	ORDER_GROUP_OCO     = iota // take-profit and stop-loss legs, the first one to fill or fire cancels the other
+	ORDER_GROUP_BRACKET        // an entry whose fill arms a take-profit and a stop-loss leg that cancel each other
+)
+
+// statuses of an order group, read from its orders
+const (
+	ORDER_GROUP_STATUS_ACTIVE = "active" // some order of the group can still be matched
+	ORDER_GROUP_STATUS_CLOSED = "closed"
+)
+
+var permittedGroupTypeVal = []int{0, 1} // 0: one cancels other 1: bracket
+
+// OrderGroup links orders that act on each other, Status and OrderIDs are read from its orders
+type OrderGroup struct {
+	ID        int64     `json:"id"`
+	UserID    int64     `json:"user_id"`
+	StockID   int64     `json:"stock_id"`
+	Type      int       `json:"type"`
+	Status    string    `json:"status"`
+	OrderIDs  []int64   `json:"order_ids"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type OrderGroupModel struct {
+	DB DBTX
+}
+
+// ValidateOrderGroup checks how the legs of a group fit together, each leg is checked on its own by ValidateOrder.
+// entry is nil for an OCO group.
+func ValidateOrderGroup(v *validator.Validator, groupType int, entry, takeProfit, stopLoss *Order) {
+	v.Check(validator.PermittedValue(groupType, permittedGroupTypeVal...), "type", "invalid type value")
+
+	v.Check(takeProfit.PriceType == ORDER_PRICE_TYPE_LIMIT, "take_profit.price_type", "the take-profit leg must be a limit order")
+	v.Check(!takeProfit.IsImmediate(), "take_profit.time_in_force", "the take-profit leg must rest in the book")
+	v.Check(stopLoss.IsStop(), "stop_loss.price_type", "the stop-loss leg must be a stop market or stop limit order")
+	v.Check(stopLoss.Type == takeProfit.Type, "stop_loss.type", "both exit legs must be on the same side")
+	v.Check(stopLoss.Quantity == takeProfit.Quantity, "stop_loss.quantity", "both exit legs must have the same quantity")
+	switch takeProfit.Type {
+	case ORDER_TYPE_SELL:
+		v.Check(takeProfit.Price > stopLoss.TriggerPrice, "take_profit.price", "a selling take-profit price must be above the stop-loss trigger price")
+	case ORDER_TYPE_BUY:
+		v.Check(takeProfit.Price < stopLoss.TriggerPrice, "take_profit.price", "a buying take-profit price must be below the stop-loss trigger price")
+	}
+
+	if groupType == ORDER_GROUP_BRACKET {
+		v.Check(entry != nil, "entry", "a bracket needs an entry order")
+		if entry != nil {
+			v.Check(entry.Type != takeProfit.Type, "entry.type", "the exit legs must be on the other side of the entry")
+			v.Check(entry.Quantity == takeProfit.Quantity, "take_profit.quantity", "the exit legs must have the quantity of the entry")
+		}
+	} else {
+		v.Check(entry == nil, "entry", "only a bracket has an entry order")
+	}
+}
+
+// SetOrders fills in the order ids and the status of the group from its orders
+func (g *OrderGroup) SetOrders(orders []*Order) {
+	g.OrderIDs = make([]int64, 0, len(orders))
+	g.Status = ORDER_GROUP_STATUS_CLOSED
+	for _, order := range orders {
+		g.OrderIDs = append(g.OrderIDs, order.ID)
+		if order.IsOpen() {
+			g.Status = ORDER_GROUP_STATUS_ACTIVE
+		}
+	}
+}
+
+func (m OrderGroupModel) Insert(group *OrderGroup) error {
+	query := `INSERT INTO order_groups (user_id, stock_id, type)
+						VALUES ($1, $2, $3)
+						RETURNING id, created_at`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, group.UserID, group.StockID, group.Type).Scan(&group.ID, &group.CreatedAt)
+}
+
+func (m OrderGroupModel) GetForUser(groupID, userID int64) (*OrderGroup, error) {
+	query := `SELECT id, user_id, stock_id, type, created_at
+						FROM order_groups
+						WHERE id = $1 AND user_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var group OrderGroup
+	err := m.DB.QueryRowContext(ctx, query, groupID, userID).Scan(
+		&group.ID,
+		&group.UserID,
+		&group.StockID,
+		&group.Type,
+		&group.CreatedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &group, nil
+}
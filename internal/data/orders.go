@@ -4,6 +4,8 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"fmt"
+	"regexp"
 	"time"
 
 	"github.com/maxwellkuo47/tradingEngine/internal/validator"
@@ -21,33 +23,215 @@ const (
 const (
 	ORDER_PRCIE_TYPE_MARKET = iota
 	ORDER_PRICE_TYPE_LIMIT
+	ORDER_PRICE_TYPE_STOP_MARKET
+	ORDER_PRICE_TYPE_STOP_LIMIT
 )
 
 const (
 	ORDER_STATUS_KILLED = iota - 1
 	ORDER_STATUS_PENDING
 	ORDER_STATUS_FILLED
+	ORDER_STATUS_PARTIALLY_FILLED
+	ORDER_STATUS_UNTRIGGERED
+	ORDER_STATUS_INACTIVE // exit leg of a bracket waiting for its entry to fill
 )
 
+// the part an order plays in its order group
+const (
+	ORDER_LEG_NONE = iota
+	ORDER_LEG_ENTRY
+	ORDER_LEG_TAKE_PROFIT
+	ORDER_LEG_STOP_LOSS
+)
+
+// a stop order fires when the stock price moves to its trigger price from the side it was placed on
+const (
+	TRIGGER_DIRECTION_NONE = iota
+	TRIGGER_DIRECTION_RISE // fires when the price rises to or above the trigger price
+	TRIGGER_DIRECTION_FALL // fires when the price falls to or below the trigger price
+)
+
+// how long an order stays open
+const (
+	ORDER_TIF_GTC = iota // good till cancelled
+	ORDER_TIF_IOC        // immediate or cancel, what can not be matched right away is cancelled
+	ORDER_TIF_FOK        // fill or kill, matched in full right away or not at all
+	ORDER_TIF_DAY        // expires at the end of the trading day
+	ORDER_TIF_GTD        // good till date, expires at expires_at
+)
+
+// what a post-only order does when it would take liquidity from the book
+const (
+	ORDER_POST_ONLY_NONE    = iota
+	ORDER_POST_ONLY_REJECT  // the order is rejected
+	ORDER_POST_ONLY_REPRICE // the order is placed one tick behind the best opposing price
+)
+
+// self-trade prevention, what happens when an order would trade with another order of the same user.
+// The mode of the newer of the two orders applies
+const (
+	ORDER_STP_NONE          = iota // the orders trade
+	ORDER_STP_CANCEL_NEWEST        // the newer order is cancelled
+	ORDER_STP_CANCEL_OLDEST        // the older order is cancelled
+	ORDER_STP_CANCEL_BOTH          // both orders are cancelled
+	ORDER_STP_DECREMENT            // both orders are reduced by the smaller open quantity, the smaller one is cancelled
+)
+
+// ImmediateOrderGrace is how long an IOC or FOK order may stay open before the expiry sweeper closes it,
+// normally the order is closed right after it was matched, this only covers a crash in between
+const ImmediateOrderGrace = time.Minute
+
 var (
-	permittedTypeVal      = []int{0, 1}     // 0: buy 1: sell
-	permittedPriceTypeVal = []int{0, 1}     // 0: market 1: limit
-	permittedStatusVal    = []int{-1, 0, 1} // -1: killed 0: pending 1: filled
+	permittedTypeVal      = []int{0, 1}              // 0: buy 1: sell
+	permittedPriceTypeVal = []int{0, 1, 2, 3}        // 0: market 1: limit 2: stop market 3: stop limit
+	permittedStatusVal    = []int{-1, 0, 1, 2, 3, 4} // -1: killed 0: pending 1: filled 2: partially filled 3: untriggered 4: inactive
+	permittedTIFVal       = []int{0, 1, 2, 3, 4}     // 0: GTC 1: IOC 2: FOK 3: DAY 4: GTD
+	permittedPostOnlyVal  = []int{0, 1, 2}           // 0: none 1: reject 2: reprice
+	permittedSTPModeVal   = []int{0, 1, 2, 3, 4}     // 0: none 1: cancel newest 2: cancel oldest 3: cancel both 4: decrement and cancel
 
 )
 
+var (
+	ErrOverFilled             = errors.New("fill exceeds order quantity")
+	ErrDuplicateClientOrderID = errors.New("duplicate client_order_id")
+)
+
+// ClientOrderIDRX is what a client order id may contain, it is chosen by the user to recognise a retried submission
+var ClientOrderIDRX = regexp.MustCompile(`^[a-zA-Z0-9._:-]{1,64}$`)
+
 type Order struct {
-	ID        int64     `json:"id"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
-	UserID    int64     `json:"user_id"`
-	StockID   int64     `json:"stock_id"`
-	Type      int       `json:"type"`
-	Quantity  int       `json:"quantity"`
-	PriceType int       `json:"price_type"`
-	Price     float64   `json:"price"`
-	Status    int       `json:"status"`
-	Version   int       `json:"_"`
+	ID               int64      `json:"id"`
+	CreatedAt        time.Time  `json:"created_at"`
+	UpdatedAt        time.Time  `json:"updated_at"`
+	UserID           int64      `json:"user_id"`
+	ClientOrderID    string     `json:"client_order_id,omitempty"`
+	StockID          int64      `json:"stock_id"`
+	Type             int        `json:"type"`
+	Quantity         int        `json:"quantity"`
+	FilledQuantity   int        `json:"filled_quantity"`
+	DisplayQuantity  int        `json:"display_quantity,omitempty"`
+	PriceType        int        `json:"price_type"`
+	Price            Money      `json:"price"`
+	TriggerPrice     Money      `json:"trigger_price,omitempty"`
+	TriggerDirection int        `json:"trigger_direction,omitempty"`
+	TriggeredAt      *time.Time `json:"triggered_at,omitempty"`
+	ProtectionPrice  Money      `json:"protection_price,omitempty"`
+	TrailAmount      Money      `json:"trail_amount,omitempty"`
+	TrailBps         int        `json:"trail_bps,omitempty"`
+	WaterMark        Money      `json:"water_mark,omitempty"`
+	PostOnly         int        `json:"post_only,omitempty"`
+	ReduceOnly       bool       `json:"reduce_only,omitempty"`
+	STPMode          int        `json:"stp_mode,omitempty"`
+	GroupID          *int64     `json:"group_id,omitempty"`
+	Leg              int        `json:"leg,omitempty"`
+	TimeInForce      int        `json:"time_in_force"`
+	ExpiresAt        *time.Time `json:"expires_at,omitempty"`
+	Status           int        `json:"status"`
+	QueuedAt         time.Time  `json:"-"` // when the order last reached the book, it keeps its place in the queue from then on
+	Version          int        `json:"-"`
+}
+
+// orderColumns and fields keep every order query selecting and scanning the same columns in the same order
+const orderColumns = `id, created_at, updated_at, user_id, COALESCE(client_order_id, ''), stock_id, type, quantity, filled_quantity, display_quantity, price_type, price,
+						trigger_price, trigger_direction, triggered_at, protection_price, trail_amount, trail_bps, water_mark, post_only, reduce_only, stp_mode, group_id, leg, time_in_force, expires_at, status, queued_at, version`
+
+func (o *Order) fields() []any {
+	return []any{
+		&o.ID,
+		&o.CreatedAt,
+		&o.UpdatedAt,
+		&o.UserID,
+		&o.ClientOrderID,
+		&o.StockID,
+		&o.Type,
+		&o.Quantity,
+		&o.FilledQuantity,
+		&o.DisplayQuantity,
+		&o.PriceType,
+		&o.Price,
+		&o.TriggerPrice,
+		&o.TriggerDirection,
+		&o.TriggeredAt,
+		&o.ProtectionPrice,
+		&o.TrailAmount,
+		&o.TrailBps,
+		&o.WaterMark,
+		&o.PostOnly,
+		&o.ReduceOnly,
+		&o.STPMode,
+		&o.GroupID,
+		&o.Leg,
+		&o.TimeInForce,
+		&o.ExpiresAt,
+		&o.Status,
+		&o.QueuedAt,
+		&o.Version,
+	}
+}
+
+// IsImmediate reports whether the order is matched right away and never rests in the book
+func (o *Order) IsImmediate() bool {
+	return o.TimeInForce == ORDER_TIF_IOC || o.TimeInForce == ORDER_TIF_FOK
+}
+
+// IsTrailing reports whether the trigger price of a stop order follows the stock price
+func (o *Order) IsTrailing() bool {
+	return o.TrailAmount != 0 || o.TrailBps != 0
+}
+
+// IsOpen reports whether the order can still be matched, now or once it is triggered or armed
+func (o *Order) IsOpen() bool {
+	switch o.Status {
+	case ORDER_STATUS_PENDING, ORDER_STATUS_PARTIALLY_FILLED, ORDER_STATUS_UNTRIGGERED, ORDER_STATUS_INACTIVE:
+		return true
+	default:
+		return false
+	}
+}
+
+// HoldsReservation reports whether funds or shares are held back for the open quantity of the order.
+// The exit legs of a bracket wait for their entry without one,
+// and a stop-loss leg shares the reservation of its take-profit leg until it fires.
+func (o *Order) HoldsReservation() bool {
+	switch {
+	case o.Status == ORDER_STATUS_INACTIVE:
+		return false
+	case o.Leg == ORDER_LEG_STOP_LOSS && o.Status == ORDER_STATUS_UNTRIGGERED:
+		return false
+	default:
+		return true
+	}
+}
+
+// IsMarket reports whether the order executes at the prices in the book, bounded by its protection price
+func (o *Order) IsMarket() bool {
+	return o.PriceType == ORDER_PRCIE_TYPE_MARKET || o.PriceType == ORDER_PRICE_TYPE_STOP_MARKET
+}
+
+// IsStop reports whether the order waits for a trigger price before it reaches the book
+func (o *Order) IsStop() bool {
+	return o.PriceType == ORDER_PRICE_TYPE_STOP_MARKET || o.PriceType == ORDER_PRICE_TYPE_STOP_LIMIT
+}
+
+// OrderFilter narrows down a user's orders, nil fields are not filtered on
+type OrderFilter struct {
+	StockID *int64
+	Status  *int
+	Type    *int
+	From    *time.Time
+	To      *time.Time
+}
+
+func ValidateOrderFilter(v *validator.Validator, filter OrderFilter) {
+	if filter.Status != nil {
+		v.Check(validator.PermittedValue(*filter.Status, permittedStatusVal...), "status", "invalid status value")
+	}
+	if filter.Type != nil {
+		v.Check(validator.PermittedValue(*filter.Type, permittedTypeVal...), "type", "invalid type value")
+	}
+	if filter.From != nil && filter.To != nil {
+		v.Check(!filter.From.After(*filter.To), "from", "must not be after to")
+	}
 }
 
 func ValidateOrder(v *validator.Validator, order Order) {
@@ -56,21 +240,106 @@ func ValidateOrder(v *validator.Validator, order Order) {
 	v.Check(validator.PermittedValue(order.Status, permittedStatusVal...), "status", "invalid status value")
 	v.Check(order.Quantity > 0, "quantity", "quantity must be positive")
 	v.Check(order.Price > 0, "price", "price must be positive")
+	v.Check(order.Price.IsMultipleOf(TickSize()), "price", "price must be a multiple of the tick size "+TickSize().String())
+	if order.IsStop() {
+		v.Check(order.TriggerPrice > 0, "trigger_price", "trigger price must be positive")
+		v.Check(order.TriggerPrice.IsMultipleOf(TickSize()), "trigger_price", "trigger price must be a multiple of the tick size "+TickSize().String())
+		v.Check(validator.PermittedValue(order.TriggerDirection, TRIGGER_DIRECTION_RISE, TRIGGER_DIRECTION_FALL), "trigger_price", "trigger price must differ from the current price")
+	} else {
+		v.Check(order.TriggerPrice == 0, "trigger_price", "trigger price is only allowed for stop orders")
+	}
+	if order.IsTrailing() {
+		v.Check(order.IsStop(), "trail_amount", "a trail is only allowed for stop orders")
+		v.Check(order.TrailAmount == 0 || order.TrailBps == 0, "trail_amount", "either trail_amount or trail_bps can be given, not both")
+		v.Check(order.TrailAmount >= 0, "trail_amount", "trail amount must be positive")
+		v.Check(order.TrailAmount.IsMultipleOf(TickSize()), "trail_amount", "trail amount must be a multiple of the tick size "+TickSize().String())
+		v.Check(order.TrailBps >= 0 && order.TrailBps < 10_000, "trail_bps", "trail must be between 1 and 9999 basis points")
+	}
+	if order.IsMarket() {
+		v.Check(order.ProtectionPrice > 0, "protection_price", "market orders need a positive protection_price or a max_slippage_bps")
+		v.Check(order.ProtectionPrice.IsMultipleOf(TickSize()), "protection_price", "protection price must be a multiple of the tick size "+TickSize().String())
+	} else {
+		v.Check(order.ProtectionPrice == 0, "protection_price", "protection price is only allowed for market orders")
+	}
+	v.Check(validator.PermittedValue(order.TimeInForce, permittedTIFVal...), "time_in_force", "invalid time_in_force value")
+	if order.IsMarket() {
+		v.Check(order.IsImmediate(), "time_in_force", "market orders can only be IOC or FOK")
+	}
+	if order.DisplayQuantity != 0 {
+		v.Check(order.DisplayQuantity > 0, "display_quantity", "display quantity must be positive")
+		v.Check(order.DisplayQuantity < order.Quantity, "display_quantity", "display quantity must be less than the quantity")
+		v.Check(!order.IsMarket() && !order.IsImmediate(), "display_quantity", "only limit orders that rest in the book can be iceberg orders")
+	}
+	v.Check(validator.PermittedValue(order.PostOnly, permittedPostOnlyVal...), "post_only", "invalid post_only value")
+	if order.PostOnly != ORDER_POST_ONLY_NONE {
+		v.Check(order.PriceType == ORDER_PRICE_TYPE_LIMIT || order.PriceType == ORDER_PRICE_TYPE_STOP_LIMIT, "post_only", "only limit and stop limit orders can be post-only")
+		v.Check(!order.IsImmediate(), "post_only", "IOC and FOK orders can not be post-only")
+	}
+	ValidateSTPMode(v, order.STPMode)
+	if order.ClientOrderID != "" {
+		ValidateClientOrderID(v, order.ClientOrderID)
+	}
+	if order.ReduceOnly {
+		v.Check(order.Type == ORDER_TYPE_SELL, "reduce_only", "only sell orders can be reduce-only")
+	}
+	if order.TimeInForce == ORDER_TIF_GTD {
+		v.Check(order.ExpiresAt != nil, "expires_at", "expires_at must be provided for GTD orders")
+		v.Check(order.ExpiresAt == nil || order.ExpiresAt.After(time.Now()), "expires_at", "expires_at must be in the future")
+	} else {
+		v.Check(order.ExpiresAt == nil, "expires_at", "expires_at is only allowed for GTD orders")
+	}
+}
+
+func ValidateClientOrderID(v *validator.Validator, clientOrderID string) {
+	v.Check(validator.Matches(clientOrderID, ClientOrderIDRX), "client_order_id", "must be 1 to 64 letters, digits or . _ : -")
+}
+
+func ValidateSTPMode(v *validator.Validator, mode int) {
+	v.Check(validator.PermittedValue(mode, permittedSTPModeVal...), "stp_mode", "invalid stp_mode value")
+}
+
+// ValidateAmendment checks the new price and quantity of an order, nil values are not changed
+func ValidateAmendment(v *validator.Validator, price *Money, quantity *int) {
+	v.Check(price != nil || quantity != nil, "price", "price or quantity must be provided")
+	if price != nil {
+		v.Check(*price > 0, "price", "price must be positive")
+		v.Check(price.IsMultipleOf(TickSize()), "price", "price must be a multiple of the tick size "+TickSize().String())
+	}
+	if quantity != nil {
+		v.Check(*quantity > 0, "quantity", "quantity must be positive")
+	}
 }
 
 func (m OrderModel) Insert(order *Order) error {
-	query := `INSERT INTO orders (user_id, stock_id, type, quantity, price_type, price, status)
-						VALUES($1, $2, $3, $4, $5, $6, $7)
-						RETURNING id, created_at, version`
+	query := `INSERT INTO orders (user_id, stock_id, type, quantity, display_quantity, price_type, price, trigger_price, trigger_direction, protection_price,
+						trail_amount, trail_bps, water_mark, post_only, reduce_only, stp_mode, group_id, leg, time_in_force, expires_at, status, client_order_id)
+						VALUES($1, $2, $3, $4, $5, $6, $7, NULLIF($8::decimal, 0), $9, NULLIF($10::decimal, 0),
+						NULLIF($11::decimal, 0), $12, NULLIF($13::decimal, 0), $14, $15, $16, $17, $18, $19, $20, $21, NULLIF($22, ''))
+						RETURNING id, created_at, queued_at, version`
 
 	args := []any{
 		order.UserID,
 		order.StockID,
 		order.Type,
 		order.Quantity,
+		order.DisplayQuantity,
 		order.PriceType,
 		order.Price,
+		order.TriggerPrice,
+		order.TriggerDirection,
+		order.ProtectionPrice,
+		order.TrailAmount,
+		order.TrailBps,
+		order.WaterMark,
+		order.PostOnly,
+		order.ReduceOnly,
+		order.STPMode,
+		order.GroupID,
+		order.Leg,
+		order.TimeInForce,
+		order.ExpiresAt,
 		order.Status,
+		order.ClientOrderID,
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
@@ -79,14 +348,24 @@ func (m OrderModel) Insert(order *Order) error {
 	err := m.DB.QueryRowContext(ctx, query, args...).Scan(
 		&order.ID,
 		&order.CreatedAt,
+		&order.QueuedAt,
 		&order.Version,
 	)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "orders_user_id_client_order_id_idx"`:
+			return ErrDuplicateClientOrderID
+		default:
+			return err
+		}
+	}
 
-	return err
+	return nil
 }
 func (m OrderModel) GetOrderForUpdate(orderID int64) (*Order, error) {
-	query := `SELECT id, user_id, quantity, price, stock_id, status, version FROM orders
-						WHERE id = $1`
+	query := `SELECT ` + orderColumns + ` FROM orders
+						WHERE id = $1
+						FOR UPDATE`
 
 	args := []any{orderID}
 
@@ -95,15 +374,7 @@ func (m OrderModel) GetOrderForUpdate(orderID int64) (*Order, error) {
 
 	var order Order
 
-	err := m.DB.QueryRowContext(ctx, query, args...).Scan(
-		&order.ID,
-		&order.UserID,
-		&order.Quantity,
-		&order.Price,
-		&order.StockID,
-		&order.Status,
-		&order.Version,
-	)
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(order.fields()...)
 
 	if err != nil {
 		switch {
@@ -117,20 +388,432 @@ func (m OrderModel) GetOrderForUpdate(orderID int64) (*Order, error) {
 	return &order, nil
 }
 func (m OrderModel) UpdateOrderStatus(order *Order, staus int) error {
-	query := `UPDATE orders SET status = $1, updated_at = $2, version = version + 1
-						WHERE id=$3 AND version=$4`
+	query := `UPDATE orders SET status = $1, updated_at = $2, queued_at = $3, version = version + 1
+						WHERE id=$4 AND version=$5
+						RETURNING version`
 
 	args := []any{
 		staus,
 		order.UpdatedAt,
+		order.QueuedAt,
+		order.ID,
+		order.Version,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&order.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+	order.Status = staus
+
+	return nil
+}
+
+// Amend writes a new price and quantity of an open order and when it reached the book
+func (m OrderModel) Amend(order *Order) error {
+	query := `UPDATE orders SET price = $1, quantity = $2, updated_at = $3, queued_at = $4, version = version + 1
+						WHERE id = $5 AND version = $6
+						RETURNING version`
+
+	args := []any{
+		order.Price,
+		order.Quantity,
+		order.UpdatedAt,
+		order.QueuedAt,
+		order.ID,
+		order.Version,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&order.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// RemainingQuantity is the part of the order that has not been filled yet
+func (o *Order) RemainingQuantity() int {
+	return o.Quantity - o.FilledQuantity
+}
+
+// UpdateOrderFill adds a fill of quantity shares to the order.
+// A killed order keeps its status, the rest is marked filled or partially filled.
+func (m OrderModel) UpdateOrderFill(order *Order, quantity int) error {
+	filledQuantity := order.FilledQuantity + quantity
+	if filledQuantity > order.Quantity {
+		return ErrOverFilled
+	}
+
+	status := order.Status
+	if status != ORDER_STATUS_KILLED {
+		status = ORDER_STATUS_PARTIALLY_FILLED
+		if filledQuantity == order.Quantity {
+			status = ORDER_STATUS_FILLED
+		}
+	}
+
+	query := `UPDATE orders SET filled_quantity = $1, status = $2, updated_at = $3, version = version + 1
+						WHERE id=$4 AND version=$5
+						RETURNING version`
+
+	args := []any{
+		filledQuantity,
+		status,
+		order.UpdatedAt,
+		order.ID,
+		order.Version,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&order.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+	order.FilledQuantity = filledQuantity
+	order.Status = status
+
+	return nil
+}
+
+func (m OrderModel) GetForUser(orderID, userID int64) (*Order, error) {
+	query := `SELECT ` + orderColumns + `
+						FROM orders
+						WHERE id = $1 AND user_id = $2`
+
+	args := []any{orderID, userID}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var order Order
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(order.fields()...)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &order, nil
+}
+
+// GetForClientOrderID finds an order of the user by the client order id it was placed with
+func (m OrderModel) GetForClientOrderID(clientOrderID string, userID int64) (*Order, error) {
+	query := `SELECT ` + orderColumns + `
+						FROM orders
+						WHERE client_order_id = $1 AND user_id = $2`
+
+	args := []any{clientOrderID, userID}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var order Order
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(order.fields()...)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &order, nil
+}
+
+// GetOpenForUpdate locks the open orders of a user, oldest first. Only the stock and type of filter are applied
+func (m OrderModel) GetOpenForUpdate(userID int64, filter OrderFilter) ([]*Order, error) {
+	query := `SELECT ` + orderColumns + `
+						FROM orders
+						WHERE user_id = $1
+						AND status IN ($2, $3, $4, $5)
+						AND ($6::bigint IS NULL OR stock_id = $6)
+						AND ($7::integer IS NULL OR type = $7)
+						ORDER BY id
+						FOR UPDATE`
+
+	args := []any{
+		userID,
+		ORDER_STATUS_PENDING,
+		ORDER_STATUS_PARTIALLY_FILLED,
+		ORDER_STATUS_UNTRIGGERED,
+		ORDER_STATUS_INACTIVE,
+		filter.StockID,
+		filter.Type,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	orders := []*Order{}
+	for rows.Next() {
+		var order Order
+		err := rows.Scan(order.fields()...)
+		if err != nil {
+			return nil, err
+		}
+		orders = append(orders, &order)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return orders, nil
+}
+
+func (m OrderModel) GetAllForUser(userID int64, filter OrderFilter, filters Filters) ([]*Order, Metadata, error) {
+	query := fmt.Sprintf(`SELECT count(*) OVER(), %s
+						FROM orders
+						WHERE user_id = $1
+						AND ($2::bigint IS NULL OR stock_id = $2)
+						AND ($3::integer IS NULL OR status = $3)
+						AND ($4::integer IS NULL OR type = $4)
+						AND ($5::timestamp IS NULL OR created_at >= $5)
+						AND ($6::timestamp IS NULL OR created_at <= $6)
+						ORDER BY %s %s, id ASC
+						LIMIT $7 OFFSET $8`, orderColumns, filters.sortColumn(), filters.sortDirection())
+
+	args := []any{
+		userID,
+		filter.StockID,
+		filter.Status,
+		filter.Type,
+		filter.From,
+		filter.To,
+		filters.limit(),
+		filters.offset(),
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	orders := []*Order{}
+
+	for rows.Next() {
+		var order Order
+		err := rows.Scan(append([]any{&totalRecords}, order.fields()...)...)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		orders = append(orders, &order)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return orders, metadata, nil
+}
+
+// OrderReservation is what a user's open orders of one stock and side hold back,
+// Amount is the cash reserved by buy orders and Quantity the unfilled shares
+type OrderReservation struct {
+	StockID  int64
+	Type     int
+	Amount   Money
+	Quantity int
+}
+
+func (m OrderModel) GetOpenReservations(userID int64) ([]*OrderReservation, error) {
+	query := `SELECT stock_id, type, COALESCE(SUM(price * (quantity - filled_quantity)), 0), COALESCE(SUM(quantity - filled_quantity), 0)
+						FROM orders
+						WHERE user_id = $1 AND status IN ($2, $3, $4) AND NOT (leg = $5 AND status = $4)
+						GROUP BY stock_id, type`
+
+	// an untriggered stop-loss leg is covered by the reservation of its take-profit leg
+	args := []any{userID, ORDER_STATUS_PENDING, ORDER_STATUS_PARTIALLY_FILLED, ORDER_STATUS_UNTRIGGERED, ORDER_LEG_STOP_LOSS}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	reservations := []*OrderReservation{}
+	for rows.Next() {
+		var reservation OrderReservation
+		err = rows.Scan(
+			&reservation.StockID,
+			&reservation.Type,
+			&reservation.Amount,
+			&reservation.Quantity,
+		)
+		if err != nil {
+			return nil, err
+		}
+		reservations = append(reservations, &reservation)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return reservations, nil
+}
+
+// GetUnreservedSellQuantity returns the unfilled shares of a user's open sell orders of a stock that hold no reservation,
+// the exit legs that wait for their entry and the untriggered stop-loss legs, see Order.HoldsReservation.
+// The legs of the group with groupID are left out, they exit the same position as the order that is checked.
+func (m OrderModel) GetUnreservedSellQuantity(userID, stockID int64, groupID *int64) (int, error) {
+	query := `SELECT COALESCE(SUM(quantity - filled_quantity), 0)
+						FROM orders
+						WHERE user_id = $1 AND stock_id = $2 AND type = $3 AND (status = $4 OR (leg = $5 AND status = $6))
+						AND ($7::bigint IS NULL OR group_id IS DISTINCT FROM $7)`
+
+	args := []any{userID, stockID, ORDER_TYPE_SELL, ORDER_STATUS_INACTIVE, ORDER_LEG_STOP_LOSS, ORDER_STATUS_UNTRIGGERED, groupID}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var quantity int
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&quantity)
+	if err != nil {
+		return 0, err
+	}
+	return quantity, nil
+}
+
+// GetOpenForStock returns the pending and partially filled orders of a stock that rest in the book,
+// in the order they last reached it. IOC and FOK orders never rest in the book and are left out.
+func (m OrderModel) GetOpenForStock(stockID int64) ([]*Order, error) {
+	query := `SELECT ` + orderColumns + `
+						FROM orders
+						WHERE stock_id = $1 AND status IN ($2, $3) AND time_in_force NOT IN ($4, $5)
+						ORDER BY queued_at, id`
+
+	args := []any{stockID, ORDER_STATUS_PENDING, ORDER_STATUS_PARTIALLY_FILLED, ORDER_TIF_IOC, ORDER_TIF_FOK}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	orders := []*Order{}
+	for rows.Next() {
+		var order Order
+		err := rows.Scan(order.fields()...)
+		if err != nil {
+			return nil, err
+		}
+		orders = append(orders, &order)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return orders, nil
+}
+
+// Trail writes the trigger price and the water mark a trailing stop moved to,
+// together with the limit and protection price that move along with the trigger price
+func (m OrderModel) Trail(order *Order) error {
+	query := `UPDATE orders SET trigger_price = $1, water_mark = $2, price = $3, protection_price = NULLIF($4::decimal, 0), updated_at = $5, version = version + 1
+						WHERE id = $6 AND version = $7 AND status IN ($8, $9)
+						RETURNING version`
+
+	args := []any{
+		order.TriggerPrice,
+		order.WaterMark,
+		order.Price,
+		order.ProtectionPrice,
+		order.UpdatedAt,
+		order.ID,
+		order.Version,
+		ORDER_STATUS_UNTRIGGERED,
+		ORDER_STATUS_INACTIVE,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&order.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Trigger converts an untriggered stop order into a live market or limit order at order.Price,
+// the grace period of an IOC or FOK order starts when it fires
+func (m OrderModel) Trigger(order *Order, triggeredAt time.Time) error {
+	query := `UPDATE orders SET status = $1, price = $2, triggered_at = $3, updated_at = $3, queued_at = $3, expires_at = $4, version = version + 1
+						WHERE id = $5 AND version = $6 AND status = $7
+						RETURNING version`
+
+	expiresAt := order.ExpiresAt
+	if order.IsImmediate() {
+		gracePeriodEnd := triggeredAt.Add(ImmediateOrderGrace)
+		expiresAt = &gracePeriodEnd
+	}
+
+	args := []any{
+		ORDER_STATUS_PENDING,
+		order.Price,
+		triggeredAt,
+		expiresAt,
 		order.ID,
 		order.Version,
+		ORDER_STATUS_UNTRIGGERED,
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	_, err := m.DB.ExecContext(ctx, query, args...)
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&order.Version)
 	if err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
@@ -139,6 +822,107 @@ func (m OrderModel) UpdateOrderStatus(order *Order, staus int) error {
 			return err
 		}
 	}
+	order.Status = ORDER_STATUS_PENDING
+	order.TriggeredAt = &triggeredAt
+	order.ExpiresAt = expiresAt
+	order.UpdatedAt = triggeredAt
+	order.QueuedAt = triggeredAt
 
 	return nil
 }
+
+// GetUntriggered returns every stop order that is still waiting for its trigger price
+func (m OrderModel) GetUntriggered() ([]*Order, error) {
+	query := `SELECT ` + orderColumns + `
+						FROM orders
+						WHERE status = $1
+						ORDER BY created_at, id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, ORDER_STATUS_UNTRIGGERED)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	orders := []*Order{}
+	for rows.Next() {
+		var order Order
+		err := rows.Scan(order.fields()...)
+		if err != nil {
+			return nil, err
+		}
+		orders = append(orders, &order)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return orders, nil
+}
+
+// GetAllForGroup returns the orders of an order group by leg
+func (m OrderModel) GetAllForGroup(groupID int64) ([]*Order, error) {
+	query := `SELECT ` + orderColumns + `
+						FROM orders
+						WHERE group_id = $1
+						ORDER BY leg, id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, groupID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	orders := []*Order{}
+	for rows.Next() {
+		var order Order
+		err := rows.Scan(order.fields()...)
+		if err != nil {
+			return nil, err
+		}
+		orders = append(orders, &order)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return orders, nil
+}
+
+// GetExpired returns up to limit open orders whose expires_at has passed, the longest expired first
+func (m OrderModel) GetExpired(now time.Time, limit int) ([]*Order, error) {
+	query := `SELECT ` + orderColumns + `
+						FROM orders
+						WHERE status IN ($1, $2, $3) AND expires_at <= $4
+						ORDER BY expires_at, id
+						LIMIT $5`
+
+	args := []any{ORDER_STATUS_PENDING, ORDER_STATUS_PARTIALLY_FILLED, ORDER_STATUS_UNTRIGGERED, now, limit}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	orders := []*Order{}
+	for rows.Next() {
+		var order Order
+		err := rows.Scan(order.fields()...)
+		if err != nil {
+			return nil, err
+		}
+		orders = append(orders, &order)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return orders, nil
+}
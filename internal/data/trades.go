@@ -2,30 +2,43 @@ package data
 
 import (
 	"context"
+	"database/sql"
+	"errors"
+	"fmt"
 	"time"
+
+	"github.com/maxwellkuo47/tradingEngine/internal/validator"
 )
 
 type TradeModel struct {
 	DB DBTX
 }
 
+// Trade is one side of an execution, every match writes a trade for the buyer and one for the seller
+// and both of them link the matched buy and sell order
 type Trade struct {
-	ID         int64     `json:"id"`
-	UserID     int64     `json:"user_id"`
-	OrderID    int64     `json:"order_id"`
-	Quantity   int       `json:"quantity"`
-	Price      float64   `json:"price"`
-	ExecutedAt time.Time `json:"executed_at"`
+	ID          int64     `json:"id"`
+	UserID      int64     `json:"user_id"`
+	OrderID     int64     `json:"order_id"`
+	StockID     int64     `json:"stock_id"`
+	Type        int       `json:"type"`
+	BuyOrderID  int64     `json:"buy_order_id,omitempty"`
+	SellOrderID int64     `json:"sell_order_id,omitempty"`
+	Quantity    int       `json:"quantity"`
+	Price       Money     `json:"price"`
+	ExecutedAt  time.Time `json:"executed_at"`
 }
 
 func (m TradeModel) Insert(trade Trade) error {
 
-	query := `INSERT INTO trades (user_id, order_id, quantity, price, executed_at)
-						VALUES ($1, $2, $3, $4, $5)`
+	query := `INSERT INTO trades (user_id, order_id, buy_order_id, sell_order_id, quantity, price, executed_at)
+						VALUES ($1, $2, $3, $4, $5, $6, $7)`
 
 	args := []any{
 		trade.UserID,
 		trade.OrderID,
+		trade.BuyOrderID,
+		trade.SellOrderID,
 		trade.Quantity,
 		trade.Price,
 		trade.ExecutedAt,
@@ -37,3 +50,212 @@ func (m TradeModel) Insert(trade Trade) error {
 	_, err := m.DB.ExecContext(ctx, query, args...)
 	return err
 }
+
+// TradeFilter narrows down a user's trades, nil fields are not filtered on
+type TradeFilter struct {
+	OrderID *int64
+	StockID *int64
+	From    *time.Time
+	To      *time.Time
+}
+
+func ValidateTradeFilter(v *validator.Validator, filter TradeFilter) {
+	if filter.From != nil && filter.To != nil {
+		v.Check(!filter.From.After(*filter.To), "from", "must not be after to")
+	}
+}
+
+func (m TradeModel) GetAllForUser(userID int64, filter TradeFilter, filters Filters) ([]*Trade, Metadata, error) {
+	query := fmt.Sprintf(`SELECT count(*) OVER(), trades.id, trades.user_id, trades.order_id, orders.stock_id, orders.type,
+						COALESCE(trades.buy_order_id, 0), COALESCE(trades.sell_order_id, 0),
+						trades.quantity, trades.price, trades.executed_at
+						FROM trades
+						INNER JOIN orders
+						ON orders.id = trades.order_id
+						WHERE trades.user_id = $1
+						AND ($2::bigint IS NULL OR trades.order_id = $2)
+						AND ($3::bigint IS NULL OR orders.stock_id = $3)
+						AND ($4::timestamp IS NULL OR trades.executed_at >= $4)
+						AND ($5::timestamp IS NULL OR trades.executed_at <= $5)
+						ORDER BY trades.%s %s, trades.id ASC
+						LIMIT $6 OFFSET $7`, filters.sortColumn(), filters.sortDirection())
+
+	args := []any{
+		userID,
+		filter.OrderID,
+		filter.StockID,
+		filter.From,
+		filter.To,
+		filters.limit(),
+		filters.offset(),
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	trades := []*Trade{}
+
+	for rows.Next() {
+		var trade Trade
+		err := rows.Scan(
+			&totalRecords,
+			&trade.ID,
+			&trade.UserID,
+			&trade.OrderID,
+			&trade.StockID,
+			&trade.Type,
+			&trade.BuyOrderID,
+			&trade.SellOrderID,
+			&trade.Quantity,
+			&trade.Price,
+			&trade.ExecutedAt,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		trades = append(trades, &trade)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return trades, metadata, nil
+}
+
+// GetHistoryForUser returns every trade of the user in execution order
+func (m TradeModel) GetHistoryForUser(userID int64) ([]*Trade, error) {
+	query := `SELECT trades.id, trades.user_id, trades.order_id, orders.stock_id, orders.type,
+						COALESCE(trades.buy_order_id, 0), COALESCE(trades.sell_order_id, 0),
+						trades.quantity, trades.price, trades.executed_at
+						FROM trades
+						INNER JOIN orders
+						ON orders.id = trades.order_id
+						WHERE trades.user_id = $1
+						ORDER BY trades.executed_at ASC, trades.id ASC`
+
+	args := []any{userID}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	trades := []*Trade{}
+	for rows.Next() {
+		var trade Trade
+		err := rows.Scan(
+			&trade.ID,
+			&trade.UserID,
+			&trade.OrderID,
+			&trade.StockID,
+			&trade.Type,
+			&trade.BuyOrderID,
+			&trade.SellOrderID,
+			&trade.Quantity,
+			&trade.Price,
+			&trade.ExecutedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		trades = append(trades, &trade)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return trades, nil
+}
+
+// TradeStats sums up the trades of a stock in a time window, every match counts once.
+// The prices are nil when there was no trade in the window
+type TradeStats struct {
+	Trades int    `json:"trades"`
+	Volume int64  `json:"volume"`
+	High   *Money `json:"high"`
+	Low    *Money `json:"low"`
+	VWAP   *Money `json:"vwap"`
+}
+
+// GetStatsForStock returns the stats of the trades of the stock executed since
+func (m TradeModel) GetStatsForStock(stockID int64, since time.Time) (*TradeStats, error) {
+	query := `SELECT count(*), COALESCE(sum(trades.quantity), 0), max(trades.price), min(trades.price),
+						COALESCE(sum(trades.price * trades.quantity), 0)
+						FROM trades
+						INNER JOIN orders
+						ON orders.id = trades.order_id
+						WHERE orders.stock_id = $1 AND orders.type = $2
+						AND trades.executed_at >= $3`
+
+	args := []any{stockID, ORDER_TYPE_BUY, since}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var stats TradeStats
+	var high, low, notional Money
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&stats.Trades, &stats.Volume, &high, &low, &notional)
+	if err != nil {
+		return nil, err
+	}
+	if stats.Trades > 0 {
+		// the notional is a whole number of units of quantity one, dividing by the volume rounds it to money precision
+		vwap := notional.Div(int(stats.Volume))
+		stats.High, stats.Low, stats.VWAP = &high, &low, &vwap
+	}
+	return &stats, nil
+}
+
+// GetLastForStock returns the buy side of the latest trade of the stock
+func (m TradeModel) GetLastForStock(stockID int64) (*Trade, error) {
+	query := `SELECT trades.id, trades.user_id, trades.order_id, orders.stock_id, orders.type,
+						COALESCE(trades.buy_order_id, 0), COALESCE(trades.sell_order_id, 0),
+						trades.quantity, trades.price, trades.executed_at
+						FROM trades
+						INNER JOIN orders
+						ON orders.id = trades.order_id
+						WHERE orders.stock_id = $1 AND orders.type = $2
+						ORDER BY trades.executed_at DESC, trades.id DESC
+						LIMIT 1`
+
+	args := []any{stockID, ORDER_TYPE_BUY}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var trade Trade
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(
+		&trade.ID,
+		&trade.UserID,
+		&trade.OrderID,
+		&trade.StockID,
+		&trade.Type,
+		&trade.BuyOrderID,
+		&trade.SellOrderID,
+		&trade.Quantity,
+		&trade.Price,
+		&trade.ExecutedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &trade, nil
+}
@@ -0,0 +1,190 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// kinds of user event
+const (
+	USER_EVENT_ORDER_ACCEPTED         = "order_accepted"
+	USER_EVENT_ORDER_PARTIALLY_FILLED = "order_partially_filled"
+	USER_EVENT_ORDER_FILLED           = "order_filled"
+	USER_EVENT_ORDER_REDUCED          = "order_reduced"
+	USER_EVENT_ORDER_AMENDED          = "order_amended"
+	USER_EVENT_ORDER_CLOSED           = "order_closed"
+)
+
+// UserEvent is a change to the orders, the wallet or the positions of a user, in the private stream of the user.
+// Data is the UserEventData of the event as JSON.
+type UserEvent struct {
+	ID        int64           `json:"id"`
+	UserID    int64           `json:"-"`
+	Type      string          `json:"type"`
+	OrderID   int64           `json:"order_id"`
+	Data      json.RawMessage `json:"data"`
+	CreatedAt time.Time       `json:"created_at"`
+	XactID    string          `json:"-"`
+}
+
+// UserEventData is what an event carries: the order as it is after the change, the fill that caused it,
+// the changes of the wallet and of the position, and for a closed order the kind of order event that closed it
+type UserEventData struct {
+	Order    *Order         `json:"order"`
+	Fill     *Fill          `json:"fill,omitempty"`
+	Wallet   *WalletDelta   `json:"wallet,omitempty"`
+	Position *PositionDelta `json:"position,omitempty"`
+	Reason   string         `json:"reason,omitempty"`
+	Message  string         `json:"message,omitempty"`
+}
+
+// Fill is one execution of an order
+type Fill struct {
+	MatchID    string    `json:"match_id"`
+	Quantity   int       `json:"quantity"`
+	Price      Money     `json:"price"`
+	ExecutedAt time.Time `json:"executed_at"`
+}
+
+// WalletDelta is how much the available cash and the cash reserved for open buy orders changed
+type WalletDelta struct {
+	Balance  Money `json:"balance"`
+	Reserved Money `json:"reserved"`
+}
+
+// PositionDelta is how many available shares and shares reserved for open sell orders of a stock changed
+type PositionDelta struct {
+	StockID  int64 `json:"stock_id"`
+	Quantity int   `json:"quantity"`
+	Reserved int   `json:"reserved"`
+}
+
+// UserEventCursor is the position of an event in the stream of a user.
+// Events are streamed in the order their transactions were written, not by id,
+// so an event that was committed after a later one is still delivered.
+type UserEventCursor struct {
+	XactID string
+	ID     int64
+}
+
+// Cursor returns the position right after event
+func (e *UserEvent) Cursor() UserEventCursor {
+	return UserEventCursor{XactID: e.XactID, ID: e.ID}
+}
+
+type UserEventModel struct {
+	DB DBTX
+}
+
+// Insert writes an event with data, the event becomes visible to the stream with the transaction
+func (m UserEventModel) Insert(event *UserEvent, data UserEventData) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	event.Data = payload
+
+	query := `INSERT INTO user_events (user_id, type, order_id, data, created_at)
+						VALUES ($1, $2, $3, $4, $5)
+						RETURNING id, pg_current_xact_id()::text`
+
+	args := []any{
+		event.UserID,
+		event.Type,
+		event.OrderID,
+		string(event.Data), // lib/pq would send bytes as bytea
+		event.CreatedAt,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&event.ID, &event.XactID)
+}
+
+// GetCursor returns the position of an event of the user
+func (m UserEventModel) GetCursor(userID, eventID int64) (UserEventCursor, error) {
+	query := `SELECT xact_id::text, id
+						FROM user_events
+						WHERE id = $1 AND user_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var cursor UserEventCursor
+	err := m.DB.QueryRowContext(ctx, query, eventID, userID).Scan(&cursor.XactID, &cursor.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return cursor, ErrRecordNotFound
+		default:
+			return cursor, err
+		}
+	}
+	return cursor, nil
+}
+
+// GetLatestCursor returns the position after the last event of the user that can be streamed,
+// the start of the stream when there is none
+func (m UserEventModel) GetLatestCursor(userID int64) (UserEventCursor, error) {
+	query := `SELECT xact_id::text, id
+						FROM user_events
+						WHERE user_id = $1 AND xact_id < pg_snapshot_xmin(pg_current_snapshot())
+						ORDER BY xact_id DESC, id DESC
+						LIMIT 1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	cursor := UserEventCursor{XactID: "0"}
+	err := m.DB.QueryRowContext(ctx, query, userID).Scan(&cursor.XactID, &cursor.ID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return cursor, err
+	}
+	return cursor, nil
+}
+
+// GetAfter returns up to limit events of the user after cursor. An event is only returned once every transaction
+// that started before it has finished, no event can show up before it later on.
+func (m UserEventModel) GetAfter(userID int64, cursor UserEventCursor, limit int) ([]*UserEvent, error) {
+	query := `SELECT id, user_id, type, order_id, data, created_at, xact_id::text
+						FROM user_events
+						WHERE user_id = $1 AND (xact_id, id) > ($2::xid8, $3)
+							AND xact_id < pg_snapshot_xmin(pg_current_snapshot())
+						ORDER BY xact_id, id
+						LIMIT $4`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID, cursor.XactID, cursor.ID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []*UserEvent{}
+	for rows.Next() {
+		var event UserEvent
+		err := rows.Scan(
+			&event.ID,
+			&event.UserID,
+			&event.Type,
+			&event.OrderID,
+			(*[]byte)(&event.Data),
+			&event.CreatedAt,
+			&event.XactID,
+		)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, &event)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
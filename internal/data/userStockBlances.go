@@ -96,3 +96,42 @@ func (m UserStockBalanceModel) Update(stockBalance *UserStockBalance) error {
 	}
 	return nil
 }
+
+func (m UserStockBalanceModel) GetAllForUser(userID int64) ([]*UserStockBalance, error) {
+	query := `SELECT id, user_id, stock_id, quantity, updated_at, version
+						FROM user_stock_balances
+						WHERE user_id = $1
+						ORDER BY stock_id`
+
+	args := []any{userID}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	stockBalances := []*UserStockBalance{}
+	for rows.Next() {
+		var stockBalance UserStockBalance
+		err = rows.Scan(
+			&stockBalance.ID,
+			&stockBalance.UserID,
+			&stockBalance.StockID,
+			&stockBalance.Quantity,
+			&stockBalance.UpdatedAt,
+			&stockBalance.Version,
+		)
+		if err != nil {
+			return nil, err
+		}
+		stockBalances = append(stockBalances, &stockBalance)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return stockBalances, nil
+}
@@ -18,7 +18,7 @@ var (
 type UserWallet struct {
 	ID       int64     `json:"id"`
 	UserID   int64     `json:"user_id"`
-	Balance  float64   `json:"balance"`
+	Balance  Money     `json:"balance"`
 	UpdateAt time.Time `json:"updated_at"`
 	Version  int       `json:"-"`
 }
@@ -26,7 +26,7 @@ type UserWallet struct {
 func (m UserWalletModel) New(userID int64) error {
 	UserWallet := UserWallet{
 		UserID:  userID,
-		Balance: 100_000_000, // for test purpose
+		Balance: NewMoney(100_000_000), // for test purpose
 	}
 	return m.Insert(UserWallet)
 }
@@ -57,13 +57,13 @@ func (m UserWalletModel) Insert(wallet UserWallet) error {
 }
 
 func (m UserWalletModel) GetUserWallet(userID int64) (*UserWallet, error) {
-	query := `SELECT id, user_id, balance, version FROM user_wallets WHERE user_id = $1`
+	query := `SELECT id, user_id, balance, updated_at, version FROM user_wallets WHERE user_id = $1`
 	args := []any{userID}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 	var wallet UserWallet
-	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&wallet.ID, &wallet.UserID, &wallet.Balance, &wallet.Version)
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&wallet.ID, &wallet.UserID, &wallet.Balance, &wallet.UpdateAt, &wallet.Version)
 	if err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
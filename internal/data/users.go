@@ -36,6 +36,7 @@ type User struct {
 	Name      string    `json:"name"`
 	Email     string    `json:"email"`
 	Password  password  `json:"-"`
+	STPMode   int       `json:"stp_mode"`
 	Version   int       `json:"-"`
 }
 
@@ -113,8 +114,32 @@ func (m UserModel) Insert(user *User) error {
 	return nil
 }
 
+// UpdateSTPMode writes the self-trade prevention mode of the user's new orders
+func (m UserModel) UpdateSTPMode(user *User) error {
+	query := `UPDATE users SET stp_mode = $1, version = version + 1
+						WHERE id = $2 AND version = $3
+						RETURNING version`
+
+	args := []any{user.STPMode, user.ID, user.Version}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&user.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
 func (m UserModel) GetByEmail(email string) (*User, error) {
-	query := `SELECT id, created_at, name, email, password_hash, version
+	query := `SELECT id, created_at, name, email, password_hash, stp_mode, version
 						FROM users
 						WHERE email = $1`
 
@@ -128,6 +153,7 @@ func (m UserModel) GetByEmail(email string) (*User, error) {
 		&user.Name,
 		&user.Email,
 		&user.Password.hash,
+		&user.STPMode,
 		&user.Version,
 	)
 
@@ -145,7 +171,7 @@ func (m UserModel) GetByEmail(email string) (*User, error) {
 
 func (m UserModel) GetForToken(tokenPlaintext string) (*User, error) {
 	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
-	query := `SELECT users.id, users.created_at, users.name, users.email, users.password_hash, users.version
+	query := `SELECT users.id, users.created_at, users.name, users.email, users.password_hash, users.stp_mode, users.version
 						FROM users
 						INNER JOIN tokens
 						ON users.id = tokens.user_id
@@ -164,6 +190,7 @@ func (m UserModel) GetForToken(tokenPlaintext string) (*User, error) {
 		&user.Name,
 		&user.Email,
 		&user.Password.hash,
+		&user.STPMode,
 		&user.Version,
 	)
 
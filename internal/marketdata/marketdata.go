@@ -0,0 +1,329 @@
+package marketdata
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/maxwellkuo47/tradingEngine/internal/data"
+	"github.com/maxwellkuo47/tradingEngine/internal/orderbook"
+)
+
+// message types
+const (
+	TypeSnapshot = "snapshot" // the whole depth of the stock, Bids and Asks
+	TypeDepth    = "depth"    // the levels that changed since the last message, a level with quantity 0 is gone
+	TypeTrade    = "trade"    // a trade on the tape, Trade
+	TypeTicker   = "ticker"   // a new stock price, Price
+)
+
+var (
+	ErrSlowClient   = errors.New("client does not keep up with the market data")
+	ErrClientClosed = errors.New("client is closed")
+)
+
+// Trade is a public execution, Side is the side of the order that took the liquidity
+type Trade struct {
+	ID         string     `json:"id"`
+	Price      data.Money `json:"price"`
+	Quantity   int        `json:"quantity"`
+	Side       string     `json:"side"`
+	ExecutedAt time.Time  `json:"executed_at"`
+}
+
+// Message is one update of the market data of a stock.
+// Seq counts the messages of the stock without gaps, a client that sees a gap missed a message and
+// asks for a new snapshot, the messages with Seq up to the one of the snapshot are then stale.
+type Message struct {
+	Type    string            `json:"type"`
+	StockID int64             `json:"stock_id"`
+	Seq     uint64            `json:"seq"`
+	Time    time.Time         `json:"time"`
+	Bids    []orderbook.Level `json:"bids,omitempty"`
+	Asks    []orderbook.Level `json:"asks,omitempty"`
+	Trade   *Trade            `json:"trade,omitempty"`
+	Price   data.Money        `json:"price,omitempty"`
+}
+
+// Hub fans the market data of every stock out to the clients that joined it.
+// The depth is read from the order book on Refresh and sent as the levels that differ from the last depth sent.
+type Hub struct {
+	book    orderbook.OrderBook
+	levels  int
+	OnError func(error)
+
+	mu     sync.Mutex
+	stocks map[int64]*stock
+}
+
+// stock is the market data state of one stock, the depth is only kept while clients are joined
+type stock struct {
+	seq     uint64
+	loaded  bool
+	loads   uint64 // tells a refresh whether the depth was read again while it read the book
+	bids    []orderbook.Level
+	asks    []orderbook.Level
+	clients map[*Client]struct{}
+}
+
+// Client receives the messages of the stocks it joined on C until it is closed.
+// A client whose buffer is full misses messages, the sequence numbers tell it so.
+type Client struct {
+	C      <-chan Message
+	c      chan Message
+	hub    *Hub
+	stocks map[int64]struct{}
+	closed bool
+}
+
+// NewHub returns a hub that keeps levels price levels of each side of a stock
+func NewHub(book orderbook.OrderBook, levels int) *Hub {
+	return &Hub{
+		book:   book,
+		levels: levels,
+		stocks: make(map[int64]*stock),
+	}
+}
+
+func (h *Hub) stock(stockID int64) *stock {
+	st, ok := h.stocks[stockID]
+	if !ok {
+		st = &stock{clients: make(map[*Client]struct{})}
+		h.stocks[stockID] = st
+	}
+	return st
+}
+
+// Subscribe returns a client with room for buffer messages that has not joined any stock yet
+func (h *Hub) Subscribe(buffer int) *Client {
+	c := make(chan Message, buffer)
+	return &Client{C: c, c: c, hub: h, stocks: make(map[int64]struct{})}
+}
+
+// Join starts the messages of a stock with its snapshot, joining a stock again sends a new snapshot.
+// The snapshot goes through C in line with the other messages, ErrSlowClient means there was no room for it.
+func (c *Client) Join(ctx context.Context, stockID int64) error {
+	h := c.hub
+
+	h.mu.Lock()
+	loaded := h.stock(stockID).loaded
+	h.mu.Unlock()
+
+	var bids, asks []orderbook.Level
+	if !loaded {
+		var err error
+		bids, asks, err = h.depth(ctx, stockID)
+		if err != nil {
+			return err
+		}
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if c.closed {
+		return ErrClientClosed
+	}
+	st := h.stock(stockID)
+	if !st.loaded {
+		st.bids, st.asks, st.loaded = bids, asks, true
+		st.loads++
+	}
+
+	snapshot := Message{
+		Type:    TypeSnapshot,
+		StockID: stockID,
+		Seq:     st.seq,
+		Time:    time.Now(),
+		Bids:    slices.Clone(st.bids),
+		Asks:    slices.Clone(st.asks),
+	}
+	select {
+	case c.c <- snapshot:
+	default:
+		if len(st.clients) == 0 {
+			st.loaded, st.bids, st.asks = false, nil, nil
+		}
+		return ErrSlowClient
+	}
+	st.clients[c] = struct{}{}
+	c.stocks[stockID] = struct{}{}
+
+	return nil
+}
+
+// Leave stops the messages of a stock
+func (c *Client) Leave(stockID int64) {
+	c.hub.mu.Lock()
+	defer c.hub.mu.Unlock()
+
+	c.hub.leave(c, stockID)
+}
+
+func (h *Hub) leave(c *Client, stockID int64) {
+	st, ok := h.stocks[stockID]
+	if !ok {
+		return
+	}
+	delete(st.clients, c)
+	delete(c.stocks, stockID)
+	if len(st.clients) == 0 {
+		// no one follows the depth anymore, it is read again by the next Join
+		st.loaded, st.bids, st.asks = false, nil, nil
+	}
+}
+
+// Close leaves every stock and closes C, closing it again does nothing
+func (c *Client) Close() {
+	c.hub.mu.Lock()
+	defer c.hub.mu.Unlock()
+
+	if c.closed {
+		return
+	}
+	for stockID := range c.stocks {
+		c.hub.leave(c, stockID)
+	}
+	c.closed = true
+	close(c.c)
+}
+
+// publish numbers the message and hands it to the clients of the stock, h.mu must be held
+func (h *Hub) publish(st *stock, msg Message) {
+	st.seq++
+	msg.Seq = st.seq
+	for c := range st.clients {
+		select {
+		case c.c <- msg:
+		default:
+		}
+	}
+}
+
+// PublishTrade puts a trade of a stock on the tape
+func (h *Hub) PublishTrade(stockID int64, trade Trade) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	st, ok := h.stocks[stockID]
+	if !ok || len(st.clients) == 0 {
+		return
+	}
+	h.publish(st, Message{Type: TypeTrade, StockID: stockID, Time: trade.ExecutedAt, Trade: &trade})
+}
+
+// PublishTicker sends a new price of a stock
+func (h *Hub) PublishTicker(stockID int64, price data.Money, at time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	st, ok := h.stocks[stockID]
+	if !ok || len(st.clients) == 0 {
+		return
+	}
+	h.publish(st, Message{Type: TypeTicker, StockID: stockID, Time: at, Price: price})
+}
+
+// Refresh reads the depth of a stock that has clients and sends the levels that changed
+func (h *Hub) Refresh(ctx context.Context, stockID int64) error {
+	h.mu.Lock()
+	st, ok := h.stocks[stockID]
+	joined := ok && st.loaded
+	var loads uint64
+	if joined {
+		loads = st.loads
+	}
+	h.mu.Unlock()
+	if !joined {
+		return nil
+	}
+
+	bids, asks, err := h.depth(ctx, stockID)
+	if err != nil {
+		return err
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	// the last client may have left meanwhile, or left and joined again with a newer depth
+	if !st.loaded || st.loads != loads {
+		return nil
+	}
+	bidChanges := diff(st.bids, bids)
+	askChanges := diff(st.asks, asks)
+	if len(bidChanges) == 0 && len(askChanges) == 0 {
+		return nil
+	}
+	st.bids, st.asks = bids, asks
+	h.publish(st, Message{Type: TypeDepth, StockID: stockID, Time: time.Now(), Bids: bidChanges, Asks: askChanges})
+
+	return nil
+}
+
+// Run refreshes the depth of every joined stock each interval until ctx is done,
+// the errors of a refresh are passed to OnError and the stock is tried again the next time
+func (h *Hub) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			h.mu.Lock()
+			stockIDs := make([]int64, 0, len(h.stocks))
+			for stockID, st := range h.stocks {
+				if st.loaded {
+					stockIDs = append(stockIDs, stockID)
+				}
+			}
+			h.mu.Unlock()
+
+			for _, stockID := range stockIDs {
+				err := h.Refresh(ctx, stockID)
+				if err != nil && ctx.Err() == nil && h.OnError != nil {
+					h.OnError(err)
+				}
+			}
+		}
+	}
+}
+
+func (h *Hub) depth(ctx context.Context, stockID int64) ([]orderbook.Level, []orderbook.Level, error) {
+	bids, err := h.book.Depth(ctx, stockID, orderbook.Buy, h.levels)
+	if err != nil {
+		return nil, nil, err
+	}
+	asks, err := h.book.Depth(ctx, stockID, orderbook.Sell, h.levels)
+	if err != nil {
+		return nil, nil, err
+	}
+	return bids, asks, nil
+}
+
+// diff returns the levels of next that are new or differ from previous,
+// and the prices of previous that are not in next any more with quantity 0
+func diff(previous, next []orderbook.Level) []orderbook.Level {
+	before := make(map[data.Money]orderbook.Level, len(previous))
+	for _, level := range previous {
+		before[level.Price] = level
+	}
+
+	changes := []orderbook.Level{}
+	for _, level := range next {
+		if old, ok := before[level.Price]; !ok || old != level {
+			changes = append(changes, level)
+		}
+		delete(before, level.Price)
+	}
+	for _, level := range previous {
+		if _, gone := before[level.Price]; gone {
+			changes = append(changes, orderbook.Level{Price: level.Price})
+		}
+	}
+	return changes
+}
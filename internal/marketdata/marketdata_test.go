@@ -0,0 +1,142 @@
+package marketdata
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/maxwellkuo47/tradingEngine/internal/data"
+	"github.com/maxwellkuo47/tradingEngine/internal/orderbook"
+)
+
+const testStockID = 1
+
+func level(price int64, quantity, orders int) orderbook.Level {
+	return orderbook.Level{Price: data.NewMoney(price), Quantity: quantity, Orders: orders}
+}
+
+func add(t *testing.T, book orderbook.OrderBook, orderID int64, side orderbook.Side, price int64, quantity int) {
+	t.Helper()
+	order := orderbook.Order{OrderID: orderID, UserID: orderID, StockID: testStockID, Side: side, Price: data.NewMoney(price), Quantity: quantity}
+	if err := book.Add(context.Background(), order); err != nil {
+		t.Fatalf("Add(%d): %v", orderID, err)
+	}
+}
+
+// next returns the next message of the client, failing the test when there is none
+func next(t *testing.T, client *Client) Message {
+	t.Helper()
+	select {
+	case msg := <-client.C:
+		return msg
+	default:
+		t.Fatal("no message for the client")
+		return Message{}
+	}
+}
+
+func TestHubMessages(t *testing.T) {
+	ctx := context.Background()
+	book := orderbook.NewMemory()
+	add(t, book, 1, orderbook.Buy, 9, 5)
+	add(t, book, 2, orderbook.Sell, 11, 3)
+	hub := NewHub(book, 10)
+
+	client := hub.Subscribe(10)
+	defer client.Close()
+	if err := client.Join(ctx, testStockID); err != nil {
+		t.Fatalf("Join: %v", err)
+	}
+	snapshot := next(t, client)
+	if snapshot.Type != TypeSnapshot || snapshot.Seq != 0 {
+		t.Errorf("first message = %s seq %d, want the snapshot at 0", snapshot.Type, snapshot.Seq)
+	}
+	if !reflect.DeepEqual(snapshot.Bids, []orderbook.Level{level(9, 5, 1)}) || !reflect.DeepEqual(snapshot.Asks, []orderbook.Level{level(11, 3, 1)}) {
+		t.Errorf("snapshot = %v / %v", snapshot.Bids, snapshot.Asks)
+	}
+
+	hub.PublishTrade(testStockID, Trade{ID: "1", Price: data.NewMoney(10), Quantity: 1, Side: "buy"})
+	hub.PublishTicker(testStockID, data.NewMoney(10), time.Now())
+	if msg := next(t, client); msg.Type != TypeTrade || msg.Seq != 1 || msg.Trade.ID != "1" {
+		t.Errorf("message = %+v, want the trade at seq 1", msg)
+	}
+	if msg := next(t, client); msg.Type != TypeTicker || msg.Seq != 2 || msg.Price != data.NewMoney(10) {
+		t.Errorf("message = %+v, want the ticker at seq 2", msg)
+	}
+
+	// an unchanged book sends nothing
+	if err := hub.Refresh(ctx, testStockID); err != nil {
+		t.Fatalf("Refresh: %v", err)
+	}
+	if len(client.C) != 0 {
+		t.Errorf("%d messages for an unchanged book", len(client.C))
+	}
+
+	// the bid grows, the ask is gone and a new ask shows up
+	add(t, book, 3, orderbook.Buy, 9, 1)
+	if _, err := book.Cancel(ctx, testStockID, orderbook.Sell, data.NewMoney(11), 2); err != nil {
+		t.Fatalf("Cancel: %v", err)
+	}
+	add(t, book, 4, orderbook.Sell, 12, 2)
+	if err := hub.Refresh(ctx, testStockID); err != nil {
+		t.Fatalf("Refresh: %v", err)
+	}
+	depth := next(t, client)
+	if depth.Type != TypeDepth || depth.Seq != 3 {
+		t.Errorf("message = %s seq %d, want depth at seq 3", depth.Type, depth.Seq)
+	}
+	if !reflect.DeepEqual(depth.Bids, []orderbook.Level{level(9, 6, 2)}) {
+		t.Errorf("bid changes = %v", depth.Bids)
+	}
+	if !reflect.DeepEqual(depth.Asks, []orderbook.Level{level(12, 2, 1), {Price: data.NewMoney(11)}}) {
+		t.Errorf("ask changes = %v, want the new level and the gone one with quantity 0", depth.Asks)
+	}
+}
+
+func TestHubClients(t *testing.T) {
+	ctx := context.Background()
+	hub := NewHub(orderbook.NewMemory(), 10)
+
+	// no room for the snapshot
+	slow := hub.Subscribe(0)
+	if err := slow.Join(ctx, testStockID); !errors.Is(err, ErrSlowClient) {
+		t.Errorf("Join = %v, want %v", err, ErrSlowClient)
+	}
+
+	client := hub.Subscribe(10)
+	if err := client.Join(ctx, testStockID); err != nil {
+		t.Fatalf("Join: %v", err)
+	}
+	next(t, client)
+
+	// a client that left gets nothing more
+	client.Leave(testStockID)
+	hub.PublishTicker(testStockID, data.NewMoney(10), time.Now())
+	if len(client.C) != 0 {
+		t.Errorf("%d messages after leaving", len(client.C))
+	}
+
+	client.Close()
+	client.Close()
+	if _, open := <-client.C; open {
+		t.Error("C is open after Close")
+	}
+	if err := client.Join(ctx, testStockID); !errors.Is(err, ErrClientClosed) {
+		t.Errorf("Join after Close = %v, want %v", err, ErrClientClosed)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	previous := []orderbook.Level{level(10, 5, 1), level(9, 2, 1), level(8, 1, 1)}
+	next := []orderbook.Level{level(10, 5, 1), level(9, 3, 2), level(7, 4, 1)}
+
+	want := []orderbook.Level{level(9, 3, 2), level(7, 4, 1), {Price: data.NewMoney(8)}}
+	if got := diff(previous, next); !reflect.DeepEqual(got, want) {
+		t.Errorf("diff = %v, want %v", got, want)
+	}
+	if got := diff(next, next); len(got) != 0 {
+		t.Errorf("diff of the same levels = %v", got)
+	}
+}
@@ -0,0 +1,552 @@
+package orderbook
+
+import (
+	"container/list"
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/maxwellkuo47/tradingEngine/internal/data"
+)
+
+// Memory is an in-process order book, every stock keeps its bids and asks in price level trees
+// and every price level is a FIFO queue of orders.
+// The settlement log lives in the same process, so it only survives as long as the book does.
+type Memory struct {
+	mu    sync.Mutex
+	books map[int64]*memoryBook
+}
+
+type memoryBook struct {
+	bids        priceTree
+	asks        priceTree
+	orders      map[int64]*list.Element // order id -> element of its price level
+	settlements []Match
+	deadLetters []DeadLetter
+	nextMatchID int64
+	nextSeq     int64
+}
+
+var _ OrderBook = (*Memory)(nil)
+
+func NewMemory() *Memory {
+	return &Memory{books: make(map[int64]*memoryBook)}
+}
+
+// book returns the book of a stock, creating it on first use. The caller must hold m.mu.
+func (m *Memory) book(stockID int64) *memoryBook {
+	b, ok := m.books[stockID]
+	if !ok {
+		b = &memoryBook{orders: make(map[int64]*list.Element)}
+		m.books[stockID] = b
+	}
+	return b
+}
+
+func (b *memoryBook) side(side Side) (*priceTree, error) {
+	switch side {
+	case Buy:
+		return &b.bids, nil
+	case Sell:
+		return &b.asks, nil
+	default:
+		return nil, ErrInvalidSide
+	}
+}
+
+// best returns the best level of one side, the highest bid or the lowest ask
+func (b *memoryBook) best(side Side) *priceLevel {
+	if side == Buy {
+		return b.bids.max()
+	}
+	return b.asks.min()
+}
+
+// walk visits the levels of one side from the best price until fn returns false
+func (b *memoryBook) walk(side Side, fn func(*priceLevel) bool) {
+	if side == Buy {
+		b.bids.descend(fn)
+		return
+	}
+	b.asks.ascend(fn)
+}
+
+// take removes quantity from the head order of the level and drops the order once it is fully matched,
+// an iceberg order queues its next slice at the back of the level instead
+func (b *memoryBook) take(tree *priceTree, level *priceLevel, quantity int) {
+	head := level.orders.Front()
+	order := head.Value.(*Order)
+	order.Quantity -= quantity
+	order.Filled += quantity
+	level.quantity -= quantity
+	if order.Quantity == 0 {
+		b.remove(tree, level, head)
+		if order.Hidden > 0 {
+			next := *order
+			next.SetOpen(order.Hidden)
+			b.queue(tree, next)
+		}
+	}
+}
+
+// arrive gives an order without an arrival sequence the next one of the book
+func (b *memoryBook) arrive(order *Order) {
+	if order.Seq == 0 {
+		b.nextSeq++
+		order.Seq = b.nextSeq
+	}
+}
+
+// queue puts the order at the tail of its price level
+func (b *memoryBook) queue(tree *priceTree, order Order) {
+	b.arrive(&order)
+	level := tree.get(order.Price)
+	if level == nil {
+		level = &priceLevel{price: order.Price, orders: list.New()}
+		tree.put(level)
+	}
+	stored := order
+	b.orders[order.OrderID] = level.orders.PushBack(&stored)
+	level.quantity += order.Quantity
+}
+
+// shrink takes quantity out of the order of an element without trading it, the hidden quantity of an iceberg order first,
+// and drops the order once nothing is left of it
+func (b *memoryBook) shrink(tree *priceTree, level *priceLevel, element *list.Element, quantity int) {
+	order := element.Value.(*Order)
+	if quantity >= order.Open() {
+		b.remove(tree, level, element)
+		return
+	}
+	fromHidden := min(order.Hidden, quantity)
+	order.Hidden -= fromHidden
+	order.Quantity -= quantity - fromHidden
+	level.quantity -= quantity - fromHidden
+}
+
+func (b *memoryBook) remove(tree *priceTree, level *priceLevel, element *list.Element) {
+	order := element.Value.(*Order)
+	level.orders.Remove(element)
+	level.quantity -= order.Quantity
+	delete(b.orders, order.OrderID)
+	if level.orders.Len() == 0 {
+		tree.remove(level.price)
+	}
+}
+
+func (m *Memory) Add(ctx context.Context, order Order) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	b := m.book(order.StockID)
+	tree, err := b.side(order.Side)
+	if err != nil {
+		return err
+	}
+	b.queue(tree, order)
+
+	return nil
+}
+
+func (m *Memory) AddBatch(ctx context.Context, orders []Order) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, order := range orders {
+		b := m.book(order.StockID)
+		tree, err := b.side(order.Side)
+		if err != nil {
+			return err
+		}
+		b.queue(tree, order)
+	}
+
+	return nil
+}
+
+func (m *Memory) AddPassive(ctx context.Context, order Order) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	b := m.book(order.StockID)
+	tree, err := b.side(order.Side)
+	if err != nil {
+		return err
+	}
+	if best := b.best(order.Side.Opposite()); best != nil {
+		if order.Side == Buy && order.Price >= best.price || order.Side == Sell && order.Price <= best.price {
+			return ErrWouldCross
+		}
+	}
+	b.queue(tree, order)
+
+	return nil
+}
+
+func (m *Memory) Cancel(ctx context.Context, stockID int64, side Side, price data.Money, orderID int64) (*Order, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	b := m.book(stockID)
+	tree, err := b.side(side)
+	if err != nil {
+		return nil, err
+	}
+
+	element, ok := b.orders[orderID]
+	if !ok {
+		return nil, ErrOrderNotFound
+	}
+	order := *element.Value.(*Order)
+	level := tree.get(order.Price)
+	if order.Side != side || level == nil {
+		return nil, ErrOrderNotFound
+	}
+	b.remove(tree, level, element)
+
+	return &order, nil
+}
+
+func (m *Memory) CancelBatch(ctx context.Context, orders []Order) ([]*Order, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	removed := make([]*Order, len(orders))
+	for i, order := range orders {
+		b := m.book(order.StockID)
+		tree, err := b.side(order.Side)
+		if err != nil {
+			return nil, err
+		}
+		element, ok := b.orders[order.OrderID]
+		if !ok {
+			continue
+		}
+		remaining := *element.Value.(*Order)
+		level := tree.get(remaining.Price)
+		if remaining.Side != order.Side || level == nil {
+			continue
+		}
+		b.remove(tree, level, element)
+		removed[i] = &remaining
+	}
+	return removed, nil
+}
+
+func (m *Memory) Reduce(ctx context.Context, stockID int64, side Side, price data.Money, orderID int64, quantity int) (*Order, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	b := m.book(stockID)
+	tree, err := b.side(side)
+	if err != nil {
+		return nil, err
+	}
+
+	element, ok := b.orders[orderID]
+	if !ok {
+		return nil, ErrOrderNotFound
+	}
+	order := element.Value.(*Order)
+	level := tree.get(order.Price)
+	if order.Side != side || order.Price != price || level == nil {
+		return nil, ErrOrderNotFound
+	}
+	if order.Open() <= quantity {
+		return nil, ErrReduceExceeds
+	}
+	fromHidden := min(order.Hidden, quantity)
+	order.Hidden -= fromHidden
+	order.Quantity -= quantity - fromHidden
+	level.quantity -= quantity - fromHidden
+
+	reduced := *order
+	return &reduced, nil
+}
+
+func (m *Memory) Best(ctx context.Context, stockID int64, side Side) (*Level, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	b := m.book(stockID)
+	if _, err := b.side(side); err != nil {
+		return nil, err
+	}
+
+	level := b.best(side)
+	if level == nil {
+		return nil, nil
+	}
+	best := level.toLevel()
+	return &best, nil
+}
+
+func (m *Memory) PopMatch(ctx context.Context, stockID int64) (*Match, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	b := m.book(stockID)
+	bid := b.bids.max()
+	ask := b.asks.min()
+	if bid == nil || ask == nil || bid.price < ask.price {
+		return nil, nil
+	}
+
+	buy := *bid.orders.Front().Value.(*Order)
+	sell := *ask.orders.Front().Value.(*Order)
+	b.nextMatchID++
+
+	newer, older := buy, sell
+	if arrivedAfter(sell, buy) {
+		newer, older = sell, buy
+	}
+	if newerRemoved, olderRemoved, ok := preventSelfTrade(newer, older); ok {
+		selfTrade := &SelfTrade{Mode: newer.STP, BuyRemoved: newerRemoved, SellRemoved: olderRemoved}
+		if newer.Side == Sell {
+			selfTrade.BuyRemoved, selfTrade.SellRemoved = olderRemoved, newerRemoved
+		}
+		match := &Match{
+			ID:         strconv.FormatInt(b.nextMatchID, 10),
+			Buy:        buy,
+			Sell:       sell,
+			SelfTrade:  selfTrade,
+			ExecutedAt: time.Now().UTC(),
+		}
+		if selfTrade.BuyRemoved > 0 {
+			b.shrink(&b.bids, bid, bid.orders.Front(), selfTrade.BuyRemoved)
+		}
+		if selfTrade.SellRemoved > 0 {
+			b.shrink(&b.asks, ask, ask.orders.Front(), selfTrade.SellRemoved)
+		}
+		b.settlements = append(b.settlements, *match)
+		return match, nil
+	}
+
+	match := &Match{
+		ID:         strconv.FormatInt(b.nextMatchID, 10),
+		Buy:        buy,
+		Sell:       sell,
+		Quantity:   min(buy.Quantity, sell.Quantity),
+		Price:      restingPrice(buy, sell),
+		ExecutedAt: time.Now().UTC(),
+	}
+
+	b.take(&b.bids, bid, match.Quantity)
+	b.take(&b.asks, ask, match.Quantity)
+	b.settlements = append(b.settlements, *match)
+
+	return match, nil
+}
+
+func (m *Memory) Take(ctx context.Context, order Order, allOrNone bool) ([]Match, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	b := m.book(order.StockID)
+	tree, err := b.side(order.Side.Opposite())
+	if err != nil {
+		return nil, err
+	}
+	crosses := func(level *priceLevel) bool {
+		if order.Side == Buy {
+			return level.price <= order.Price
+		}
+		return level.price >= order.Price
+	}
+
+	if allOrNone {
+		available := 0
+		b.walk(order.Side.Opposite(), func(level *priceLevel) bool {
+			if !crosses(level) {
+				return false
+			}
+			// hidden iceberg quantity is matched as well, it only does not show in the depth
+			for element := level.orders.Front(); element != nil && available < order.Quantity; element = element.Next() {
+				resting := element.Value.(*Order)
+				if _, _, ok := preventSelfTrade(order, *resting); !ok {
+					available += resting.Open()
+					continue
+				}
+				// only cancelling the resting order lets the taker go on past it
+				if order.STP != data.ORDER_STP_CANCEL_OLDEST {
+					return false
+				}
+			}
+			return available < order.Quantity
+		})
+		if available < order.Quantity {
+			return []Match{}, nil
+		}
+	}
+
+	matches := []Match{}
+	taker := order
+	b.arrive(&taker)
+	for taker.Quantity > 0 {
+		level := b.best(order.Side.Opposite())
+		if level == nil || !crosses(level) {
+			break
+		}
+		resting := *level.orders.Front().Value.(*Order)
+		b.nextMatchID++
+
+		// the taker is the newer order
+		if takerRemoved, restingRemoved, ok := preventSelfTrade(taker, resting); ok {
+			match := Match{
+				ID:         strconv.FormatInt(b.nextMatchID, 10),
+				SelfTrade:  &SelfTrade{Mode: taker.STP, BuyRemoved: takerRemoved, SellRemoved: restingRemoved},
+				ExecutedAt: time.Now().UTC(),
+			}
+			if order.Side == Buy {
+				match.Buy, match.Sell = taker, resting
+			} else {
+				match.Buy, match.Sell = resting, taker
+				match.SelfTrade.BuyRemoved, match.SelfTrade.SellRemoved = restingRemoved, takerRemoved
+			}
+			if restingRemoved > 0 {
+				b.shrink(tree, level, level.orders.Front(), restingRemoved)
+			}
+			b.settlements = append(b.settlements, match)
+			matches = append(matches, match)
+
+			taker.Quantity -= takerRemoved
+			continue
+		}
+
+		quantity := min(taker.Quantity, resting.Quantity)
+		match := Match{
+			ID:         strconv.FormatInt(b.nextMatchID, 10),
+			Quantity:   quantity,
+			ExecutedAt: time.Now().UTC(),
+		}
+		if order.Side == Buy {
+			match.Buy, match.Sell = taker, resting
+		} else {
+			match.Buy, match.Sell = resting, taker
+		}
+		match.Price = restingPrice(match.Buy, match.Sell)
+
+		b.take(tree, level, quantity)
+		b.settlements = append(b.settlements, match)
+		matches = append(matches, match)
+
+		taker.Quantity -= quantity
+		taker.Filled += quantity
+	}
+
+	return matches, nil
+}
+
+func (m *Memory) PendingMatches(ctx context.Context, stockID int64, after string, count int) ([]Match, error) {
+	var afterID int64
+	if after != "" {
+		var err error
+		afterID, err = strconv.ParseInt(after, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	b := m.book(stockID)
+	pending := []Match{}
+	for _, match := range b.settlements {
+		if len(pending) == count {
+			break
+		}
+		// match ids are counted up, the log is in id order
+		id, _ := strconv.ParseInt(match.ID, 10, 64)
+		if id > afterID {
+			pending = append(pending, match)
+		}
+	}
+	return pending, nil
+}
+
+func (m *Memory) AckMatch(ctx context.Context, stockID int64, matchID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	b := m.book(stockID)
+	b.removeSettlement(matchID)
+	return nil
+}
+
+func (m *Memory) DeadLetter(ctx context.Context, stockID int64, match Match, reason string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	b := m.book(stockID)
+	b.removeSettlement(match.ID)
+	b.deadLetters = append(b.deadLetters, DeadLetter{Match: match, Reason: reason, FailedAt: time.Now().UTC()})
+	return nil
+}
+
+func (m *Memory) DeadLetters(ctx context.Context, stockID int64) ([]DeadLetter, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	b := m.book(stockID)
+	return append([]DeadLetter{}, b.deadLetters...), nil
+}
+
+func (m *Memory) Settlements(ctx context.Context, stockID int64) ([]Match, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	b := m.book(stockID)
+	return append([]Match{}, b.settlements...), nil
+}
+
+func (b *memoryBook) removeSettlement(matchID string) {
+	for i, match := range b.settlements {
+		if match.ID == matchID {
+			b.settlements = append(b.settlements[:i], b.settlements[i+1:]...)
+			return
+		}
+	}
+}
+
+func (m *Memory) Depth(ctx context.Context, stockID int64, side Side, levels int) ([]Level, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	b := m.book(stockID)
+	if _, err := b.side(side); err != nil {
+		return nil, err
+	}
+
+	depth := []Level{}
+	b.walk(side, func(level *priceLevel) bool {
+		if len(depth) >= levels {
+			return false
+		}
+		depth = append(depth, level.toLevel())
+		return true
+	})
+
+	return depth, nil
+}
+
+func (m *Memory) Snapshot(ctx context.Context, stockID int64) (*Snapshot, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	b := m.book(stockID)
+	snapshot := &Snapshot{StockID: stockID, Bids: []Order{}, Asks: []Order{}}
+	collect := func(orders *[]Order) func(*priceLevel) bool {
+		return func(level *priceLevel) bool {
+			for e := level.orders.Front(); e != nil; e = e.Next() {
+				*orders = append(*orders, *e.Value.(*Order))
+			}
+			return true
+		}
+	}
+	b.walk(Buy, collect(&snapshot.Bids))
+	b.walk(Sell, collect(&snapshot.Asks))
+
+	return snapshot, nil
+}
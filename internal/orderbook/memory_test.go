@@ -0,0 +1,484 @@
+package orderbook
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/maxwellkuo47/tradingEngine/internal/data"
+)
+
+const testStockID = 1
+
+func buyOrder(orderID int64, price int64, quantity int) Order {
+	return Order{OrderID: orderID, UserID: orderID, StockID: testStockID, Side: Buy, Price: data.NewMoney(price), Quantity: quantity}
+}
+
+func sellOrder(orderID int64, price int64, quantity int) Order {
+	return Order{OrderID: orderID, UserID: orderID, StockID: testStockID, Side: Sell, Price: data.NewMoney(price), Quantity: quantity}
+}
+
+// iceberg turns an order into an iceberg order that shows display shares at a time
+func iceberg(order Order, display int) Order {
+	order.Display = display
+	order.SetOpen(order.Quantity)
+	return order
+}
+
+// ownedBy gives an order to a user with a self-trade prevention mode
+func ownedBy(order Order, userID int64, stp int) Order {
+	order.UserID, order.STP = userID, stp
+	return order
+}
+
+// fill is what a test expects of a match
+type fill struct {
+	Buy, Sell int64
+	Quantity  int
+	Price     int64
+}
+
+// resting is an order the book holds after a test, in priority order
+type resting struct {
+	OrderID int64
+	Open    int
+}
+
+func newTestBook(t *testing.T, orders []Order) *Memory {
+	t.Helper()
+	book := NewMemory()
+	for _, order := range orders {
+		if err := book.Add(context.Background(), order); err != nil {
+			t.Fatalf("Add(%d): %v", order.OrderID, err)
+		}
+	}
+	return book
+}
+
+func restingOrders(t *testing.T, book *Memory) (bids, asks []resting) {
+	t.Helper()
+	snapshot, err := book.Snapshot(context.Background(), testStockID)
+	if err != nil {
+		t.Fatalf("Snapshot: %v", err)
+	}
+	bids, asks = []resting{}, []resting{}
+	for _, order := range snapshot.Bids {
+		bids = append(bids, resting{order.OrderID, order.Open()})
+	}
+	for _, order := range snapshot.Asks {
+		asks = append(asks, resting{order.OrderID, order.Open()})
+	}
+	return bids, asks
+}
+
+func TestMemoryPopMatch(t *testing.T) {
+	tests := []struct {
+		name     string
+		orders   []Order
+		want     []fill
+		wantBids []resting
+		wantAsks []resting
+	}{
+		{
+			name:     "best price first",
+			orders:   []Order{sellOrder(1, 11, 1), sellOrder(2, 10, 1), buyOrder(3, 11, 2)},
+			want:     []fill{{Buy: 3, Sell: 2, Quantity: 1, Price: 10}, {Buy: 3, Sell: 1, Quantity: 1, Price: 11}},
+			wantBids: []resting{},
+			wantAsks: []resting{},
+		},
+		{
+			name:     "first in first out at one price",
+			orders:   []Order{sellOrder(1, 10, 1), sellOrder(2, 10, 1), buyOrder(3, 10, 1)},
+			want:     []fill{{Buy: 3, Sell: 1, Quantity: 1, Price: 10}},
+			wantBids: []resting{},
+			wantAsks: []resting{{2, 1}},
+		},
+		{
+			name:     "partial fill keeps the head of the queue",
+			orders:   []Order{sellOrder(1, 10, 5), sellOrder(2, 10, 5), buyOrder(3, 10, 3)},
+			want:     []fill{{Buy: 3, Sell: 1, Quantity: 3, Price: 10}},
+			wantBids: []resting{},
+			wantAsks: []resting{{1, 2}, {2, 5}},
+		},
+		{
+			name:     "partial fill of the incoming order rests",
+			orders:   []Order{sellOrder(1, 10, 2), buyOrder(2, 11, 5)},
+			want:     []fill{{Buy: 2, Sell: 1, Quantity: 2, Price: 10}},
+			wantBids: []resting{{2, 3}},
+			wantAsks: []resting{},
+		},
+		{
+			name:     "resting buy sets the price",
+			orders:   []Order{buyOrder(1, 12, 1), sellOrder(2, 10, 1)},
+			want:     []fill{{Buy: 1, Sell: 2, Quantity: 1, Price: 12}},
+			wantBids: []resting{},
+			wantAsks: []resting{},
+		},
+		{
+			name:     "order arriving with an older id takes the resting price",
+			orders:   []Order{sellOrder(5, 11, 1), buyOrder(3, 12, 1)},
+			want:     []fill{{Buy: 3, Sell: 5, Quantity: 1, Price: 11}},
+			wantBids: []resting{},
+			wantAsks: []resting{},
+		},
+		{
+			name:     "iceberg refill queues behind the level",
+			orders:   []Order{iceberg(sellOrder(1, 10, 10), 4), sellOrder(2, 10, 3), buyOrder(3, 10, 6)},
+			want:     []fill{{Buy: 3, Sell: 1, Quantity: 4, Price: 10}, {Buy: 3, Sell: 2, Quantity: 2, Price: 10}},
+			wantBids: []resting{},
+			wantAsks: []resting{{2, 1}, {1, 6}},
+		},
+		{
+			name:     "book not crossed",
+			orders:   []Order{buyOrder(1, 9, 1), sellOrder(2, 10, 1)},
+			want:     []fill{},
+			wantBids: []resting{{1, 1}},
+			wantAsks: []resting{{2, 1}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			book := newTestBook(t, tt.orders)
+
+			got := []fill{}
+			for {
+				match, err := book.PopMatch(context.Background(), testStockID)
+				if err != nil {
+					t.Fatalf("PopMatch: %v", err)
+				}
+				if match == nil {
+					break
+				}
+				got = append(got, fill{match.Buy.OrderID, match.Sell.OrderID, match.Quantity, int64(match.Price / data.NewMoney(1))})
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("matches = %v, want %v", got, tt.want)
+			}
+
+			bids, asks := restingOrders(t, book)
+			if !reflect.DeepEqual(bids, tt.wantBids) {
+				t.Errorf("bids = %v, want %v", bids, tt.wantBids)
+			}
+			if !reflect.DeepEqual(asks, tt.wantAsks) {
+				t.Errorf("asks = %v, want %v", asks, tt.wantAsks)
+			}
+		})
+	}
+}
+
+func TestMemorySelfTradePrevention(t *testing.T) {
+	// the sell order of user 1 rests, the buy order of the same user arrives and its mode applies
+	older := ownedBy(sellOrder(1, 10, 5), 1, data.ORDER_STP_NONE)
+	newer := func(mode int) Order { return ownedBy(buyOrder(2, 10, 3), 1, mode) }
+
+	tests := []struct {
+		name          string
+		orders        []Order
+		wantSelfTrade *SelfTrade
+		wantQuantity  int
+		wantBids      []resting
+		wantAsks      []resting
+	}{
+		{
+			name:         "none trades",
+			orders:       []Order{older, newer(data.ORDER_STP_NONE)},
+			wantQuantity: 3,
+			wantBids:     []resting{},
+			wantAsks:     []resting{{1, 2}},
+		},
+		{
+			name:          "cancel newest",
+			orders:        []Order{older, newer(data.ORDER_STP_CANCEL_NEWEST)},
+			wantSelfTrade: &SelfTrade{Mode: data.ORDER_STP_CANCEL_NEWEST, BuyRemoved: 3},
+			wantBids:      []resting{},
+			wantAsks:      []resting{{1, 5}},
+		},
+		{
+			name:          "cancel oldest",
+			orders:        []Order{older, newer(data.ORDER_STP_CANCEL_OLDEST)},
+			wantSelfTrade: &SelfTrade{Mode: data.ORDER_STP_CANCEL_OLDEST, SellRemoved: 5},
+			wantBids:      []resting{{2, 3}},
+			wantAsks:      []resting{},
+		},
+		{
+			name:          "cancel both",
+			orders:        []Order{older, newer(data.ORDER_STP_CANCEL_BOTH)},
+			wantSelfTrade: &SelfTrade{Mode: data.ORDER_STP_CANCEL_BOTH, BuyRemoved: 3, SellRemoved: 5},
+			wantBids:      []resting{},
+			wantAsks:      []resting{},
+		},
+		{
+			name:          "decrement",
+			orders:        []Order{older, newer(data.ORDER_STP_DECREMENT)},
+			wantSelfTrade: &SelfTrade{Mode: data.ORDER_STP_DECREMENT, BuyRemoved: 3, SellRemoved: 3},
+			wantBids:      []resting{},
+			wantAsks:      []resting{{1, 2}},
+		},
+		{
+			name:          "decrement takes the hidden quantity of an iceberg first",
+			orders:        []Order{iceberg(older, 2), newer(data.ORDER_STP_DECREMENT)},
+			wantSelfTrade: &SelfTrade{Mode: data.ORDER_STP_DECREMENT, BuyRemoved: 3, SellRemoved: 3},
+			wantBids:      []resting{},
+			wantAsks:      []resting{{1, 2}},
+		},
+		{
+			name:         "mode of the older order does not apply",
+			orders:       []Order{ownedBy(sellOrder(1, 10, 5), 1, data.ORDER_STP_CANCEL_NEWEST), newer(data.ORDER_STP_NONE)},
+			wantQuantity: 3,
+			wantBids:     []resting{},
+			wantAsks:     []resting{{1, 2}},
+		},
+		{
+			name: "order arriving with an older id is the newest",
+			orders: []Order{
+				ownedBy(sellOrder(2, 10, 5), 1, data.ORDER_STP_NONE),
+				ownedBy(buyOrder(1, 10, 3), 1, data.ORDER_STP_CANCEL_NEWEST),
+			},
+			wantSelfTrade: &SelfTrade{Mode: data.ORDER_STP_CANCEL_NEWEST, BuyRemoved: 3},
+			wantBids:      []resting{},
+			wantAsks:      []resting{{2, 5}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			book := newTestBook(t, tt.orders)
+
+			match, err := book.PopMatch(context.Background(), testStockID)
+			if err != nil {
+				t.Fatalf("PopMatch: %v", err)
+			}
+			if match == nil {
+				t.Fatal("PopMatch returned no match")
+			}
+			if !reflect.DeepEqual(match.SelfTrade, tt.wantSelfTrade) {
+				t.Errorf("self trade = %+v, want %+v", match.SelfTrade, tt.wantSelfTrade)
+			}
+			if match.Quantity != tt.wantQuantity {
+				t.Errorf("quantity = %d, want %d", match.Quantity, tt.wantQuantity)
+			}
+
+			bids, asks := restingOrders(t, book)
+			if !reflect.DeepEqual(bids, tt.wantBids) {
+				t.Errorf("bids = %v, want %v", bids, tt.wantBids)
+			}
+			if !reflect.DeepEqual(asks, tt.wantAsks) {
+				t.Errorf("asks = %v, want %v", asks, tt.wantAsks)
+			}
+		})
+	}
+}
+
+func TestMemoryCancel(t *testing.T) {
+	tests := []struct {
+		name       string
+		orders     []Order
+		match      bool
+		side       Side
+		orderID    int64
+		wantOpen   int
+		wantFilled int
+		wantErr    error
+		wantAsks   []resting
+	}{
+		{
+			name:     "queued order",
+			orders:   []Order{sellOrder(1, 10, 5), sellOrder(2, 10, 5)},
+			side:     Sell,
+			orderID:  1,
+			wantOpen: 5,
+			wantAsks: []resting{{2, 5}},
+		},
+		{
+			name:       "partially filled order",
+			orders:     []Order{sellOrder(1, 10, 5), buyOrder(2, 10, 2)},
+			match:      true,
+			side:       Sell,
+			orderID:    1,
+			wantOpen:   3,
+			wantFilled: 2,
+			wantAsks:   []resting{},
+		},
+		{
+			name:     "iceberg order with its hidden quantity",
+			orders:   []Order{iceberg(sellOrder(1, 10, 9), 2)},
+			side:     Sell,
+			orderID:  1,
+			wantOpen: 9,
+			wantAsks: []resting{},
+		},
+		{
+			name:     "fully matched order",
+			orders:   []Order{sellOrder(1, 10, 2), buyOrder(2, 10, 2)},
+			match:    true,
+			side:     Sell,
+			orderID:  1,
+			wantErr:  ErrOrderNotFound,
+			wantAsks: []resting{},
+		},
+		{
+			name:     "wrong side",
+			orders:   []Order{sellOrder(1, 10, 5)},
+			side:     Buy,
+			orderID:  1,
+			wantErr:  ErrOrderNotFound,
+			wantAsks: []resting{{1, 5}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			book := newTestBook(t, tt.orders)
+			if tt.match {
+				if _, err := book.PopMatch(context.Background(), testStockID); err != nil {
+					t.Fatalf("PopMatch: %v", err)
+				}
+			}
+
+			removed, err := book.Cancel(context.Background(), testStockID, tt.side, data.NewMoney(10), tt.orderID)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Cancel error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil {
+				if removed.Open() != tt.wantOpen || removed.Filled != tt.wantFilled {
+					t.Errorf("removed open %d filled %d, want open %d filled %d", removed.Open(), removed.Filled, tt.wantOpen, tt.wantFilled)
+				}
+				// a second cancellation finds nothing
+				if _, err := book.Cancel(context.Background(), testStockID, tt.side, data.NewMoney(10), tt.orderID); !errors.Is(err, ErrOrderNotFound) {
+					t.Errorf("second Cancel error = %v, want %v", err, ErrOrderNotFound)
+				}
+			}
+
+			_, asks := restingOrders(t, book)
+			if !reflect.DeepEqual(asks, tt.wantAsks) {
+				t.Errorf("asks = %v, want %v", asks, tt.wantAsks)
+			}
+		})
+	}
+}
+
+func TestMemoryDepth(t *testing.T) {
+	orders := []Order{
+		buyOrder(1, 9, 2),
+		buyOrder(2, 9, 3),
+		buyOrder(3, 8, 1),
+		buyOrder(4, 7, 4),
+		iceberg(sellOrder(5, 11, 10), 2),
+		sellOrder(6, 11, 1),
+		sellOrder(7, 12, 5),
+	}
+	level := func(price int64, quantity, count int) Level {
+		return Level{Price: data.NewMoney(price), Quantity: quantity, Orders: count}
+	}
+
+	tests := []struct {
+		name   string
+		side   Side
+		levels int
+		want   []Level
+	}{
+		{
+			name:   "bids best first",
+			side:   Buy,
+			levels: 10,
+			want:   []Level{level(9, 5, 2), level(8, 1, 1), level(7, 4, 1)},
+		},
+		{
+			name:   "limited levels",
+			side:   Buy,
+			levels: 2,
+			want:   []Level{level(9, 5, 2), level(8, 1, 1)},
+		},
+		{
+			name:   "asks count the displayed quantity of icebergs",
+			side:   Sell,
+			levels: 10,
+			want:   []Level{level(11, 3, 2), level(12, 5, 1)},
+		},
+	}
+
+	book := newTestBook(t, orders)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := book.Depth(context.Background(), testStockID, tt.side, tt.levels)
+			if err != nil {
+				t.Fatalf("Depth: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("depth = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMemoryPendingMatches(t *testing.T) {
+	ctx := context.Background()
+	book := newTestBook(t, []Order{buyOrder(1, 10, 4), sellOrder(2, 10, 1), sellOrder(3, 10, 1), sellOrder(4, 10, 1), sellOrder(5, 10, 1)})
+	for i := 0; i < 4; i++ {
+		if match, err := book.PopMatch(ctx, testStockID); err != nil || match == nil {
+			t.Fatalf("PopMatch = %v, %v", match, err)
+		}
+	}
+	if err := book.AckMatch(ctx, testStockID, "3"); err != nil {
+		t.Fatalf("AckMatch: %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		after string
+		count int
+		want  []string
+	}{
+		{name: "from the oldest", after: "", count: 10, want: []string{"1", "2", "4"}},
+		{name: "limited count", after: "", count: 2, want: []string{"1", "2"}},
+		{name: "after a match", after: "1", count: 10, want: []string{"2", "4"}},
+		{name: "after an acknowledged match", after: "3", count: 10, want: []string{"4"}},
+		{name: "after the newest", after: "4", count: 10, want: []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			matches, err := book.PendingMatches(ctx, testStockID, tt.after, tt.count)
+			if err != nil {
+				t.Fatalf("PendingMatches: %v", err)
+			}
+			got := []string{}
+			for _, match := range matches {
+				got = append(got, match.ID)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("pending = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// inZone runs the test with the local time zone set to a zone that is not UTC
+func inZone(t *testing.T) {
+	t.Helper()
+	local := time.Local
+	time.Local = time.FixedZone("UTC+8", 8*60*60)
+	t.Cleanup(func() { time.Local = local })
+}
+
+func TestMemoryMatchesExecuteInUTC(t *testing.T) {
+	inZone(t)
+	ctx := context.Background()
+	book := newTestBook(t, []Order{buyOrder(1, 10, 1), sellOrder(2, 10, 2)})
+
+	popped, err := book.PopMatch(ctx, testStockID)
+	if err != nil || popped == nil {
+		t.Fatalf("PopMatch = %v, %v", popped, err)
+	}
+	taken, err := book.Take(ctx, buyOrder(3, 10, 1), false)
+	if err != nil || len(taken) != 1 {
+		t.Fatalf("Take = %v, %v", taken, err)
+	}
+	for _, match := range []Match{*popped, taken[0]} {
+		if match.ExecutedAt.Location() != time.UTC {
+			t.Errorf("match %s executed at %v, want UTC", match.ID, match.ExecutedAt)
+		}
+	}
+}
@@ -0,0 +1,228 @@
+package orderbook
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/maxwellkuo47/tradingEngine/internal/data"
+)
+
+// Side of an order in the book, the values are the same as data.ORDER_TYPE_BUY and data.ORDER_TYPE_SELL
+type Side int
+
+const (
+	Buy Side = iota
+	Sell
+)
+
+// Opposite is the side an order of this side matches against
+func (s Side) Opposite() Side {
+	if s == Buy {
+		return Sell
+	}
+	return Buy
+}
+
+var (
+	ErrOrderNotFound = errors.New("order not found in book")
+	ErrInvalidSide   = errors.New("invalid order side")
+	ErrReduceExceeds = errors.New("order has no more open quantity than the reduction")
+	ErrWouldCross    = errors.New("order would cross the book")
+)
+
+// Order is an order resting in the book, Quantity is the part that has not been matched yet
+// and Filled is the quantity of the order that was matched before, in the book or earlier.
+// Filled is the fill offset that makes settling a match idempotent.
+//
+// An iceberg order only queues a slice of Display shares as its Quantity and keeps the rest as Hidden,
+// once the slice is matched the next one is taken from Hidden and queued at the back of its price level.
+//
+// Seq is the arrival sequence the book gives an order of the stock when it is queued or taken, a later arrival gets a higher one.
+// An order without Seq gets the next one, an order that is put back, e.g. when a cancellation is rolled back, keeps its own.
+type Order struct {
+	OrderID    int64      `json:"order_id"`
+	UserID     int64      `json:"user_id"`
+	StockID    int64      `json:"stock_id"`
+	Side       Side       `json:"side"`
+	Price      data.Money `json:"price"`
+	Quantity   int        `json:"quantity"`
+	Filled     int        `json:"filled"`
+	Display    int        `json:"display,omitempty"`
+	Hidden     int        `json:"hidden,omitempty"`
+	STP        int        `json:"stp,omitempty"`
+	Seq        int64      `json:"seq"`
+	CreateTime time.Time  `json:"create_time"`
+}
+
+// Open is the quantity of the order that can still be matched, displayed or hidden
+func (o Order) Open() int {
+	return o.Quantity + o.Hidden
+}
+
+// SetOpen splits an open quantity into the displayed slice and the hidden reserve of the order
+func (o *Order) SetOpen(open int) {
+	o.Quantity, o.Hidden = open, 0
+	if o.Display > 0 && open > o.Display {
+		o.Quantity, o.Hidden = o.Display, open-o.Display
+	}
+}
+
+// Level is an aggregated price level of one side of the book
+type Level struct {
+	Price    data.Money `json:"price"`
+	Quantity int        `json:"quantity"`
+	Orders   int        `json:"orders"`
+}
+
+// Match is one execution between a buy order and a sell order.
+// Buy and Sell hold the orders as they were in the book before the match,
+// Price is the price of the resting order, i.e. the order that reached the book first, the other one is the taker.
+// ID is the position of the match in the settlement log of its stock.
+// ExecutedAt is in UTC, the database keeps it without a time zone.
+//
+// A match with SelfTrade set is not a trade, its orders belong to the same user and self-trade prevention
+// took quantity out of them instead. Its Quantity and Price are zero.
+type Match struct {
+	ID         string     `json:"id"`
+	Buy        Order      `json:"buy"`
+	Sell       Order      `json:"sell"`
+	Quantity   int        `json:"quantity"`
+	Price      data.Money `json:"price"`
+	SelfTrade  *SelfTrade `json:"self_trade,omitempty"`
+	ExecutedAt time.Time  `json:"executed_at"`
+}
+
+// SelfTrade is what self-trade prevention did to two orders of the same user that would have traded,
+// Mode is the data.ORDER_STP_* mode of the newer order and the removed quantities were taken out of the book untraded
+type SelfTrade struct {
+	Mode        int `json:"mode"`
+	BuyRemoved  int `json:"buy_removed"`
+	SellRemoved int `json:"sell_removed"`
+}
+
+// TakerSide is the side of the order that reached the book last
+func (m Match) TakerSide() Side {
+	if arrivedAfter(m.Buy, m.Sell) {
+		return Buy
+	}
+	return Sell
+}
+
+// Removed is the quantity self-trade prevention took out of the order on one side
+func (s SelfTrade) Removed(side Side) int {
+	if side == Buy {
+		return s.BuyRemoved
+	}
+	return s.SellRemoved
+}
+
+// DeadLetter is a match that could not be settled and was taken out of the settlement log
+type DeadLetter struct {
+	Match    Match     `json:"match"`
+	Reason   string    `json:"reason"`
+	FailedAt time.Time `json:"failed_at"`
+}
+
+// Snapshot is every order of a stock in priority order, best price first and FIFO within a price
+type Snapshot struct {
+	StockID int64   `json:"stock_id"`
+	Bids    []Order `json:"bids"`
+	Asks    []Order `json:"asks"`
+}
+
+// OrderBook keeps the resting orders of every stock with price-time priority
+type OrderBook interface {
+	// Add puts the order at the tail of its price level, see Order for its arrival sequence
+	Add(ctx context.Context, order Order) error
+	// AddPassive puts the order at the tail of its price level like Add unless its price reaches the best price
+	// of the opposite side, then nothing is added and it returns ErrWouldCross
+	AddPassive(ctx context.Context, order Order) error
+	// AddBatch puts each of the orders at the tail of its price level like Add, in one round trip to the backend
+	AddBatch(ctx context.Context, orders []Order) error
+	// Cancel takes the order out of the book and returns what was left of it,
+	// it returns ErrOrderNotFound once the order has been fully matched or cancelled
+	Cancel(ctx context.Context, stockID int64, side Side, price data.Money, orderID int64) (*Order, error)
+	// CancelBatch takes the orders, located by their stock, side, price and id, out of the book like Cancel
+	// in one round trip to the backend. It returns what was left of each order at the same index,
+	// nil for an order that was fully matched or cancelled already
+	CancelBatch(ctx context.Context, orders []Order) ([]*Order, error)
+	// Reduce lowers the open quantity of a queued order by quantity without moving it in its queue
+	// and returns what is left of it. The hidden quantity of an iceberg order is reduced first. It returns ErrOrderNotFound when the order is not in the book
+	// and ErrReduceExceeds when the reduction would leave nothing of it
+	Reduce(ctx context.Context, stockID int64, side Side, price data.Money, orderID int64, quantity int) (*Order, error)
+	// Best returns the best price level of one side, nil when that side is empty.
+	// Levels only count the displayed quantity of iceberg orders
+	Best(ctx context.Context, stockID int64, side Side) (*Level, error)
+	// PopMatch crosses the best bid against the best ask and takes the matched quantity out of the book,
+	// the match is appended to the settlement log of the stock in the same step.
+	// It returns nil when the book is not crossed
+	PopMatch(ctx context.Context, stockID int64) (*Match, error)
+	// Take matches an incoming order against the opposite side of the book, best price first and up to the order's price,
+	// without queuing it. Every match is appended to the settlement log like in PopMatch.
+	// With allOrNone nothing is matched unless the whole quantity can be. The unmatched quantity is left to the caller.
+	Take(ctx context.Context, order Order, allOrNone bool) ([]Match, error)
+	// PendingMatches returns up to count matches of the settlement log that were not acknowledged yet, oldest first,
+	// starting after the match with id after or at the oldest one when after is empty.
+	// A match is returned again until it is acknowledged or dead lettered, a settler holding one back reads on after it
+	PendingMatches(ctx context.Context, stockID int64, after string, count int) ([]Match, error)
+	// AckMatch removes a settled match from the settlement log
+	AckMatch(ctx context.Context, stockID int64, matchID string) error
+	// DeadLetter moves a match that cannot be settled from the settlement log to the dead letters of the stock
+	DeadLetter(ctx context.Context, stockID int64, match Match, reason string) error
+	// DeadLetters returns the dead letters of the stock, oldest first
+	DeadLetters(ctx context.Context, stockID int64) ([]DeadLetter, error)
+	// Settlements returns every match of the settlement log without claiming it, oldest first
+	Settlements(ctx context.Context, stockID int64) ([]Match, error)
+	// Depth returns up to levels price levels of one side, best price first
+	Depth(ctx context.Context, stockID int64, side Side, levels int) ([]Level, error)
+	// Snapshot returns every order of the stock
+	Snapshot(ctx context.Context, stockID int64) (*Snapshot, error)
+}
+
+// StaleLevelFinder is implemented by backends whose price index can get out of sync with the price levels
+type StaleLevelFinder interface {
+	// StaleLevels returns price levels of the stock that are indexed without orders or hold orders without being indexed,
+	// with remove set they are dropped as well
+	StaleLevels(ctx context.Context, stockID int64, remove bool) ([]string, error)
+}
+
+// preventSelfTrade decides what self-trade prevention does when the newer order would trade with the older one,
+// the newer order is the one that reached the book last and its mode applies. It returns how much to take out of each order without trading them,
+// ok is false when the orders may trade.
+func preventSelfTrade(newer, older Order) (newerRemoved, olderRemoved int, ok bool) {
+	if newer.UserID != older.UserID {
+		return 0, 0, false
+	}
+	switch newer.STP {
+	case data.ORDER_STP_CANCEL_NEWEST:
+		return newer.Open(), 0, true
+	case data.ORDER_STP_CANCEL_OLDEST:
+		return 0, older.Open(), true
+	case data.ORDER_STP_CANCEL_BOTH:
+		return newer.Open(), older.Open(), true
+	case data.ORDER_STP_DECREMENT:
+		decrement := min(newer.Open(), older.Open())
+		return decrement, decrement, true
+	default:
+		return 0, 0, false
+	}
+}
+
+// arrivedAfter reports whether order a reached the book after order b. An amended, fired or restored order
+// may have an older id than the orders it meets, so the arrival sequence decides, the ids only tell apart
+// orders queued before there was one.
+func arrivedAfter(a, b Order) bool {
+	if a.Seq != b.Seq {
+		return a.Seq > b.Seq
+	}
+	return a.OrderID > b.OrderID
+}
+
+// restingPrice is the trade price of a match, the order that reached the book first sets the price
+func restingPrice(buy, sell Order) data.Money {
+	if arrivedAfter(buy, sell) {
+		return sell.Price
+	}
+	return buy.Price
+}
@@ -0,0 +1,1109 @@
+package orderbook
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/maxwellkuo47/tradingEngine/internal/data"
+	"github.com/redis/go-redis/v9"
+)
+
+// Redis keeps the book in redis, every side of a stock is a sorted set (heap) of price queue keys
+// scored by price and every price queue is a list of json encoded orders in FIFO order.
+// Scores are only used for ordering, the exact decimal price is read from the queue key and the orders.
+//
+// Every match is appended to a settlement stream by the same script that takes it out of the book,
+// settlers read the stream through a consumer group and acknowledge a match once it is settled.
+//
+//	buy_heap_<stock_id>, sell_heap_<stock_id>: sorted sets of queue keys
+//	buy_<stock_id>_at_<price>, sell_<stock_id>_at_<price>: lists of orders
+//	settlement_<stock_id>: stream of matches waiting for settlement
+//	settlement_dead_<stock_id>: stream of matches that could not be settled
+//	order_seq_<stock_id>: the last arrival sequence given to an order
+type Redis struct {
+	client   *redis.Client
+	consumer string
+	groups   sync.Map // settlement streams whose consumer group exists
+}
+
+var (
+	_ OrderBook        = (*Redis)(nil)
+	_ StaleLevelFinder = (*Redis)(nil)
+)
+
+const (
+	settlementGroup = "settlers"
+	// settlementClaimIdle is how long a match may stay unacknowledged by another consumer before it is claimed
+	settlementClaimIdle = 30 * time.Second
+)
+
+// NewRedis returns a book on the client, consumer names this process in the settlement consumer group
+// and should stay the same across restarts so unacknowledged matches are picked up again right away
+func NewRedis(client *redis.Client, consumer string) *Redis {
+	return &Redis{client: client, consumer: consumer}
+}
+
+// sequenceLua is shared by the scripts that take orders into the book.
+// sequence gives an order that has no arrival sequence yet the next one of its stock,
+// the order is rewritten in place like in fillLua.
+const sequenceLua = `
+local function sequence(entry, counter)
+	if not string.find(entry, '"seq":0[,}]') then
+		return entry
+	end
+	local seq = redis.call('INCR', counter)
+	return (string.gsub(entry, '"seq":0([,}])', '"seq":' .. string.format('%d', seq) .. '%1', 1))
+end
+`
+
+// addOrderScript queues an order and registers its price in the heap in one step,
+// so the matcher never sees a price without its queue.
+// KEYS[1]: queue key, KEYS[2]: heap key, KEYS[3]: sequence key, ARGV[1]: price, ARGV[2]: order
+var addOrderScript = redis.NewScript(sequenceLua + `
+redis.call('ZADD', KEYS[2], ARGV[1], KEYS[1])
+return redis.call('RPUSH', KEYS[1], sequence(ARGV[2], KEYS[3]))
+`)
+
+// addPassiveScript queues an order like addOrderScript unless its price reaches the best price of the opposite heap.
+// It returns 0 when the order would cross and was not queued.
+// KEYS[1]: queue key, KEYS[2]: heap key, KEYS[3]: opposite heap key, KEYS[4]: sequence key,
+// ARGV[1]: price, ARGV[2]: order, ARGV[3]: side, 0 buy 1 sell
+var addPassiveScript = redis.NewScript(sequenceLua + `
+local price = tonumber(ARGV[1])
+local best
+if ARGV[3] == '0' then
+	best = redis.call('ZRANGE', KEYS[3], 0, 0, 'WITHSCORES')
+else
+	best = redis.call('ZREVRANGE', KEYS[3], 0, 0, 'WITHSCORES')
+end
+if best[2] then
+	local bestPrice = tonumber(best[2])
+	if (ARGV[3] == '0' and price >= bestPrice) or (ARGV[3] == '1' and price <= bestPrice) then
+		return 0
+	end
+end
+redis.call('ZADD', KEYS[2], ARGV[1], KEYS[1])
+redis.call('RPUSH', KEYS[1], sequence(ARGV[2], KEYS[4]))
+return 1
+`)
+
+// removeOrderScript pulls a single order out of its price queue by order id
+// and drops the price from the heap once the queue is empty.
+// Running it as a script keeps the removal atomic against the matcher.
+// KEYS[1]: queue key, KEYS[2]: heap key, ARGV[1]: order id
+var removeOrderScript = redis.NewScript(`
+local entries = redis.call('LRANGE', KEYS[1], 0, -1)
+for _, entry in ipairs(entries) do
+	local stored = cjson.decode(entry)
+	if stored['order_id'] == tonumber(ARGV[1]) then
+		redis.call('LREM', KEYS[1], 1, entry)
+		if redis.call('LLEN', KEYS[1]) == 0 then
+			redis.call('ZREM', KEYS[2], KEYS[1])
+		end
+		return entry
+	end
+end
+return false
+`)
+
+// fillLua is shared by the scripts that match orders.
+// rewrite only replaces the quantity, fill and hidden quantity of a stored order,
+// re-encoding it with cjson would round the decimal price.
+// fill takes quantity from the order at the head of a queue and drops the order once it is fully matched,
+// an iceberg order queues its next slice at the back of the queue instead.
+const fillLua = `
+local function rewrite(entry, quantity, filled, hidden)
+	local updated = string.gsub(entry, '"quantity":%d+', '"quantity":' .. string.format('%d', quantity), 1)
+	updated = string.gsub(updated, '"filled":%d+', '"filled":' .. string.format('%d', filled), 1)
+	if hidden then
+		updated = string.gsub(updated, '"hidden":%d+', '"hidden":' .. string.format('%d', hidden), 1)
+	end
+	return updated
+end
+
+local function fill(heap, queue, entry, stored, quantity)
+	local filled = (stored['filled'] or 0) + quantity
+	local hidden = stored['hidden'] or 0
+	if stored['quantity'] > quantity then
+		redis.call('LSET', queue, 0, rewrite(entry, stored['quantity'] - quantity, filled))
+	elseif hidden > 0 then
+		local slice = math.min(stored['display'], hidden)
+		redis.call('RPUSH', queue, rewrite(entry, slice, filled, hidden - slice))
+		redis.call('LPOP', queue)
+	else
+		redis.call('LPOP', queue)
+		if redis.call('LLEN', queue) == 0 then
+			redis.call('ZREM', heap, queue)
+		end
+	end
+end
+`
+
+// selfTradeLua is shared by the scripts that match orders, it needs fillLua.
+// preventSelfTrade mirrors the Go preventSelfTrade and returns the mode with the quantities to take out of
+// the newer and the older order, or nothing when the orders may trade.
+// shrink takes quantity out of the order at the head of a queue without trading it, the hidden quantity first.
+const selfTradeLua = `
+local function preventSelfTrade(newer, older)
+	if newer['user_id'] ~= older['user_id'] then
+		return nil
+	end
+	local mode = newer['stp'] or 0
+	local newerOpen = newer['quantity'] + (newer['hidden'] or 0)
+	local olderOpen = older['quantity'] + (older['hidden'] or 0)
+	if mode == 1 then
+		return mode, newerOpen, 0
+	elseif mode == 2 then
+		return mode, 0, olderOpen
+	elseif mode == 3 then
+		return mode, newerOpen, olderOpen
+	elseif mode == 4 then
+		local decrement = math.min(newerOpen, olderOpen)
+		return mode, decrement, decrement
+	end
+	return nil
+end
+
+-- arrivedAfter mirrors the Go arrivedAfter
+local function arrivedAfter(a, b)
+	local aSeq, bSeq = a['seq'] or 0, b['seq'] or 0
+	if aSeq ~= bSeq then
+		return aSeq > bSeq
+	end
+	return a['order_id'] > b['order_id']
+end
+
+local function selfTradeJSON(mode, buyRemoved, sellRemoved)
+	return string.format('{"mode":%d,"buy_removed":%d,"sell_removed":%d}', mode, buyRemoved, sellRemoved)
+end
+
+local function shrink(heap, queue, entry, stored, quantity)
+	local hidden = stored['hidden'] or 0
+	if stored['quantity'] + hidden <= quantity then
+		redis.call('LPOP', queue)
+		if redis.call('LLEN', queue) == 0 then
+			redis.call('ZREM', heap, queue)
+		end
+		return
+	end
+	local fromHidden = math.min(hidden, quantity)
+	redis.call('LSET', queue, 0, rewrite(entry, stored['quantity'] - quantity + fromHidden, stored['filled'] or 0, hidden - fromHidden))
+end
+`
+
+// reduceOrderScript lowers the quantity of a queued order in place, so it keeps its position in the queue.
+// The hidden quantity of an iceberg order is reduced first.
+// KEYS[1]: queue key, ARGV[1]: order id, ARGV[2]: quantity to take off
+// returns {1, reduced order}, {0, order} when the order has no more than the quantity left, or false when it is not queued
+var reduceOrderScript = redis.NewScript(fillLua + `
+local reduction = tonumber(ARGV[2])
+local entries = redis.call('LRANGE', KEYS[1], 0, -1)
+for i, entry in ipairs(entries) do
+	local stored = cjson.decode(entry)
+	if stored['order_id'] == tonumber(ARGV[1]) then
+		local hidden = stored['hidden'] or 0
+		if stored['quantity'] + hidden <= reduction then
+			return {0, entry}
+		end
+		local fromHidden = math.min(hidden, reduction)
+		local reduced = rewrite(entry, stored['quantity'] - reduction + fromHidden, stored['filled'] or 0, hidden - fromHidden)
+		redis.call('LSET', KEYS[1], i - 1, reduced)
+		return {1, reduced}
+	end
+end
+return false
+`)
+
+// matchOrderScript crosses the best bid against the best ask of one stock.
+// The order that reached the book first is the resting order and the trade executes at its price,
+// a partially filled order keeps its place at the head of its queue with the remaining quantity.
+// The match goes to the settlement stream in the same step, so it cannot leave the book without being logged.
+// Orders of the same user do not trade when the newer one has a self-trade prevention mode,
+// the quantity it removes is logged with no matched quantity instead.
+// KEYS[1]: buy heap key, KEYS[2]: sell heap key, KEYS[3]: settlement stream key
+// returns {settlement id, buy order before the match, sell order before the match, matched quantity, self trade or an empty string}
+var matchOrderScript = redis.NewScript(fillLua + selfTradeLua + `
+local bid = redis.call('ZREVRANGE', KEYS[1], 0, 0, 'WITHSCORES')
+local ask = redis.call('ZRANGE', KEYS[2], 0, 0, 'WITHSCORES')
+if #bid == 0 or #ask == 0 or tonumber(bid[2]) < tonumber(ask[2]) then
+	return false
+end
+
+local buyEntry = redis.call('LINDEX', bid[1], 0)
+if not buyEntry then
+	redis.call('ZREM', KEYS[1], bid[1])
+	return false
+end
+local sellEntry = redis.call('LINDEX', ask[1], 0)
+if not sellEntry then
+	redis.call('ZREM', KEYS[2], ask[1])
+	return false
+end
+
+local buy = cjson.decode(buyEntry)
+local sell = cjson.decode(sellEntry)
+
+local buyNewer = arrivedAfter(buy, sell)
+local mode, newerRemoved, olderRemoved
+if buyNewer then
+	mode, newerRemoved, olderRemoved = preventSelfTrade(buy, sell)
+else
+	mode, newerRemoved, olderRemoved = preventSelfTrade(sell, buy)
+end
+if mode then
+	local buyRemoved, sellRemoved = olderRemoved, newerRemoved
+	if buyNewer then
+		buyRemoved, sellRemoved = newerRemoved, olderRemoved
+	end
+	if buyRemoved > 0 then
+		shrink(KEYS[1], bid[1], buyEntry, buy, buyRemoved)
+	end
+	if sellRemoved > 0 then
+		shrink(KEYS[2], ask[1], sellEntry, sell, sellRemoved)
+	end
+	local selfTrade = selfTradeJSON(mode, buyRemoved, sellRemoved)
+	local id = redis.call('XADD', KEYS[3], '*', 'buy', buyEntry, 'sell', sellEntry, 'quantity', 0, 'self_trade', selfTrade)
+	return {id, buyEntry, sellEntry, 0, selfTrade}
+end
+
+local quantity = math.min(buy['quantity'], sell['quantity'])
+
+fill(KEYS[1], bid[1], buyEntry, buy, quantity)
+fill(KEYS[2], ask[1], sellEntry, sell, quantity)
+local id = redis.call('XADD', KEYS[3], '*', 'buy', buyEntry, 'sell', sellEntry, 'quantity', quantity)
+return {id, buyEntry, sellEntry, quantity, ''}
+`)
+
+// takeScript matches an incoming order against the opposite side of the book without queuing it,
+// level by level from the best price as long as the price is within the order's limit.
+// With all or none set nothing is matched unless the whole quantity is available without trading with the user's own orders.
+// The incoming order is always the newer one for self-trade prevention, it gets its arrival sequence before it is matched.
+// KEYS[1]: opposite heap key, KEYS[2]: settlement stream key, KEYS[3]: sequence key
+// ARGV[1]: incoming order, ARGV[2]: 1 when it is a buy order, ARGV[3]: limit price score, ARGV[4]: 1 for all or none
+// returns {{settlement id, buy order before the match, sell order before the match, matched quantity, self trade or an empty string}, ...}
+var takeScript = redis.NewScript(fillLua + selfTradeLua + sequenceLua + `
+local taker = cjson.decode(ARGV[1])
+local takerEntry = ARGV[1]
+local remaining = taker['quantity']
+local filled = taker['filled'] or 0
+local buying = ARGV[2] == '1'
+local limit = tonumber(ARGV[3])
+
+local function level(offset)
+	if buying then
+		return redis.call('ZRANGE', KEYS[1], offset, offset, 'WITHSCORES')
+	end
+	return redis.call('ZREVRANGE', KEYS[1], offset, offset, 'WITHSCORES')
+end
+
+local function crosses(found)
+	if #found == 0 then
+		return false
+	end
+	if buying then
+		return tonumber(found[2]) <= limit
+	end
+	return tonumber(found[2]) >= limit
+end
+
+if ARGV[4] == '1' then
+	local available = 0
+	local offset = 0
+	while available < remaining do
+		local found = level(offset)
+		if not crosses(found) then
+			return {}
+		end
+		for _, entry in ipairs(redis.call('LRANGE', found[1], 0, -1)) do
+			if available >= remaining then
+				break
+			end
+			local resting = cjson.decode(entry)
+			if not preventSelfTrade(taker, resting) then
+				available = available + resting['quantity'] + (resting['hidden'] or 0)
+			elseif (taker['stp'] or 0) ~= 2 then
+				-- only cancelling the resting order lets the taker go on past it
+				return {}
+			end
+		end
+		offset = offset + 1
+	end
+end
+
+takerEntry = sequence(takerEntry, KEYS[3])
+local matches = {}
+while remaining > 0 do
+	local found = level(0)
+	if not crosses(found) then
+		break
+	end
+	local restingEntry = redis.call('LINDEX', found[1], 0)
+	if not restingEntry then
+		redis.call('ZREM', KEYS[1], found[1])
+	else
+		local resting = cjson.decode(restingEntry)
+		local buyEntry, sellEntry = takerEntry, restingEntry
+		if not buying then
+			buyEntry, sellEntry = restingEntry, takerEntry
+		end
+
+		taker['quantity'] = remaining
+		local mode, takerRemoved, restingRemoved = preventSelfTrade(taker, resting)
+		if mode then
+			if restingRemoved > 0 then
+				shrink(KEYS[1], found[1], restingEntry, resting, restingRemoved)
+			end
+			local selfTrade = selfTradeJSON(mode, takerRemoved, restingRemoved)
+			if not buying then
+				selfTrade = selfTradeJSON(mode, restingRemoved, takerRemoved)
+			end
+			local id = redis.call('XADD', KEYS[2], '*', 'buy', buyEntry, 'sell', sellEntry, 'quantity', 0, 'self_trade', selfTrade)
+			table.insert(matches, {id, buyEntry, sellEntry, 0, selfTrade})
+
+			remaining = remaining - takerRemoved
+		else
+			local quantity = math.min(remaining, resting['quantity'])
+			fill(KEYS[1], found[1], restingEntry, resting, quantity)
+
+			local id = redis.call('XADD', KEYS[2], '*', 'buy', buyEntry, 'sell', sellEntry, 'quantity', quantity)
+			table.insert(matches, {id, buyEntry, sellEntry, quantity, ''})
+
+			remaining = remaining - quantity
+			filled = filled + quantity
+		end
+		takerEntry = rewrite(takerEntry, remaining, filled)
+	end
+end
+return matches
+`)
+
+// deadLetterScript moves a match from the settlement stream to the dead letter stream.
+// KEYS[1]: settlement stream key, KEYS[2]: dead letter stream key
+// ARGV[1]: consumer group, ARGV[2]: settlement id, ARGV[3]: match, ARGV[4]: reason, ARGV[5]: failed at
+var deadLetterScript = redis.NewScript(`
+redis.call('XADD', KEYS[2], '*', 'match', ARGV[3], 'reason', ARGV[4], 'failed_at', ARGV[5])
+redis.call('XACK', KEYS[1], ARGV[1], ARGV[2])
+return redis.call('XDEL', KEYS[1], ARGV[2])
+`)
+
+// depthScript aggregates the first price levels of a heap.
+// KEYS[1]: heap key, ARGV[1]: 1 for descending prices, ARGV[2]: index of the last level
+// returns {{queue key, quantity, orders}, ...}
+var depthScript = redis.NewScript(`
+local members
+if ARGV[1] == '1' then
+	members = redis.call('ZREVRANGE', KEYS[1], 0, tonumber(ARGV[2]))
+else
+	members = redis.call('ZRANGE', KEYS[1], 0, tonumber(ARGV[2]))
+end
+local levels = {}
+for _, queue in ipairs(members) do
+	local entries = redis.call('LRANGE', queue, 0, -1)
+	local quantity = 0
+	for _, entry in ipairs(entries) do
+		quantity = quantity + cjson.decode(entry)['quantity']
+	end
+	if #entries > 0 then
+		table.insert(levels, {queue, quantity, #entries})
+	end
+end
+return levels
+`)
+
+// snapshotScript returns every order of a heap.
+// KEYS[1]: heap key, ARGV[1]: 1 for descending prices
+// returns {{queue key, {order, ...}}, ...}
+var snapshotScript = redis.NewScript(`
+local members
+if ARGV[1] == '1' then
+	members = redis.call('ZREVRANGE', KEYS[1], 0, -1)
+else
+	members = redis.call('ZRANGE', KEYS[1], 0, -1)
+end
+local levels = {}
+for _, queue in ipairs(members) do
+	table.insert(levels, {queue, redis.call('LRANGE', queue, 0, -1)})
+end
+return levels
+`)
+
+func heapKey(stockID int64, side Side) (string, error) {
+	switch side {
+	case Buy:
+		return fmt.Sprintf("buy_heap_%d", stockID), nil
+	case Sell:
+		return fmt.Sprintf("sell_heap_%d", stockID), nil
+	default:
+		return "", ErrInvalidSide
+	}
+}
+
+func queueKey(stockID int64, side Side, price data.Money) (string, error) {
+	switch side {
+	case Buy:
+		return fmt.Sprintf("buy_%d_at_%s", stockID, price), nil
+	case Sell:
+		return fmt.Sprintf("sell_%d_at_%s", stockID, price), nil
+	default:
+		return "", ErrInvalidSide
+	}
+}
+
+// queuePrice reads the exact price back from a queue key
+func queuePrice(queue string) (data.Money, error) {
+	_, priceText, found := strings.Cut(queue, "_at_")
+	if !found {
+		return 0, fmt.Errorf("unexpected queue key %q", queue)
+	}
+	return data.ParseMoney(priceText)
+}
+
+func settlementKey(stockID int64) string {
+	return fmt.Sprintf("settlement_%d", stockID)
+}
+
+func deadLetterKey(stockID int64) string {
+	return fmt.Sprintf("settlement_dead_%d", stockID)
+}
+
+func sequenceKey(stockID int64) string {
+	return fmt.Sprintf("order_seq_%d", stockID)
+}
+
+func (b *Redis) Add(ctx context.Context, order Order) error {
+	heap, err := heapKey(order.StockID, order.Side)
+	if err != nil {
+		return err
+	}
+	queue, err := queueKey(order.StockID, order.Side, order.Price)
+	if err != nil {
+		return err
+	}
+
+	orderJSON, err := json.Marshal(order)
+	if err != nil {
+		return err
+	}
+
+	return addOrderScript.Run(ctx, b.client, []string{queue, heap, sequenceKey(order.StockID)}, order.Price.Float64(), orderJSON).Err()
+}
+
+// AddBatch sends every order in one transaction, a script can not be loaded from within it so the scripts are sent in full
+func (b *Redis) AddBatch(ctx context.Context, orders []Order) error {
+	_, err := b.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, order := range orders {
+			heap, err := heapKey(order.StockID, order.Side)
+			if err != nil {
+				return err
+			}
+			queue, err := queueKey(order.StockID, order.Side, order.Price)
+			if err != nil {
+				return err
+			}
+			orderJSON, err := json.Marshal(order)
+			if err != nil {
+				return err
+			}
+			addOrderScript.Eval(ctx, pipe, []string{queue, heap, sequenceKey(order.StockID)}, order.Price.Float64(), orderJSON)
+		}
+		return nil
+	})
+	return err
+}
+
+func (b *Redis) AddPassive(ctx context.Context, order Order) error {
+	heap, err := heapKey(order.StockID, order.Side)
+	if err != nil {
+		return err
+	}
+	opposite, err := heapKey(order.StockID, order.Side.Opposite())
+	if err != nil {
+		return err
+	}
+	queue, err := queueKey(order.StockID, order.Side, order.Price)
+	if err != nil {
+		return err
+	}
+
+	orderJSON, err := json.Marshal(order)
+	if err != nil {
+		return err
+	}
+
+	queued, err := addPassiveScript.Run(ctx, b.client, []string{queue, heap, opposite, sequenceKey(order.StockID)}, order.Price.Float64(), orderJSON, int(order.Side)).Int()
+	if err != nil {
+		return err
+	}
+	if queued == 0 {
+		return ErrWouldCross
+	}
+	return nil
+}
+
+func (b *Redis) Cancel(ctx context.Context, stockID int64, side Side, price data.Money, orderID int64) (*Order, error) {
+	heap, err := heapKey(stockID, side)
+	if err != nil {
+		return nil, err
+	}
+	queue, err := queueKey(stockID, side, price)
+	if err != nil {
+		return nil, err
+	}
+
+	orderJSON, err := removeOrderScript.Run(ctx, b.client, []string{queue, heap}, orderID).Text()
+	if err != nil {
+		switch {
+		case errors.Is(err, redis.Nil):
+			return nil, ErrOrderNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	order, err := decodeOrder(orderJSON, side)
+	if err != nil {
+		return nil, err
+	}
+
+	return order, nil
+}
+
+func (b *Redis) CancelBatch(ctx context.Context, orders []Order) ([]*Order, error) {
+	cmds := make([]*redis.Cmd, len(orders))
+	_, err := b.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, order := range orders {
+			heap, err := heapKey(order.StockID, order.Side)
+			if err != nil {
+				return err
+			}
+			queue, err := queueKey(order.StockID, order.Side, order.Price)
+			if err != nil {
+				return err
+			}
+			cmds[i] = removeOrderScript.Eval(ctx, pipe, []string{queue, heap}, order.OrderID)
+		}
+		return nil
+	})
+	// redis.Nil only means one of the orders was not queued
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return nil, err
+	}
+
+	removed := make([]*Order, len(orders))
+	for i, cmd := range cmds {
+		orderJSON, err := cmd.Text()
+		if err != nil {
+			if errors.Is(err, redis.Nil) {
+				continue
+			}
+			return nil, err
+		}
+		removed[i], err = decodeOrder(orderJSON, orders[i].Side)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return removed, nil
+}
+
+func (b *Redis) Reduce(ctx context.Context, stockID int64, side Side, price data.Money, orderID int64, quantity int) (*Order, error) {
+	queue, err := queueKey(stockID, side, price)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := reduceOrderScript.Run(ctx, b.client, []string{queue}, orderID, quantity).Slice()
+	if err != nil {
+		switch {
+		case errors.Is(err, redis.Nil):
+			return nil, ErrOrderNotFound
+		default:
+			return nil, err
+		}
+	}
+	if len(result) != 2 {
+		return nil, fmt.Errorf("unexpected reduce result %v", result)
+	}
+	if reduced, _ := result[0].(int64); reduced == 0 {
+		return nil, ErrReduceExceeds
+	}
+
+	orderJSON, _ := result[1].(string)
+	return decodeOrder(orderJSON, side)
+}
+
+func (b *Redis) Best(ctx context.Context, stockID int64, side Side) (*Level, error) {
+	levels, err := b.Depth(ctx, stockID, side, 1)
+	if err != nil {
+		return nil, err
+	}
+	if len(levels) == 0 {
+		return nil, nil
+	}
+	return &levels[0], nil
+}
+
+func (b *Redis) PopMatch(ctx context.Context, stockID int64) (*Match, error) {
+	buyHeap, _ := heapKey(stockID, Buy)
+	sellHeap, _ := heapKey(stockID, Sell)
+
+	result, err := matchOrderScript.Run(ctx, b.client, []string{buyHeap, sellHeap, settlementKey(stockID)}).Slice()
+	if err != nil {
+		switch {
+		case errors.Is(err, redis.Nil):
+			return nil, nil
+		default:
+			return nil, err
+		}
+	}
+	if len(result) != 5 {
+		return nil, fmt.Errorf("unexpected match result %v", result)
+	}
+
+	id, _ := result[0].(string)
+	buyJSON, _ := result[1].(string)
+	sellJSON, _ := result[2].(string)
+	quantity, _ := result[3].(int64)
+	selfTradeJSON, _ := result[4].(string)
+
+	return decodeMatch(id, buyJSON, sellJSON, int(quantity), selfTradeJSON)
+}
+
+func (b *Redis) Take(ctx context.Context, order Order, allOrNone bool) ([]Match, error) {
+	opposite, err := heapKey(order.StockID, order.Side.Opposite())
+	if err != nil {
+		return nil, err
+	}
+
+	orderJSON, err := json.Marshal(order)
+	if err != nil {
+		return nil, err
+	}
+	buying, all := 0, 0
+	if order.Side == Buy {
+		buying = 1
+	}
+	if allOrNone {
+		all = 1
+	}
+
+	keys := []string{opposite, settlementKey(order.StockID), sequenceKey(order.StockID)}
+	result, err := takeScript.Run(ctx, b.client, keys, orderJSON, buying, order.Price.Float64(), all).Slice()
+	if err != nil {
+		return nil, err
+	}
+
+	matches := make([]Match, 0, len(result))
+	for _, item := range result {
+		fields, ok := item.([]any)
+		if !ok || len(fields) != 5 {
+			return nil, fmt.Errorf("unexpected take result %v", item)
+		}
+		id, _ := fields[0].(string)
+		buyJSON, _ := fields[1].(string)
+		sellJSON, _ := fields[2].(string)
+		quantity, _ := fields[3].(int64)
+		selfTradeJSON, _ := fields[4].(string)
+
+		match, err := decodeMatch(id, buyJSON, sellJSON, int(quantity), selfTradeJSON)
+		if err != nil {
+			return nil, err
+		}
+		matches = append(matches, *match)
+	}
+
+	return matches, nil
+}
+
+func (b *Redis) PendingMatches(ctx context.Context, stockID int64, after string, count int) ([]Match, error) {
+	stream := settlementKey(stockID)
+	err := b.ensureSettlementGroup(ctx, stream)
+	if err != nil {
+		return nil, err
+	}
+
+	// reading pending entries from an id returns the ones after it, claiming starts at the id itself
+	readStart, claimStart := "0", "0-0"
+	if after != "" {
+		readStart = after
+		claimStart, err = nextStreamID(after)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// matches this consumer read before but never acknowledged, e.g. before a restart
+	messages, err := b.readSettlements(ctx, stream, readStart, count)
+	if err != nil {
+		return nil, err
+	}
+	// matches left behind by consumers that went away
+	if len(messages) == 0 {
+		messages, _, err = b.client.XAutoClaim(ctx, &redis.XAutoClaimArgs{
+			Stream:   stream,
+			Group:    settlementGroup,
+			Consumer: b.consumer,
+			MinIdle:  settlementClaimIdle,
+			Start:    claimStart,
+			Count:    int64(count),
+		}).Result()
+		if err != nil {
+			return nil, err
+		}
+	}
+	// new matches
+	if len(messages) == 0 {
+		messages, err = b.readSettlements(ctx, stream, ">", count)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	matches := make([]Match, 0, len(messages))
+	for _, message := range messages {
+		buyJSON, _ := message.Values["buy"].(string)
+		sellJSON, _ := message.Values["sell"].(string)
+		quantityText, _ := message.Values["quantity"].(string)
+		selfTradeJSON, _ := message.Values["self_trade"].(string)
+		if buyJSON == "" || sellJSON == "" {
+			// deleted after it was read, nothing left to settle
+			err = b.client.XAck(ctx, stream, settlementGroup, message.ID).Err()
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		quantity, err := strconv.Atoi(quantityText)
+		if err != nil {
+			return nil, err
+		}
+		match, err := decodeMatch(message.ID, buyJSON, sellJSON, quantity, selfTradeJSON)
+		if err != nil {
+			return nil, err
+		}
+		matches = append(matches, *match)
+	}
+
+	return matches, nil
+}
+
+// nextStreamID returns the smallest stream id after id
+func nextStreamID(id string) (string, error) {
+	millis, seq, ok := strings.Cut(id, "-")
+	if !ok {
+		return "", fmt.Errorf("invalid stream id %q", id)
+	}
+	n, err := strconv.ParseUint(seq, 10, 64)
+	if err != nil {
+		return "", fmt.Errorf("invalid stream id %q", id)
+	}
+	return millis + "-" + strconv.FormatUint(n+1, 10), nil
+}
+
+func (b *Redis) readSettlements(ctx context.Context, stream, start string, count int) ([]redis.XMessage, error) {
+	streams, err := b.client.XReadGroup(ctx, &redis.XReadGroupArgs{
+		Group:    settlementGroup,
+		Consumer: b.consumer,
+		Streams:  []string{stream, start},
+		Count:    int64(count),
+		Block:    -1,
+	}).Result()
+	if err != nil {
+		switch {
+		case errors.Is(err, redis.Nil):
+			return nil, nil
+		default:
+			return nil, err
+		}
+	}
+	if len(streams) == 0 {
+		return nil, nil
+	}
+	return streams[0].Messages, nil
+}
+
+// ensureSettlementGroup creates the consumer group of a settlement stream from its first entry
+func (b *Redis) ensureSettlementGroup(ctx context.Context, stream string) error {
+	if _, ok := b.groups.Load(stream); ok {
+		return nil
+	}
+	err := b.client.XGroupCreateMkStream(ctx, stream, settlementGroup, "0").Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return err
+	}
+	b.groups.Store(stream, true)
+	return nil
+}
+
+func (b *Redis) AckMatch(ctx context.Context, stockID int64, matchID string) error {
+	stream := settlementKey(stockID)
+	_, err := b.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.XAck(ctx, stream, settlementGroup, matchID)
+		pipe.XDel(ctx, stream, matchID)
+		return nil
+	})
+	return err
+}
+
+func (b *Redis) DeadLetter(ctx context.Context, stockID int64, match Match, reason string) error {
+	matchJSON, err := json.Marshal(match)
+	if err != nil {
+		return err
+	}
+
+	keys := []string{settlementKey(stockID), deadLetterKey(stockID)}
+	return deadLetterScript.Run(ctx, b.client, keys, settlementGroup, match.ID, matchJSON, reason, time.Now().UTC().Format(time.RFC3339Nano)).Err()
+}
+
+func (b *Redis) DeadLetters(ctx context.Context, stockID int64) ([]DeadLetter, error) {
+	messages, err := b.client.XRange(ctx, deadLetterKey(stockID), "-", "+").Result()
+	if err != nil {
+		return nil, err
+	}
+
+	deadLetters := make([]DeadLetter, 0, len(messages))
+	for _, message := range messages {
+		matchJSON, _ := message.Values["match"].(string)
+		reason, _ := message.Values["reason"].(string)
+		failedAtText, _ := message.Values["failed_at"].(string)
+
+		var deadLetter DeadLetter
+		err := json.Unmarshal([]byte(matchJSON), &deadLetter.Match)
+		if err != nil {
+			return nil, err
+		}
+		deadLetter.Reason = reason
+		deadLetter.FailedAt, err = time.Parse(time.RFC3339Nano, failedAtText)
+		if err != nil {
+			return nil, err
+		}
+		deadLetters = append(deadLetters, deadLetter)
+	}
+
+	return deadLetters, nil
+}
+
+func (b *Redis) Depth(ctx context.Context, stockID int64, side Side, levels int) ([]Level, error) {
+	heap, err := heapKey(stockID, side)
+	if err != nil {
+		return nil, err
+	}
+	if levels < 1 {
+		return []Level{}, nil
+	}
+
+	result, err := depthScript.Run(ctx, b.client, []string{heap}, descending(side), levels-1).Slice()
+	if err != nil {
+		return nil, err
+	}
+
+	depth := make([]Level, 0, len(result))
+	for _, item := range result {
+		fields, ok := item.([]any)
+		if !ok || len(fields) != 3 {
+			return nil, fmt.Errorf("unexpected depth result %v", item)
+		}
+		queue, _ := fields[0].(string)
+		quantity, _ := fields[1].(int64)
+		orders, _ := fields[2].(int64)
+
+		price, err := queuePrice(queue)
+		if err != nil {
+			return nil, err
+		}
+		depth = append(depth, Level{Price: price, Quantity: int(quantity), Orders: int(orders)})
+	}
+
+	return depth, nil
+}
+
+func (b *Redis) Snapshot(ctx context.Context, stockID int64) (*Snapshot, error) {
+	snapshot := &Snapshot{StockID: stockID}
+
+	var err error
+	snapshot.Bids, err = b.snapshotSide(ctx, stockID, Buy)
+	if err != nil {
+		return nil, err
+	}
+	snapshot.Asks, err = b.snapshotSide(ctx, stockID, Sell)
+	if err != nil {
+		return nil, err
+	}
+
+	return snapshot, nil
+}
+
+func (b *Redis) snapshotSide(ctx context.Context, stockID int64, side Side) ([]Order, error) {
+	heap, err := heapKey(stockID, side)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := snapshotScript.Run(ctx, b.client, []string{heap}, descending(side)).Slice()
+	if err != nil {
+		return nil, err
+	}
+
+	orders := []Order{}
+	for _, item := range result {
+		fields, ok := item.([]any)
+		if !ok || len(fields) != 2 {
+			return nil, fmt.Errorf("unexpected snapshot result %v", item)
+		}
+		entries, _ := fields[1].([]any)
+
+		for _, entry := range entries {
+			orderJSON, _ := entry.(string)
+			order, err := decodeOrder(orderJSON, side)
+			if err != nil {
+				return nil, err
+			}
+			orders = append(orders, *order)
+		}
+	}
+
+	return orders, nil
+}
+
+// decodeOrder reads a queued order, side comes from the heap it was stored under
+func decodeOrder(orderJSON string, side Side) (*Order, error) {
+	var order Order
+	err := json.Unmarshal([]byte(orderJSON), &order)
+	if err != nil {
+		return nil, err
+	}
+	order.Side = side
+	return &order, nil
+}
+
+func (b *Redis) Settlements(ctx context.Context, stockID int64) ([]Match, error) {
+	messages, err := b.client.XRange(ctx, settlementKey(stockID), "-", "+").Result()
+	if err != nil {
+		return nil, err
+	}
+
+	matches := make([]Match, 0, len(messages))
+	for _, message := range messages {
+		buyJSON, _ := message.Values["buy"].(string)
+		sellJSON, _ := message.Values["sell"].(string)
+		quantityText, _ := message.Values["quantity"].(string)
+		selfTradeJSON, _ := message.Values["self_trade"].(string)
+
+		quantity, err := strconv.Atoi(quantityText)
+		if err != nil {
+			return nil, err
+		}
+		match, err := decodeMatch(message.ID, buyJSON, sellJSON, quantity, selfTradeJSON)
+		if err != nil {
+			return nil, err
+		}
+		matches = append(matches, *match)
+	}
+
+	return matches, nil
+}
+
+func (b *Redis) StaleLevels(ctx context.Context, stockID int64, remove bool) ([]string, error) {
+	stale := []string{}
+	for _, side := range []Side{Buy, Sell} {
+		heap, _ := heapKey(stockID, side)
+		members, err := b.client.ZRange(ctx, heap, 0, -1).Result()
+		if err != nil {
+			return nil, err
+		}
+		indexed := make(map[string]bool, len(members))
+		for _, queue := range members {
+			indexed[queue] = true
+			length, err := b.client.LLen(ctx, queue).Result()
+			if err != nil {
+				return nil, err
+			}
+			if length > 0 {
+				continue
+			}
+			stale = append(stale, fmt.Sprintf("%s lists %s without orders", heap, queue))
+			if remove {
+				err = b.client.ZRem(ctx, heap, queue).Err()
+				if err != nil {
+					return nil, err
+				}
+			}
+		}
+
+		// queues the matcher can not reach, adding an order at their price would bring their entries back
+		prefix, _ := queueKey(stockID, side, 0)
+		prefix = strings.TrimSuffix(prefix, "0")
+		iter := b.client.Scan(ctx, 0, prefix+"*", 100).Iterator()
+		for iter.Next(ctx) {
+			queue := iter.Val()
+			if indexed[queue] {
+				continue
+			}
+			stale = append(stale, fmt.Sprintf("%s is not listed in %s", queue, heap))
+			if remove {
+				err = b.client.Del(ctx, queue).Err()
+				if err != nil {
+					return nil, err
+				}
+			}
+		}
+		if err := iter.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	return stale, nil
+}
+
+// decodeMatch rebuilds a match from the orders as they were before it, the trade time comes from the stream id.
+// selfTradeJSON is empty unless self-trade prevention kept the orders from trading.
+func decodeMatch(id, buyJSON, sellJSON string, quantity int, selfTradeJSON string) (*Match, error) {
+	buy, err := decodeOrder(buyJSON, Buy)
+	if err != nil {
+		return nil, err
+	}
+	sell, err := decodeOrder(sellJSON, Sell)
+	if err != nil {
+		return nil, err
+	}
+
+	millisText, _, _ := strings.Cut(id, "-")
+	millis, err := strconv.ParseInt(millisText, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("unexpected settlement id %q", id)
+	}
+
+	match := &Match{
+		ID:         id,
+		Buy:        *buy,
+		Sell:       *sell,
+		ExecutedAt: time.UnixMilli(millis).UTC(),
+	}
+	if selfTradeJSON != "" {
+		match.SelfTrade = &SelfTrade{}
+		err = json.Unmarshal([]byte(selfTradeJSON), match.SelfTrade)
+		if err != nil {
+			return nil, err
+		}
+		return match, nil
+	}
+	match.Quantity = quantity
+	match.Price = restingPrice(*buy, *sell)
+
+	return match, nil
+}
+
+func descending(side Side) int {
+	if side == Buy {
+		return 1
+	}
+	return 0
+}
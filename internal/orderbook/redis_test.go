@@ -0,0 +1,39 @@
+package orderbook
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDecodeMatchExecutesInUTC(t *testing.T) {
+	inZone(t)
+	match, err := decodeMatch("1700000000000-0", `{"order_id":1}`, `{"order_id":2}`, 1, "")
+	if err != nil {
+		t.Fatalf("decodeMatch: %v", err)
+	}
+	want := time.UnixMilli(1700000000000)
+	if match.ExecutedAt.Location() != time.UTC || !match.ExecutedAt.Equal(want) {
+		t.Errorf("executed at %v, want %v in UTC", match.ExecutedAt, want.UTC())
+	}
+}
+
+func TestNextStreamID(t *testing.T) {
+	tests := []struct {
+		id, want string
+	}{
+		{"1700000000000-0", "1700000000000-1"},
+		{"1700000000000-41", "1700000000000-42"},
+	}
+	for _, tt := range tests {
+		got, err := nextStreamID(tt.id)
+		if err != nil {
+			t.Fatalf("nextStreamID(%q): %v", tt.id, err)
+		}
+		if got != tt.want {
+			t.Errorf("nextStreamID(%q) = %q, want %q", tt.id, got, tt.want)
+		}
+	}
+	if _, err := nextStreamID("1"); err == nil {
+		t.Error("nextStreamID(\"1\") accepted an id without a sequence")
+	}
+}
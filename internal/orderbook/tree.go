@@ -0,0 +1,191 @@
+package orderbook
+
+import (
+	"container/list"
+
+	"github.com/maxwellkuo47/tradingEngine/internal/data"
+)
+
+// priceLevel is a FIFO queue of the orders resting at one price
+type priceLevel struct {
+	price    data.Money
+	quantity int
+	orders   *list.List // *Order
+}
+
+func (l *priceLevel) toLevel() Level {
+	return Level{Price: l.price, Quantity: l.quantity, Orders: l.orders.Len()}
+}
+
+// priceTree is an AVL tree of price levels ordered by ascending price
+type priceTree struct {
+	root *treeNode
+}
+
+type treeNode struct {
+	level       *priceLevel
+	left, right *treeNode
+	height      int
+}
+
+func (t *priceTree) get(price data.Money) *priceLevel {
+	n := t.root
+	for n != nil {
+		switch {
+		case price < n.level.price:
+			n = n.left
+		case price > n.level.price:
+			n = n.right
+		default:
+			return n.level
+		}
+	}
+	return nil
+}
+
+func (t *priceTree) put(level *priceLevel) {
+	t.root = insertNode(t.root, level)
+}
+
+func (t *priceTree) remove(price data.Money) {
+	t.root = deleteNode(t.root, price)
+}
+
+func (t *priceTree) min() *priceLevel {
+	if t.root == nil {
+		return nil
+	}
+	n := t.root
+	for n.left != nil {
+		n = n.left
+	}
+	return n.level
+}
+
+func (t *priceTree) max() *priceLevel {
+	if t.root == nil {
+		return nil
+	}
+	n := t.root
+	for n.right != nil {
+		n = n.right
+	}
+	return n.level
+}
+
+// ascend walks the levels from the lowest price until fn returns false
+func (t *priceTree) ascend(fn func(*priceLevel) bool) {
+	ascendNode(t.root, fn)
+}
+
+// descend walks the levels from the highest price until fn returns false
+func (t *priceTree) descend(fn func(*priceLevel) bool) {
+	descendNode(t.root, fn)
+}
+
+func ascendNode(n *treeNode, fn func(*priceLevel) bool) bool {
+	if n == nil {
+		return true
+	}
+	return ascendNode(n.left, fn) && fn(n.level) && ascendNode(n.right, fn)
+}
+
+func descendNode(n *treeNode, fn func(*priceLevel) bool) bool {
+	if n == nil {
+		return true
+	}
+	return descendNode(n.right, fn) && fn(n.level) && descendNode(n.left, fn)
+}
+
+func height(n *treeNode) int {
+	if n == nil {
+		return 0
+	}
+	return n.height
+}
+
+func (n *treeNode) update() {
+	n.height = 1 + max(height(n.left), height(n.right))
+}
+
+func (n *treeNode) balanceFactor() int {
+	return height(n.left) - height(n.right)
+}
+
+func rotateRight(n *treeNode) *treeNode {
+	l := n.left
+	n.left = l.right
+	l.right = n
+	n.update()
+	l.update()
+	return l
+}
+
+func rotateLeft(n *treeNode) *treeNode {
+	r := n.right
+	n.right = r.left
+	r.left = n
+	n.update()
+	r.update()
+	return r
+}
+
+func rebalance(n *treeNode) *treeNode {
+	n.update()
+	switch bf := n.balanceFactor(); {
+	case bf > 1:
+		if n.left.balanceFactor() < 0 {
+			n.left = rotateLeft(n.left)
+		}
+		return rotateRight(n)
+	case bf < -1:
+		if n.right.balanceFactor() > 0 {
+			n.right = rotateRight(n.right)
+		}
+		return rotateLeft(n)
+	}
+	return n
+}
+
+func insertNode(n *treeNode, level *priceLevel) *treeNode {
+	if n == nil {
+		return &treeNode{level: level, height: 1}
+	}
+	switch {
+	case level.price < n.level.price:
+		n.left = insertNode(n.left, level)
+	case level.price > n.level.price:
+		n.right = insertNode(n.right, level)
+	default:
+		n.level = level
+		return n
+	}
+	return rebalance(n)
+}
+
+func deleteNode(n *treeNode, price data.Money) *treeNode {
+	if n == nil {
+		return nil
+	}
+	switch {
+	case price < n.level.price:
+		n.left = deleteNode(n.left, price)
+	case price > n.level.price:
+		n.right = deleteNode(n.right, price)
+	default:
+		if n.left == nil {
+			return n.right
+		}
+		if n.right == nil {
+			return n.left
+		}
+		// replace with the lowest level of the right subtree
+		successor := n.right
+		for successor.left != nil {
+			successor = successor.left
+		}
+		n.level = successor.level
+		n.right = deleteNode(n.right, successor.level.price)
+	}
+	return rebalance(n)
+}
@@ -0,0 +1,32 @@
+package pricefeed
+
+import (
+	"context"
+	"time"
+
+	"github.com/maxwellkuo47/tradingEngine/internal/data"
+)
+
+// Manual gives every stock the Initial price and then leaves the prices to the trades
+// and to the price adjustment endpoint
+type Manual struct {
+	StockIDs []int64
+	Initial  data.Money
+}
+
+func (m *Manual) Run(ctx context.Context, sink Sink) error {
+	seed(sink, m.StockIDs, m.Initial)
+	<-ctx.Done()
+	return nil
+}
+
+// seed publishes the initial price of the stocks that have no price yet
+func seed(sink Sink, stockIDs []int64, initial data.Money) {
+	now := time.Now()
+	for _, stockID := range stockIDs {
+		if _, ok := sink.Price(stockID); ok {
+			continue
+		}
+		sink.Publish(Tick{StockID: stockID, Price: initial, Time: now})
+	}
+}
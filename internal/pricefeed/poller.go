@@ -0,0 +1,152 @@
+package pricefeed
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Poller reads prices from an exchange. An http or https URL is fetched every Interval,
+// a ws or wss URL is subscribed to and reconnected after Interval when the connection drops.
+// Both send a tick object or an array of them, a tick without a time is stamped when it arrives.
+// The errors of the exchange are passed to OnError and do not stop the poller.
+type Poller struct {
+	URL      string
+	Interval time.Duration
+	Client   *http.Client
+	OnError  func(error)
+}
+
+func (p *Poller) Run(ctx context.Context, sink Sink) error {
+	if p.Interval <= 0 {
+		return fmt.Errorf("poll interval must be positive, got %s", p.Interval)
+	}
+	u, err := url.Parse(p.URL)
+	if err != nil {
+		return fmt.Errorf("poll url %q: %w", p.URL, err)
+	}
+
+	var poll func(context.Context, Sink) error
+	switch u.Scheme {
+	case "http", "https":
+		poll = p.fetch
+	case "ws", "wss":
+		poll = p.stream
+	default:
+		return fmt.Errorf("poll url %q: unsupported scheme, want http, https, ws or wss", p.URL)
+	}
+
+	ticker := time.NewTicker(p.Interval)
+	defer ticker.Stop()
+
+	for {
+		err := poll(ctx, sink)
+		if err != nil && ctx.Err() == nil && p.OnError != nil {
+			p.OnError(err)
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// fetch publishes the prices of one GET request
+func (p *Poller) fetch(ctx context.Context, sink Sink) error {
+	ctx, cancel := context.WithTimeout(ctx, p.Interval)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.URL, nil)
+	if err != nil {
+		return err
+	}
+	client := p.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("poll %s: unexpected status %s", p.URL, res.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(res.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+
+	return publishMessage(sink, body)
+}
+
+// stream publishes the prices of every message of one websocket connection until it drops
+func (p *Poller) stream(ctx context.Context, sink Sink) error {
+	conn, _, err := websocket.DefaultDialer.DialContext(ctx, p.URL, nil)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// unblocks ReadMessage on shutdown
+	stop := context.AfterFunc(ctx, func() {
+		conn.Close()
+	})
+	defer stop()
+
+	for {
+		_, message, err := conn.ReadMessage()
+		if err != nil {
+			return err
+		}
+		err = publishMessage(sink, message)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// publishMessage publishes a tick object or an array of them
+func publishMessage(sink Sink, message []byte) error {
+	var ticks []Tick
+	message = bytes.TrimSpace(message)
+	if len(message) > 0 && message[0] == '[' {
+		err := json.Unmarshal(message, &ticks)
+		if err != nil {
+			return fmt.Errorf("decode ticks: %w", err)
+		}
+	} else {
+		var tick Tick
+		err := json.Unmarshal(message, &tick)
+		if err != nil {
+			return fmt.Errorf("decode tick: %w", err)
+		}
+		ticks = append(ticks, tick)
+	}
+
+	for _, tick := range ticks {
+		err := validTick(tick)
+		if err != nil {
+			return err
+		}
+	}
+
+	now := time.Now()
+	for _, tick := range ticks {
+		if tick.Time.IsZero() {
+			tick.Time = now
+		}
+		sink.Publish(tick)
+	}
+	return nil
+}
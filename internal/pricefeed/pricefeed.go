@@ -0,0 +1,109 @@
+package pricefeed
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/maxwellkuo47/tradingEngine/internal/data"
+)
+
+// Tick is a new price of a stock
+type Tick struct {
+	StockID int64      `json:"stock_id"`
+	Price   data.Money `json:"price"`
+	Time    time.Time  `json:"time"`
+}
+
+// Sink receives the ticks of a price source, Price is the price the sink holds for a stock
+type Sink interface {
+	Price(stockID int64) (data.Money, bool)
+	Publish(tick Tick)
+}
+
+// PriceSource produces stock prices until ctx is done or the source runs out of prices.
+// Run returns nil when ctx is cancelled.
+type PriceSource interface {
+	Run(ctx context.Context, sink Sink) error
+}
+
+// Feed keeps the last price of every stock and passes every tick on to its subscribers
+type Feed struct {
+	mu          sync.RWMutex
+	prices      map[int64]data.Money
+	subscribers map[*Subscription]struct{}
+}
+
+// Subscription receives the ticks published after Subscribe on C until it is closed
+type Subscription struct {
+	C    <-chan Tick
+	c    chan Tick
+	feed *Feed
+}
+
+func NewFeed() *Feed {
+	return &Feed{
+		prices:      make(map[int64]data.Money),
+		subscribers: make(map[*Subscription]struct{}),
+	}
+}
+
+// Price is the last price of a stock, false when it has none yet
+func (f *Feed) Price(stockID int64) (data.Money, bool) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	price, ok := f.prices[stockID]
+	return price, ok
+}
+
+// Prices is a copy of the last price of every stock
+func (f *Feed) Prices() map[int64]data.Money {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	prices := make(map[int64]data.Money, len(f.prices))
+	for stockID, price := range f.prices {
+		prices[stockID] = price
+	}
+	return prices
+}
+
+// Publish sets the price of a stock and hands the tick to every subscriber.
+// A subscriber whose buffer is full misses the tick, a slow reader never holds up the feed.
+func (f *Feed) Publish(tick Tick) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.prices[tick.StockID] = tick.Price
+	for sub := range f.subscribers {
+		select {
+		case sub.c <- tick:
+		default:
+		}
+	}
+}
+
+// Subscribe starts a subscription to the ticks of every stock with room for buffer ticks
+func (f *Feed) Subscribe(buffer int) *Subscription {
+	c := make(chan Tick, buffer)
+	sub := &Subscription{C: c, c: c, feed: f}
+
+	f.mu.Lock()
+	f.subscribers[sub] = struct{}{}
+	f.mu.Unlock()
+
+	return sub
+}
+
+// Close ends the subscription and closes C, closing it again does nothing
+func (s *Subscription) Close() {
+	s.feed.mu.Lock()
+	defer s.feed.mu.Unlock()
+
+	if _, ok := s.feed.subscribers[s]; !ok {
+		return
+	}
+	delete(s.feed.subscribers, s)
+	close(s.c)
+}
@@ -0,0 +1,145 @@
+package pricefeed
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/maxwellkuo47/tradingEngine/internal/data"
+)
+
+// Replay plays back the recorded ticks of a file in order, a .csv file with the columns time,stock_id,price
+// after a header line or a .ndjson/.jsonl file with one tick object per line.
+// The time of a tick is RFC 3339, the gaps between the ticks are replayed Speed times faster,
+// a Speed of 0 publishes the ticks without waiting.
+type Replay struct {
+	Path  string
+	Speed float64
+}
+
+func (r *Replay) Run(ctx context.Context, sink Sink) error {
+	file, err := os.Open(r.Path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var next func() (Tick, error)
+	switch strings.ToLower(filepath.Ext(r.Path)) {
+	case ".csv":
+		next, err = csvTicks(file)
+	case ".ndjson", ".jsonl":
+		next = jsonTicks(file)
+	default:
+		return fmt.Errorf("replay file %s: unknown format, want .csv, .ndjson or .jsonl", r.Path)
+	}
+	if err != nil {
+		return err
+	}
+
+	var previous time.Time
+	for n := 1; ; n++ {
+		tick, err := next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("replay file %s tick %d: %w", r.Path, n, err)
+		}
+
+		if r.Speed > 0 && !previous.IsZero() && tick.Time.After(previous) {
+			timer := time.NewTimer(time.Duration(float64(tick.Time.Sub(previous)) / r.Speed))
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return nil
+			case <-timer.C:
+			}
+		}
+		if ctx.Err() != nil {
+			return nil
+		}
+		previous = tick.Time
+
+		sink.Publish(tick)
+	}
+}
+
+func csvTicks(file io.Reader) (func() (Tick, error), error) {
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = 3
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("read csv header: %w", err)
+	}
+	if header[0] != "time" || header[1] != "stock_id" || header[2] != "price" {
+		return nil, fmt.Errorf("csv header must be time,stock_id,price, got %s", strings.Join(header, ","))
+	}
+
+	return func() (Tick, error) {
+		record, err := reader.Read()
+		if err != nil {
+			return Tick{}, err
+		}
+
+		var tick Tick
+		tick.Time, err = time.Parse(time.RFC3339, record[0])
+		if err != nil {
+			return Tick{}, fmt.Errorf("invalid time %q", record[0])
+		}
+		tick.StockID, err = strconv.ParseInt(record[1], 10, 64)
+		if err != nil {
+			return Tick{}, fmt.Errorf("invalid stock id %q", record[1])
+		}
+		tick.Price, err = data.ParseMoney(record[2])
+		if err != nil {
+			return Tick{}, fmt.Errorf("invalid price %q: %w", record[2], err)
+		}
+		return tick, validTick(tick)
+	}, nil
+}
+
+func jsonTicks(file io.Reader) func() (Tick, error) {
+	scanner := bufio.NewScanner(file)
+
+	return func() (Tick, error) {
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" {
+				continue
+			}
+			var tick Tick
+			err := json.Unmarshal([]byte(line), &tick)
+			if err != nil {
+				return Tick{}, err
+			}
+			return tick, validTick(tick)
+		}
+		if err := scanner.Err(); err != nil {
+			return Tick{}, err
+		}
+		return Tick{}, io.EOF
+	}
+}
+
+// validTick rejects a tick a consumer can not use: no stock, or a price that is not a positive number of ticks
+func validTick(tick Tick) error {
+	if tick.StockID <= 0 {
+		return fmt.Errorf("invalid stock id %d", tick.StockID)
+	}
+	if tick.Price <= 0 || !tick.Price.IsMultipleOf(data.TickSize()) {
+		return fmt.Errorf("price %s must be a positive multiple of the tick size %s", tick.Price, data.TickSize())
+	}
+	return nil
+}
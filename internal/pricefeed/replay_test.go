@@ -0,0 +1,135 @@
+package pricefeed
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/maxwellkuo47/tradingEngine/internal/data"
+)
+
+// writeReplay writes a replay file with the lines and returns its path
+func writeReplay(t *testing.T, name string, lines ...string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0o644); err != nil {
+		t.Fatalf("write %s: %v", name, err)
+	}
+	return path
+}
+
+func TestReplay(t *testing.T) {
+	first := time.Date(2024, 1, 2, 9, 30, 0, 0, time.UTC)
+	want := []Tick{
+		{StockID: 1, Price: data.NewMoney(100), Time: first},
+		{StockID: 2, Price: 1_005_000, Time: first.Add(time.Second)},
+	}
+
+	tests := []struct {
+		name string
+		path string
+	}{
+		{
+			name: "csv",
+			path: writeReplay(t, "ticks.csv",
+				"time,stock_id,price",
+				"2024-01-02T09:30:00Z,1,100",
+				"2024-01-02T09:30:01Z, 2, 100.50",
+			),
+		},
+		{
+			name: "ndjson",
+			path: writeReplay(t, "ticks.ndjson",
+				`{"time":"2024-01-02T09:30:00Z","stock_id":1,"price":100}`,
+				``,
+				`{"time":"2024-01-02T09:30:01Z","stock_id":2,"price":"100.50"}`,
+			),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sink := newRecorder()
+			err := (&Replay{Path: tt.path}).Run(context.Background(), sink)
+			if err != nil {
+				t.Fatalf("Run: %v", err)
+			}
+			if len(sink.ticks) != len(want) {
+				t.Fatalf("replayed %d ticks, want %d", len(sink.ticks), len(want))
+			}
+			for i := range want {
+				got := sink.ticks[i]
+				if got.StockID != want[i].StockID || got.Price != want[i].Price || !got.Time.Equal(want[i].Time) {
+					t.Errorf("tick %d = %+v, want %+v", i, got, want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestReplayRejectsInvalidFiles(t *testing.T) {
+	tests := []struct {
+		name string
+		path string
+		err  string
+	}{
+		{
+			name: "unknown format",
+			path: writeReplay(t, "ticks.txt", "time,stock_id,price"),
+			err:  "unknown format",
+		},
+		{
+			name: "wrong header",
+			path: writeReplay(t, "header.csv", "stock_id,time,price"),
+			err:  "csv header",
+		},
+		{
+			name: "invalid time",
+			path: writeReplay(t, "time.csv", "time,stock_id,price", "yesterday,1,100"),
+			err:  "invalid time",
+		},
+		{
+			name: "price between ticks",
+			path: writeReplay(t, "tick.csv", "time,stock_id,price", "2024-01-02T09:30:00Z,1,100.005"),
+			err:  "multiple of the tick size",
+		},
+		{
+			name: "no stock",
+			path: writeReplay(t, "stock.jsonl", `{"time":"2024-01-02T09:30:00Z","price":100}`),
+			err:  "invalid stock id",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := (&Replay{Path: tt.path}).Run(context.Background(), newRecorder())
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("Run error = %v, want one about %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestReplayWaitsForTheGaps(t *testing.T) {
+	path := writeReplay(t, "gaps.csv",
+		"time,stock_id,price",
+		"2024-01-02T09:30:00Z,1,100",
+		"2024-01-02T09:30:10Z,1,101",
+	)
+
+	// 10 seconds replayed 100 times faster
+	start := time.Now()
+	sink := newRecorder()
+	if err := (&Replay{Path: path, Speed: 100}).Run(context.Background(), sink); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("replay took %s, want at least 100ms", elapsed)
+	}
+	prices := []data.Money{sink.ticks[0].Price, sink.ticks[1].Price}
+	if !reflect.DeepEqual(prices, []data.Money{data.NewMoney(100), data.NewMoney(101)}) {
+		t.Errorf("prices = %v", prices)
+	}
+}
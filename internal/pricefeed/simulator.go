@@ -0,0 +1,110 @@
+package pricefeed
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/maxwellkuo47/tradingEngine/internal/data"
+)
+
+// year is the time unit of the drift and the volatility of the simulator
+const year = 365 * 24 * time.Hour
+
+// Params are the annualized drift and volatility of the geometric Brownian motion of a stock
+type Params struct {
+	Drift      float64
+	Volatility float64
+}
+
+// Simulator moves the price of every stock by a geometric Brownian motion step every Interval.
+// The same Seed, stocks and parameters give the same sequence of moves.
+type Simulator struct {
+	StockIDs []int64
+	Initial  data.Money
+	Interval time.Duration
+	Seed     int64
+	Default  Params
+	Stocks   map[int64]Params // overrides Default per stock
+}
+
+func (s *Simulator) Run(ctx context.Context, sink Sink) error {
+	if s.Interval <= 0 {
+		return fmt.Errorf("simulator interval must be positive, got %s", s.Interval)
+	}
+	seed(sink, s.StockIDs, s.Initial)
+
+	rng := rand.New(rand.NewSource(s.Seed))
+	dt := s.Interval.Seconds() / year.Seconds()
+	ticker := time.NewTicker(s.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case now := <-ticker.C:
+			for _, stockID := range s.StockIDs {
+				// drawn for every stock on every step so a stock without a price does not shift the others
+				z := rng.NormFloat64()
+				price, ok := sink.Price(stockID)
+				if !ok || price <= 0 {
+					continue
+				}
+				sink.Publish(Tick{StockID: stockID, Price: s.step(stockID, price, dt, z), Time: now})
+			}
+		}
+	}
+}
+
+// step is the next price of a stock, rounded to the tick size and never below one tick.
+// The move is computed in float64, the rounding to a whole number of ticks makes the result exact again.
+func (s *Simulator) step(stockID int64, price data.Money, dt, z float64) data.Money {
+	params, ok := s.Stocks[stockID]
+	if !ok {
+		params = s.Default
+	}
+
+	factor := math.Exp((params.Drift-params.Volatility*params.Volatility/2)*dt + params.Volatility*math.Sqrt(dt)*z)
+	tick := data.TickSize()
+	ticks := math.Round(float64(price) * factor / float64(tick))
+	if ticks < 1 {
+		ticks = 1
+	}
+	return tick.Mul(int(ticks))
+}
+
+// ParseParams reads per stock parameters written as stock_id:drift:volatility separated by commas,
+// e.g. "1:0.05:0.2,2:-0.1:0.6"
+func ParseParams(s string) (map[int64]Params, error) {
+	params := make(map[int64]Params)
+	if strings.TrimSpace(s) == "" {
+		return params, nil
+	}
+
+	for _, item := range strings.Split(s, ",") {
+		fields := strings.Split(strings.TrimSpace(item), ":")
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("stock parameters %q: want stock_id:drift:volatility", item)
+		}
+		stockID, err := strconv.ParseInt(fields[0], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("stock parameters %q: invalid stock id", item)
+		}
+		drift, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil {
+			return nil, fmt.Errorf("stock parameters %q: invalid drift", item)
+		}
+		volatility, err := strconv.ParseFloat(fields[2], 64)
+		if err != nil || volatility < 0 {
+			return nil, fmt.Errorf("stock parameters %q: invalid volatility", item)
+		}
+		params[stockID] = Params{Drift: drift, Volatility: volatility}
+	}
+
+	return params, nil
+}
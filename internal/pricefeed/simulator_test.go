@@ -0,0 +1,134 @@
+package pricefeed
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/maxwellkuo47/tradingEngine/internal/data"
+)
+
+// recorder is a sink that keeps every tick published to it
+type recorder struct {
+	mu     sync.Mutex
+	prices map[int64]data.Money
+	ticks  []Tick
+}
+
+func newRecorder() *recorder {
+	return &recorder{prices: make(map[int64]data.Money)}
+}
+
+func (r *recorder) Price(stockID int64) (data.Money, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	price, ok := r.prices[stockID]
+	return price, ok
+}
+
+func (r *recorder) Publish(tick Tick) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.prices[tick.StockID] = tick.Price
+	r.ticks = append(r.ticks, tick)
+}
+
+func (r *recorder) len() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.ticks)
+}
+
+// simulate runs the simulator until it published count ticks and returns the prices of the ticks
+func simulate(t *testing.T, s *Simulator, count int) []data.Money {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	sink := newRecorder()
+	done := make(chan error, 1)
+	go func() { done <- s.Run(ctx, sink) }()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for sink.len() < count && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if sink.len() < count {
+		t.Fatalf("simulator published %d ticks, want %d", sink.len(), count)
+	}
+
+	prices := make([]data.Money, count)
+	for i, tick := range sink.ticks[:count] {
+		prices[i] = tick.Price
+	}
+	return prices
+}
+
+func TestSimulatorIsDeterministic(t *testing.T) {
+	newSimulator := func(seed int64) *Simulator {
+		return &Simulator{
+			StockIDs: []int64{1, 2},
+			Initial:  data.NewMoney(100),
+			Interval: time.Millisecond,
+			Seed:     seed,
+			// a step of a millisecond is a tiny part of a year, only a huge volatility moves the price by ticks
+			Default: Params{Drift: 0.05, Volatility: 500},
+			Stocks:  map[int64]Params{2: {Drift: -0.1, Volatility: 1000}},
+		}
+	}
+
+	first := simulate(t, newSimulator(42), 40)
+	second := simulate(t, newSimulator(42), 40)
+	if !reflect.DeepEqual(first, second) {
+		t.Errorf("runs with the same seed differ:\n%v\n%v", first, second)
+	}
+	other := simulate(t, newSimulator(7), 40)
+	if reflect.DeepEqual(first, other) {
+		t.Error("runs with different seeds are the same")
+	}
+
+	moved := false
+	for i, price := range first {
+		if price <= 0 || !price.IsMultipleOf(data.TickSize()) {
+			t.Errorf("tick %d price %s is not a positive multiple of the tick size", i, price)
+		}
+		moved = moved || price != data.NewMoney(100)
+	}
+	if !moved {
+		t.Error("the simulator never moved the price")
+	}
+}
+
+func TestSimulatorStepStaysOnTheTick(t *testing.T) {
+	s := &Simulator{Default: Params{Drift: 0, Volatility: 0}}
+	if got := s.step(1, data.NewMoney(100), 1, 3); got != data.NewMoney(100) {
+		t.Errorf("step without drift and volatility = %s, want 100", got)
+	}
+
+	// a crash far below one tick is held at one tick
+	s = &Simulator{Default: Params{Volatility: 5}}
+	if got := s.step(1, data.TickSize(), 1, -10); got != data.TickSize() {
+		t.Errorf("step = %s, want the tick size %s", got, data.TickSize())
+	}
+}
+
+func TestParseParams(t *testing.T) {
+	got, err := ParseParams("1:0.05:0.2, 2:-0.1:0.6")
+	if err != nil {
+		t.Fatalf("ParseParams: %v", err)
+	}
+	want := map[int64]Params{1: {Drift: 0.05, Volatility: 0.2}, 2: {Drift: -0.1, Volatility: 0.6}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseParams = %v, want %v", got, want)
+	}
+
+	for _, text := range []string{"1:0.05", "x:0.05:0.2", "1:x:0.2", "1:0.05:-0.2"} {
+		if _, err := ParseParams(text); err == nil {
+			t.Errorf("ParseParams(%q) accepted invalid parameters", text)
+		}
+	}
+}
@@ -0,0 +1,206 @@
+package reconcile
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/maxwellkuo47/tradingEngine/internal/data"
+	"github.com/maxwellkuo47/tradingEngine/internal/orderbook"
+)
+
+// Kinds of discrepancy between the orders table and the order book
+const (
+	KindMissing     = "missing"     // open in postgres but not in the book
+	KindOrphaned    = "orphaned"    // in the book but not open in postgres
+	KindQuantity    = "quantity"    // in the book with a different open quantity
+	KindDuplicated  = "duplicated"  // in the book more than once
+	KindStaleLevel  = "stale_level" // price level out of sync with the price index
+	KindDeadLetters = "dead_letter" // matches that could not be settled
+)
+
+type Discrepancy struct {
+	Kind     string `json:"kind"`
+	OrderID  int64  `json:"order_id,omitempty"`
+	Expected int    `json:"expected"`
+	Actual   int    `json:"actual"`
+	Detail   string `json:"detail,omitempty"`
+}
+
+// Report is the result of reconciling one stock
+type Report struct {
+	StockID       int64         `json:"stock_id"`
+	OpenOrders    int           `json:"open_orders"`
+	BookOrders    int           `json:"book_orders"`
+	Discrepancies []Discrepancy `json:"discrepancies"`
+	Repaired      bool          `json:"repaired"`
+}
+
+// OpenOrders reads the orders of a stock that rest in the book, in the order they last reached it, like data.OrderModel
+type OpenOrders interface {
+	GetOpenForStock(stockID int64) ([]*data.Order, error)
+}
+
+// Reconciler compares the open orders in postgres with the order book.
+// Postgres is the source of truth: an order belongs in the book with its remaining quantity
+// less what was matched already but is still waiting in the settlement log.
+type Reconciler struct {
+	Orders OpenOrders
+	Book   orderbook.OrderBook
+}
+
+func New(models data.DBModels, book orderbook.OrderBook) *Reconciler {
+	return &Reconciler{Orders: models.Order, Book: book}
+}
+
+// Stock diffs one stock and, with repair set, makes the book match postgres.
+// Missing orders are added in queued_at order so an empty book is rebuilt with the time priority it had,
+// an order whose quantity is off is re-queued and loses its place in the queue.
+// Repairing a book that the matcher is working on can race with it, so repair before the matchers start.
+func (r *Reconciler) Stock(ctx context.Context, stockID int64, repair bool) (*Report, error) {
+	report := &Report{StockID: stockID, Discrepancies: []Discrepancy{}, Repaired: repair}
+
+	// stale levels go first, they would otherwise hide orders or bring old ones back when an order is added
+	if finder, ok := r.Book.(orderbook.StaleLevelFinder); ok {
+		stale, err := finder.StaleLevels(ctx, stockID, repair)
+		if err != nil {
+			return nil, err
+		}
+		for _, detail := range stale {
+			report.add(Discrepancy{Kind: KindStaleLevel, Detail: detail})
+		}
+	}
+
+	openOrders, err := r.Orders.GetOpenForStock(stockID)
+	if err != nil {
+		return nil, err
+	}
+	settlements, err := r.Book.Settlements(ctx, stockID)
+	if err != nil {
+		return nil, err
+	}
+	snapshot, err := r.Book.Snapshot(ctx, stockID)
+	if err != nil {
+		return nil, err
+	}
+	report.OpenOrders = len(openOrders)
+
+	expected := make(map[int64]orderbook.Order, len(openOrders))
+	for _, order := range openOrders {
+		expected[order.ID] = bookOrder(order)
+	}
+	// fills that left the book but are not in postgres yet
+	for _, match := range settlements {
+		for _, matched := range []orderbook.Order{match.Buy, match.Sell} {
+			order, ok := expected[matched.OrderID]
+			if !ok || matched.Filled < order.Filled {
+				continue
+			}
+			if match.SelfTrade != nil {
+				// taken out of the book by self-trade prevention, the order record is cut down when it is settled
+				order.SetOpen(order.Open() - match.SelfTrade.Removed(matched.Side))
+				expected[matched.OrderID] = order
+				continue
+			}
+			order.SetOpen(order.Open() - match.Quantity)
+			order.Filled += match.Quantity
+			expected[matched.OrderID] = order
+		}
+	}
+
+	actual := make(map[int64][]orderbook.Order)
+	bookOrderIDs := []int64{}
+	for _, order := range append(snapshot.Bids, snapshot.Asks...) {
+		if _, ok := actual[order.OrderID]; !ok {
+			bookOrderIDs = append(bookOrderIDs, order.OrderID)
+		}
+		actual[order.OrderID] = append(actual[order.OrderID], order)
+	}
+	report.BookOrders = len(snapshot.Bids) + len(snapshot.Asks)
+
+	for _, orderID := range bookOrderIDs {
+		queued := actual[orderID]
+		want, ok := expected[orderID]
+		switch {
+		case !ok || want.Open() <= 0:
+			report.add(Discrepancy{Kind: KindOrphaned, OrderID: orderID, Expected: max(want.Open(), 0), Actual: queuedQuantity(queued)})
+		case len(queued) > 1:
+			report.add(Discrepancy{Kind: KindDuplicated, OrderID: orderID, Expected: want.Open(), Actual: queuedQuantity(queued)})
+		case queued[0].Open() != want.Open():
+			report.add(Discrepancy{Kind: KindQuantity, OrderID: orderID, Expected: want.Open(), Actual: queued[0].Open()})
+		default:
+			continue
+		}
+		if repair {
+			for _, order := range queued {
+				_, err := r.Book.Cancel(ctx, stockID, order.Side, order.Price, order.OrderID)
+				if err != nil && !errors.Is(err, orderbook.ErrOrderNotFound) {
+					return nil, err
+				}
+			}
+		}
+	}
+
+	for _, order := range openOrders {
+		want := expected[order.ID]
+		queued, inBook := actual[order.ID]
+		if want.Open() <= 0 {
+			continue
+		}
+		if !inBook {
+			report.add(Discrepancy{Kind: KindMissing, OrderID: order.ID, Expected: want.Open()})
+		} else if len(queued) == 1 && queued[0].Open() == want.Open() {
+			continue
+		}
+		// taken out above or never queued
+		if repair {
+			err := r.Book.Add(ctx, want)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	deadLetters, err := r.Book.DeadLetters(ctx, stockID)
+	if err != nil {
+		return nil, err
+	}
+	if len(deadLetters) > 0 {
+		report.add(Discrepancy{
+			Kind:   KindDeadLetters,
+			Actual: len(deadLetters),
+			Detail: fmt.Sprintf("%d matches need manual settlement", len(deadLetters)),
+		})
+	}
+
+	return report, nil
+}
+
+func (report *Report) add(discrepancy Discrepancy) {
+	report.Discrepancies = append(report.Discrepancies, discrepancy)
+}
+
+// bookOrder is the open part of an order record as it rests in the book
+func bookOrder(order *data.Order) orderbook.Order {
+	queued := orderbook.Order{
+		OrderID:    order.ID,
+		UserID:     order.UserID,
+		StockID:    order.StockID,
+		Side:       orderbook.Side(order.Type),
+		Price:      order.Price,
+		Filled:     order.FilledQuantity,
+		Display:    order.DisplayQuantity,
+		STP:        order.STPMode,
+		CreateTime: order.QueuedAt,
+	}
+	queued.SetOpen(order.RemainingQuantity())
+	return queued
+}
+
+func queuedQuantity(orders []orderbook.Order) int {
+	quantity := 0
+	for _, order := range orders {
+		quantity += order.Open()
+	}
+	return quantity
+}
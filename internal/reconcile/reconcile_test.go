@@ -0,0 +1,174 @@
+package reconcile
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/maxwellkuo47/tradingEngine/internal/data"
+	"github.com/maxwellkuo47/tradingEngine/internal/orderbook"
+)
+
+const testStockID = 1
+
+// openOrders stands in for the orders table
+type openOrders []*data.Order
+
+func (o openOrders) GetOpenForStock(stockID int64) ([]*data.Order, error) {
+	return o, nil
+}
+
+var queuedAt = time.Date(2024, 1, 2, 9, 30, 0, 0, time.UTC)
+
+// order is an open order record queued seconds after queuedAt
+func order(id int64, orderType int, price int64, quantity, filled, seconds int) *data.Order {
+	return &data.Order{
+		ID:             id,
+		UserID:         id,
+		StockID:        testStockID,
+		Type:           orderType,
+		Price:          data.NewMoney(price),
+		Quantity:       quantity,
+		FilledQuantity: filled,
+		QueuedAt:       queuedAt.Add(time.Duration(seconds) * time.Second),
+	}
+}
+
+// kinds lists the kinds of the discrepancies with their order ids
+func kinds(report *Report) []string {
+	got := []string{}
+	for _, discrepancy := range report.Discrepancies {
+		got = append(got, discrepancy.Kind)
+	}
+	return got
+}
+
+// resting lists the order ids and open quantities of one side of the book, best first
+func resting(t *testing.T, book orderbook.OrderBook, side orderbook.Side) [][2]int64 {
+	t.Helper()
+	snapshot, err := book.Snapshot(context.Background(), testStockID)
+	if err != nil {
+		t.Fatalf("Snapshot: %v", err)
+	}
+	orders := snapshot.Bids
+	if side == orderbook.Sell {
+		orders = snapshot.Asks
+	}
+	got := [][2]int64{}
+	for _, order := range orders {
+		got = append(got, [2]int64{order.OrderID, int64(order.Open())})
+	}
+	return got
+}
+
+func TestRebuildEmptyBook(t *testing.T) {
+	ctx := context.Background()
+	book := orderbook.NewMemory()
+	reconciler := &Reconciler{
+		Orders: openOrders{
+			order(2, data.ORDER_TYPE_BUY, 10, 5, 0, 0),
+			order(1, data.ORDER_TYPE_BUY, 10, 4, 1, 1),
+			order(3, data.ORDER_TYPE_SELL, 11, 2, 0, 2),
+		},
+		Book: book,
+	}
+
+	report, err := reconciler.Stock(ctx, testStockID, true)
+	if err != nil {
+		t.Fatalf("Stock: %v", err)
+	}
+	if got := kinds(report); !reflect.DeepEqual(got, []string{KindMissing, KindMissing, KindMissing}) {
+		t.Errorf("discrepancies = %v, want every order missing", got)
+	}
+
+	// the orders are queued in the order they last reached the book, not by id
+	if got := resting(t, book, orderbook.Buy); !reflect.DeepEqual(got, [][2]int64{{2, 5}, {1, 3}}) {
+		t.Errorf("bids = %v, want order 2 ahead of order 1 with its remaining quantity", got)
+	}
+	if got := resting(t, book, orderbook.Sell); !reflect.DeepEqual(got, [][2]int64{{3, 2}}) {
+		t.Errorf("asks = %v", got)
+	}
+
+	report, err = reconciler.Stock(ctx, testStockID, false)
+	if err != nil {
+		t.Fatalf("Stock: %v", err)
+	}
+	if len(report.Discrepancies) != 0 {
+		t.Errorf("discrepancies after the repair = %+v", report.Discrepancies)
+	}
+}
+
+func TestRepairOrphanedAndQuantity(t *testing.T) {
+	ctx := context.Background()
+	book := orderbook.NewMemory()
+	for _, queued := range []orderbook.Order{
+		{OrderID: 1, UserID: 1, StockID: testStockID, Side: orderbook.Buy, Price: data.NewMoney(10), Quantity: 3},
+		{OrderID: 9, UserID: 9, StockID: testStockID, Side: orderbook.Buy, Price: data.NewMoney(9), Quantity: 1},
+	} {
+		if err := book.Add(ctx, queued); err != nil {
+			t.Fatalf("Add(%d): %v", queued.OrderID, err)
+		}
+	}
+	reconciler := &Reconciler{Orders: openOrders{order(1, data.ORDER_TYPE_BUY, 10, 5, 0, 0)}, Book: book}
+
+	report, err := reconciler.Stock(ctx, testStockID, false)
+	if err != nil {
+		t.Fatalf("Stock: %v", err)
+	}
+	if got := kinds(report); !reflect.DeepEqual(got, []string{KindQuantity, KindOrphaned}) {
+		t.Errorf("discrepancies = %v", got)
+	}
+	if got := resting(t, book, orderbook.Buy); len(got) != 2 {
+		t.Errorf("bids = %v, a report without repair changed the book", got)
+	}
+
+	if _, err := reconciler.Stock(ctx, testStockID, true); err != nil {
+		t.Fatalf("Stock: %v", err)
+	}
+	if got := resting(t, book, orderbook.Buy); !reflect.DeepEqual(got, [][2]int64{{1, 5}}) {
+		t.Errorf("bids = %v, want only order 1 with its full quantity", got)
+	}
+}
+
+func TestUnsettledMatchesAreNotMissing(t *testing.T) {
+	ctx := context.Background()
+	book := orderbook.NewMemory()
+	for _, queued := range []orderbook.Order{
+		{OrderID: 1, UserID: 1, StockID: testStockID, Side: orderbook.Buy, Price: data.NewMoney(10), Quantity: 5},
+		{OrderID: 2, UserID: 2, StockID: testStockID, Side: orderbook.Sell, Price: data.NewMoney(10), Quantity: 2},
+	} {
+		if err := book.Add(ctx, queued); err != nil {
+			t.Fatalf("Add(%d): %v", queued.OrderID, err)
+		}
+	}
+	// matched in the book, the fills are not in postgres yet
+	match, err := book.PopMatch(ctx, testStockID)
+	if err != nil || match == nil {
+		t.Fatalf("PopMatch = %v, %v", match, err)
+	}
+	if err := book.DeadLetter(ctx, testStockID, orderbook.Match{ID: "0"}, "settlement failed"); err != nil {
+		t.Fatalf("DeadLetter: %v", err)
+	}
+
+	reconciler := &Reconciler{
+		Orders: openOrders{
+			order(1, data.ORDER_TYPE_BUY, 10, 5, 0, 0),
+			order(2, data.ORDER_TYPE_SELL, 10, 2, 0, 1),
+		},
+		Book: book,
+	}
+	report, err := reconciler.Stock(ctx, testStockID, true)
+	if err != nil {
+		t.Fatalf("Stock: %v", err)
+	}
+	if got := kinds(report); !reflect.DeepEqual(got, []string{KindDeadLetters}) {
+		t.Errorf("discrepancies = %v, want only the dead letter", got)
+	}
+	if got := resting(t, book, orderbook.Buy); !reflect.DeepEqual(got, [][2]int64{{1, 3}}) {
+		t.Errorf("bids = %v, want the rest of order 1", got)
+	}
+	if got := resting(t, book, orderbook.Sell); len(got) != 0 {
+		t.Errorf("asks = %v, the matched sell order was queued again", got)
+	}
+}
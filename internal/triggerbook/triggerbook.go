@@ -0,0 +1,223 @@
+package triggerbook
+
+import (
+	"cmp"
+	"errors"
+	"slices"
+	"sync"
+
+	"github.com/maxwellkuo47/tradingEngine/internal/data"
+)
+
+// Direction of the price move that fires a trigger, the values are the same as data.TRIGGER_DIRECTION_*
+type Direction int
+
+const (
+	Rise Direction = data.TRIGGER_DIRECTION_RISE
+	Fall Direction = data.TRIGGER_DIRECTION_FALL
+)
+
+var ErrInvalidDirection = errors.New("invalid trigger direction")
+
+// Trigger is a dormant stop order waiting for the stock price to reach Price
+type Trigger struct {
+	OrderID   int64
+	StockID   int64
+	Price     data.Money
+	Direction Direction
+
+	// a trailing trigger keeps TrailAmount, or TrailBps basis points of Mark, between its price and Mark,
+	// the highest price seen since the order was placed for Fall and the lowest for Rise
+	TrailAmount data.Money
+	TrailBps    int
+	Mark        data.Money
+}
+
+// IsTrailing reports whether the trigger price follows the stock price
+func (t Trigger) IsTrailing() bool {
+	return t.TrailAmount != 0 || t.TrailBps != 0
+}
+
+// TrailPrice is the trigger price one trail away from mark, below it for Fall and above it for Rise.
+// A trail in basis points is rounded to the tick size towards mark so the trail is never exceeded.
+func TrailPrice(direction Direction, mark, amount data.Money, bps int) data.Money {
+	tick := data.TickSize()
+	switch direction {
+	case Fall:
+		if amount != 0 {
+			return mark - amount
+		}
+		price := mark * data.Money(10_000-bps) / 10_000
+		if price%tick != 0 {
+			price += tick - price%tick
+		}
+		return price
+	case Rise:
+		if amount != 0 {
+			return mark + amount
+		}
+		price := mark * data.Money(10_000+bps) / 10_000
+		return price - price%tick
+	default:
+		return mark
+	}
+}
+
+// Book keeps the triggers of every stock sorted by how close they are to firing,
+// so a price update only looks at the triggers it actually crosses
+type Book struct {
+	mu     sync.Mutex
+	stocks map[int64]*stockTriggers
+}
+
+type stockTriggers struct {
+	rise []Trigger // ascending trigger price
+	fall []Trigger // descending trigger price
+}
+
+func New() *Book {
+	return &Book{stocks: make(map[int64]*stockTriggers)}
+}
+
+// stock returns the triggers of a stock, creating them on first use. The caller must hold b.mu.
+func (b *Book) stock(stockID int64) *stockTriggers {
+	s, ok := b.stocks[stockID]
+	if !ok {
+		s = &stockTriggers{}
+		b.stocks[stockID] = s
+	}
+	return s
+}
+
+// compare orders triggers by the price move they need, ties fire in order id order
+func compare(direction Direction) func(a, b Trigger) int {
+	return func(a, b Trigger) int {
+		byPrice := cmp.Compare(a.Price, b.Price)
+		if direction == Fall {
+			byPrice = -byPrice
+		}
+		if byPrice != 0 {
+			return byPrice
+		}
+		return cmp.Compare(a.OrderID, b.OrderID)
+	}
+}
+
+func (s *stockTriggers) side(direction Direction) (*[]Trigger, error) {
+	switch direction {
+	case Rise:
+		return &s.rise, nil
+	case Fall:
+		return &s.fall, nil
+	default:
+		return nil, ErrInvalidDirection
+	}
+}
+
+// Add puts a trigger into the book, a trigger of the same order is replaced
+func (b *Book) Add(trigger Trigger) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	s := b.stock(trigger.StockID)
+	side, err := s.side(trigger.Direction)
+	if err != nil {
+		return err
+	}
+	s.remove(trigger.OrderID)
+
+	i, _ := slices.BinarySearchFunc(*side, trigger, compare(trigger.Direction))
+	*side = slices.Insert(*side, i, trigger)
+	return nil
+}
+
+// Remove takes the trigger of an order out of the book, it returns false when the order has no trigger,
+// e.g. because it fired already
+func (b *Book) Remove(stockID, orderID int64) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.stock(stockID).remove(orderID)
+}
+
+func (s *stockTriggers) remove(orderID int64) bool {
+	for _, side := range []*[]Trigger{&s.rise, &s.fall} {
+		i := slices.IndexFunc(*side, func(t Trigger) bool { return t.OrderID == orderID })
+		if i >= 0 {
+			*side = slices.Delete(*side, i, i+1)
+			return true
+		}
+	}
+	return false
+}
+
+// Trail moves the marks of the trailing triggers of a stock to price when it is a new high for Fall or a new low for Rise,
+// their trigger prices follow the marks. It returns the triggers whose price moved.
+func (b *Book) Trail(stockID int64, price data.Money) []Trigger {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	s := b.stock(stockID)
+	moved := []Trigger{}
+	for _, direction := range []Direction{Rise, Fall} {
+		side, _ := s.side(direction)
+		changed := false
+		for i := range *side {
+			t := &(*side)[i]
+			if !t.IsTrailing() {
+				continue
+			}
+			if direction == Fall && price <= t.Mark || direction == Rise && price >= t.Mark {
+				continue
+			}
+			t.Mark = price
+			trailed := TrailPrice(direction, t.Mark, t.TrailAmount, t.TrailBps)
+			// the trigger price only ever moves towards the price
+			if direction == Fall && trailed <= t.Price || direction == Rise && trailed >= t.Price {
+				continue
+			}
+			t.Price = trailed
+			moved = append(moved, *t)
+			changed = true
+		}
+		if changed {
+			slices.SortFunc(*side, compare(direction))
+		}
+	}
+	return moved
+}
+
+// Fire takes every trigger that the stock price has reached out of the book and returns them,
+// the ones closest to the previous price first
+func (b *Book) Fire(stockID int64, price data.Money) []Trigger {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	s := b.stock(stockID)
+	fired := []Trigger{}
+
+	n := 0
+	for n < len(s.rise) && s.rise[n].Price <= price {
+		n++
+	}
+	fired = append(fired, s.rise[:n]...)
+	s.rise = slices.Delete(s.rise, 0, n)
+
+	n = 0
+	for n < len(s.fall) && s.fall[n].Price >= price {
+		n++
+	}
+	fired = append(fired, s.fall[:n]...)
+	s.fall = slices.Delete(s.fall, 0, n)
+
+	return fired
+}
+
+// Len returns the number of triggers waiting for a stock
+func (b *Book) Len(stockID int64) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	s := b.stock(stockID)
+	return len(s.rise) + len(s.fall)
+}
@@ -0,0 +1,196 @@
+package triggerbook
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/maxwellkuo47/tradingEngine/internal/data"
+)
+
+const testStockID = 1
+
+func trigger(orderID int64, price int64, direction Direction) Trigger {
+	return Trigger{OrderID: orderID, StockID: testStockID, Price: data.NewMoney(price), Direction: direction}
+}
+
+func newTestBook(t *testing.T, triggers []Trigger) *Book {
+	t.Helper()
+	book := New()
+	for _, trigger := range triggers {
+		if err := book.Add(trigger); err != nil {
+			t.Fatalf("Add(%d): %v", trigger.OrderID, err)
+		}
+	}
+	return book
+}
+
+// orderIDs lists the orders of the triggers in their order
+func orderIDs(triggers []Trigger) []int64 {
+	ids := []int64{}
+	for _, trigger := range triggers {
+		ids = append(ids, trigger.OrderID)
+	}
+	return ids
+}
+
+func TestBookFire(t *testing.T) {
+	triggers := []Trigger{
+		trigger(1, 105, Rise),
+		trigger(2, 102, Rise),
+		trigger(3, 102, Rise),
+		trigger(4, 110, Rise),
+		trigger(5, 95, Fall),
+		trigger(6, 98, Fall),
+		trigger(7, 90, Fall),
+	}
+
+	tests := []struct {
+		name  string
+		price int64
+		fired []int64
+		left  int
+	}{
+		{name: "between the triggers", price: 100, fired: []int64{}, left: 7},
+		{name: "on a rise price", price: 102, fired: []int64{2, 3}, left: 5},
+		{name: "past rise prices", price: 106, fired: []int64{2, 3, 1}, left: 4},
+		{name: "past fall prices", price: 94, fired: []int64{6, 5}, left: 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			book := newTestBook(t, triggers)
+			fired := book.Fire(testStockID, data.NewMoney(tt.price))
+			if got := orderIDs(fired); !reflect.DeepEqual(got, tt.fired) {
+				t.Errorf("fired = %v, want %v", got, tt.fired)
+			}
+			if got := book.Len(testStockID); got != tt.left {
+				t.Errorf("%d triggers left, want %d", got, tt.left)
+			}
+		})
+	}
+}
+
+func TestBookFiresOnce(t *testing.T) {
+	book := newTestBook(t, []Trigger{trigger(1, 105, Rise)})
+	if fired := book.Fire(testStockID, data.NewMoney(105)); len(fired) != 1 {
+		t.Fatalf("fired %d triggers, want 1", len(fired))
+	}
+	if fired := book.Fire(testStockID, data.NewMoney(106)); len(fired) != 0 {
+		t.Errorf("fired %v again", orderIDs(fired))
+	}
+	if book.Remove(testStockID, 1) {
+		t.Error("Remove found a trigger that fired already")
+	}
+}
+
+func TestBookAddReplacesAndRemoves(t *testing.T) {
+	book := newTestBook(t, []Trigger{trigger(1, 105, Rise), trigger(2, 95, Fall)})
+
+	// the order moved its trigger to the other side
+	if err := book.Add(trigger(1, 90, Fall)); err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	if got := book.Len(testStockID); got != 2 {
+		t.Errorf("%d triggers, want the replaced one counted once", got)
+	}
+	if fired := book.Fire(testStockID, data.NewMoney(110)); len(fired) != 0 {
+		t.Errorf("fired %v on a rise, want none", orderIDs(fired))
+	}
+
+	if !book.Remove(testStockID, 2) {
+		t.Error("Remove did not find the trigger of order 2")
+	}
+	if fired := book.Fire(testStockID, data.NewMoney(80)); !reflect.DeepEqual(orderIDs(fired), []int64{1}) {
+		t.Errorf("fired %v, want [1]", orderIDs(fired))
+	}
+
+	if err := book.Add(Trigger{OrderID: 3, StockID: testStockID, Direction: 0}); !errors.Is(err, ErrInvalidDirection) {
+		t.Errorf("Add without a direction = %v, want %v", err, ErrInvalidDirection)
+	}
+}
+
+func TestTrailPrice(t *testing.T) {
+	tests := []struct {
+		name      string
+		direction Direction
+		mark      string
+		amount    string
+		bps       int
+		want      string
+	}{
+		{name: "fall by amount", direction: Fall, mark: "100", amount: "5", want: "95"},
+		{name: "rise by amount", direction: Rise, mark: "100", amount: "5", want: "105"},
+		{name: "fall by bps rounds up to the tick", direction: Fall, mark: "101", bps: 250, want: "98.48"},
+		{name: "rise by bps rounds down to the tick", direction: Rise, mark: "101", bps: 250, want: "103.52"},
+		{name: "on the tick", direction: Fall, mark: "100", bps: 250, want: "97.5"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mark, _ := data.ParseMoney(tt.mark)
+			var amount data.Money
+			if tt.amount != "" {
+				amount, _ = data.ParseMoney(tt.amount)
+			}
+			if got := TrailPrice(tt.direction, mark, amount, tt.bps); got.String() != tt.want {
+				t.Errorf("TrailPrice = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+// trailing is a trailing trigger one amount away from mark
+func trailing(orderID int64, mark, amount int64, direction Direction) Trigger {
+	t := Trigger{OrderID: orderID, StockID: testStockID, Direction: direction, TrailAmount: data.NewMoney(amount), Mark: data.NewMoney(mark)}
+	t.Price = TrailPrice(direction, t.Mark, t.TrailAmount, 0)
+	return t
+}
+
+func TestBookTrail(t *testing.T) {
+	book := newTestBook(t, []Trigger{
+		trailing(1, 100, 5, Fall),
+		trailing(2, 100, 5, Rise),
+		trigger(3, 90, Fall),
+	})
+
+	// a new high moves the fall trigger up, the rise trigger and the plain trigger stay
+	moved := book.Trail(testStockID, data.NewMoney(103))
+	if got := orderIDs(moved); !reflect.DeepEqual(got, []int64{1}) {
+		t.Fatalf("moved = %v, want [1]", got)
+	}
+	if moved[0].Price != data.NewMoney(98) || moved[0].Mark != data.NewMoney(103) {
+		t.Errorf("trailed trigger = %+v, want price 98 and mark 103", moved[0])
+	}
+
+	// a pull back does not move the trigger back down
+	if moved := book.Trail(testStockID, data.NewMoney(101)); len(moved) != 0 {
+		t.Errorf("moved %v on a pull back", orderIDs(moved))
+	}
+	if fired := book.Fire(testStockID, data.NewMoney(99)); len(fired) != 0 {
+		t.Errorf("fired %v above the trailed price", orderIDs(fired))
+	}
+
+	// a new low moves the rise trigger down
+	moved = book.Trail(testStockID, data.NewMoney(96))
+	if got := orderIDs(moved); !reflect.DeepEqual(got, []int64{2}) || moved[0].Price != data.NewMoney(101) {
+		t.Errorf("moved = %+v, want order 2 at 101", moved)
+	}
+
+	if fired := book.Fire(testStockID, data.NewMoney(98)); !reflect.DeepEqual(orderIDs(fired), []int64{1}) {
+		t.Errorf("fired %v, want [1]", orderIDs(fired))
+	}
+}
+
+func TestBookTrailKeepsFiringOrder(t *testing.T) {
+	// the trailing trigger starts further from the price than the plain one
+	book := newTestBook(t, []Trigger{
+		trigger(1, 96, Fall),
+		trailing(2, 100, 5, Fall),
+	})
+
+	// and passes it as the price rises
+	book.Trail(testStockID, data.NewMoney(103))
+	fired := book.Fire(testStockID, data.NewMoney(95))
+	if got := orderIDs(fired); !reflect.DeepEqual(got, []int64{2, 1}) {
+		t.Errorf("fired = %v, want the trailed trigger first", got)
+	}
+}
@@ -0,0 +1,3 @@
+ALTER TABLE "orders" DROP COLUMN IF EXISTS "filled_quantity";
+
+COMMENT ON COLUMN "orders"."status" IS '-1: killed 0: pending 1: filled';
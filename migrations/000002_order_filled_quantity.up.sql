@@ -0,0 +1,3 @@
+ALTER TABLE "orders" ADD COLUMN "filled_quantity" integer NOT NULL DEFAULT 0;
+
+COMMENT ON COLUMN "orders"."status" IS '-1: killed 0: pending 1: filled 2: partially filled';
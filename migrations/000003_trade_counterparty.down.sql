@@ -0,0 +1,2 @@
+ALTER TABLE "trades" DROP COLUMN IF EXISTS "sell_order_id";
+ALTER TABLE "trades" DROP COLUMN IF EXISTS "buy_order_id";
@@ -0,0 +1,10 @@
+ALTER TABLE "trades" ADD COLUMN "buy_order_id" bigint;
+ALTER TABLE "trades" ADD COLUMN "sell_order_id" bigint;
+
+ALTER TABLE "trades" ADD FOREIGN KEY ("buy_order_id") REFERENCES "orders" ("id");
+ALTER TABLE "trades" ADD FOREIGN KEY ("sell_order_id") REFERENCES "orders" ("id");
+
+CREATE INDEX ON "trades" ("buy_order_id", "sell_order_id");
+
+COMMENT ON COLUMN "trades"."buy_order_id" IS 'null for trades executed against the mock price';
+COMMENT ON COLUMN "trades"."sell_order_id" IS 'null for trades executed against the mock price';
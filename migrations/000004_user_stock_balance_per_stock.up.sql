@@ -0,0 +1,3 @@
+ALTER TABLE "user_stock_balances" DROP CONSTRAINT IF EXISTS "user_stock_balances_user_id_key";
+
+ALTER TABLE "user_stock_balances" ADD CONSTRAINT "user_stock_balances_user_id_stock_id_key" UNIQUE ("user_id", "stock_id");
@@ -0,0 +1,2 @@
+-- the rounded digits were float noise, there is nothing to restore
+SELECT 1;
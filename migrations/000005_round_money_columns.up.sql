@@ -0,0 +1,5 @@
+-- amounts written as float64 before the decimal money type may carry binary rounding noise,
+-- round them to the default currency precision (-money-precision=4) so they scan exactly
+UPDATE "orders" SET "price" = round("price", 4) WHERE "price" <> round("price", 4);
+UPDATE "trades" SET "price" = round("price", 4) WHERE "price" <> round("price", 4);
+UPDATE "user_wallets" SET "balance" = round("balance", 4) WHERE "balance" <> round("balance", 4);
@@ -0,0 +1,7 @@
+DROP TABLE IF EXISTS "order_events";
+
+DROP INDEX IF EXISTS "orders_status_idx";
+
+ALTER TABLE "orders" DROP COLUMN IF EXISTS "triggered_at";
+ALTER TABLE "orders" DROP COLUMN IF EXISTS "trigger_direction";
+ALTER TABLE "orders" DROP COLUMN IF EXISTS "trigger_price";
@@ -0,0 +1,20 @@
+ALTER TABLE "orders" ADD COLUMN "trigger_price" decimal;
+ALTER TABLE "orders" ADD COLUMN "trigger_direction" integer NOT NULL DEFAULT 0;
+ALTER TABLE "orders" ADD COLUMN "triggered_at" timestamp;
+
+COMMENT ON COLUMN "orders"."price_type" IS '0: market 1: limit 2: stop market 3: stop limit';
+COMMENT ON COLUMN "orders"."status" IS '-1: killed 0: pending 1: filled 2: partially filled 3: untriggered';
+COMMENT ON COLUMN "orders"."trigger_direction" IS '0: none 1: fires when the price rises to the trigger price 2: fires when it falls to it';
+
+CREATE INDEX IF NOT EXISTS "orders_status_idx" ON "orders" ("status");
+
+CREATE TABLE IF NOT EXISTS "order_events" (
+  "id" bigserial PRIMARY KEY,
+  "order_id" bigint NOT NULL REFERENCES "orders" ("id") ON DELETE CASCADE,
+  "type" text NOT NULL,
+  "message" text NOT NULL,
+  "price" decimal,
+  "created_at" timestamp NOT NULL DEFAULT (now())
+);
+
+CREATE INDEX IF NOT EXISTS "order_events_order_id_idx" ON "order_events" ("order_id");
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS "orders_expires_at_idx";
+
+ALTER TABLE "orders" DROP COLUMN IF EXISTS "expires_at";
+ALTER TABLE "orders" DROP COLUMN IF EXISTS "time_in_force";
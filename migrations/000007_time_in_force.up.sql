@@ -0,0 +1,6 @@
+ALTER TABLE "orders" ADD COLUMN "time_in_force" integer NOT NULL DEFAULT 0;
+ALTER TABLE "orders" ADD COLUMN "expires_at" timestamp;
+
+COMMENT ON COLUMN "orders"."time_in_force" IS '0: GTC 1: IOC 2: FOK 3: DAY 4: GTD';
+
+CREATE INDEX IF NOT EXISTS "orders_expires_at_idx" ON "orders" ("expires_at") WHERE "expires_at" IS NOT NULL;
@@ -0,0 +1,3 @@
+ALTER TABLE "orders" ADD COLUMN "protection_price" decimal;
+
+COMMENT ON COLUMN "orders"."protection_price" IS 'worst price a market order may execute at';
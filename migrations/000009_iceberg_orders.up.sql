@@ -0,0 +1,3 @@
+ALTER TABLE "orders" ADD COLUMN "display_quantity" integer NOT NULL DEFAULT 0;
+
+COMMENT ON COLUMN "orders"."display_quantity" IS 'size of the slice an iceberg order shows in the book 0: not an iceberg order';
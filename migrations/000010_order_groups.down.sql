@@ -0,0 +1,8 @@
+DROP INDEX IF EXISTS "orders_group_id_idx";
+
+ALTER TABLE "orders" DROP COLUMN IF EXISTS "leg";
+ALTER TABLE "orders" DROP COLUMN IF EXISTS "group_id";
+
+COMMENT ON COLUMN "orders"."status" IS '-1: killed 0: pending 1: filled 2: partially filled 3: untriggered';
+
+DROP TABLE IF EXISTS "order_groups";
@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS "order_groups" (
+  "id" bigserial PRIMARY KEY,
+  "user_id" bigint NOT NULL,
+  "stock_id" bigint NOT NULL,
+  "type" integer NOT NULL,
+  "created_at" timestamp NOT NULL DEFAULT (now())
+);
+
+ALTER TABLE "orders" ADD COLUMN "group_id" bigint REFERENCES "order_groups" ("id");
+ALTER TABLE "orders" ADD COLUMN "leg" integer NOT NULL DEFAULT 0;
+
+COMMENT ON COLUMN "order_groups"."type" IS '0: one cancels other 1: bracket';
+COMMENT ON COLUMN "orders"."leg" IS '0: none 1: entry 2: take profit 3: stop loss';
+COMMENT ON COLUMN "orders"."status" IS '-1: killed 0: pending 1: filled 2: partially filled 3: untriggered 4: inactive';
+
+CREATE INDEX IF NOT EXISTS "order_groups_user_id_idx" ON "order_groups" ("user_id");
+CREATE INDEX IF NOT EXISTS "orders_group_id_idx" ON "orders" ("group_id") WHERE "group_id" IS NOT NULL;
@@ -0,0 +1,3 @@
+ALTER TABLE "orders" DROP COLUMN IF EXISTS "water_mark";
+ALTER TABLE "orders" DROP COLUMN IF EXISTS "trail_bps";
+ALTER TABLE "orders" DROP COLUMN IF EXISTS "trail_amount";
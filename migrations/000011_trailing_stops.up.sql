@@ -0,0 +1,7 @@
+ALTER TABLE "orders" ADD COLUMN "trail_amount" decimal;
+ALTER TABLE "orders" ADD COLUMN "trail_bps" integer NOT NULL DEFAULT 0;
+ALTER TABLE "orders" ADD COLUMN "water_mark" decimal;
+
+COMMENT ON COLUMN "orders"."trail_amount" IS 'distance a trailing stop keeps its trigger price from the water mark';
+COMMENT ON COLUMN "orders"."trail_bps" IS 'distance a trailing stop keeps its trigger price from the water mark in basis points of it';
+COMMENT ON COLUMN "orders"."water_mark" IS 'highest price since a trailing sell stop was placed, lowest for a buy stop';
@@ -0,0 +1,2 @@
+ALTER TABLE "orders" DROP COLUMN IF EXISTS "reduce_only";
+ALTER TABLE "orders" DROP COLUMN IF EXISTS "post_only";
@@ -0,0 +1,5 @@
+ALTER TABLE "orders" ADD COLUMN "post_only" integer NOT NULL DEFAULT 0;
+ALTER TABLE "orders" ADD COLUMN "reduce_only" boolean NOT NULL DEFAULT false;
+
+COMMENT ON COLUMN "orders"."post_only" IS '0: none 1: reject when crossing 2: reprice when crossing';
+COMMENT ON COLUMN "orders"."reduce_only" IS 'sell order that may not exceed the shares not held by other sell orders';
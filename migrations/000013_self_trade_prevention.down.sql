@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS "order_events_order_id_match_id_idx";
+
+ALTER TABLE "order_events" DROP COLUMN IF EXISTS "match_id";
+ALTER TABLE "order_events" DROP COLUMN IF EXISTS "reason";
+ALTER TABLE "orders" DROP COLUMN IF EXISTS "stp_mode";
+ALTER TABLE "users" DROP COLUMN IF EXISTS "stp_mode";
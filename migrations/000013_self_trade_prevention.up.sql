@@ -0,0 +1,10 @@
+ALTER TABLE "users" ADD COLUMN "stp_mode" integer NOT NULL DEFAULT 0;
+ALTER TABLE "orders" ADD COLUMN "stp_mode" integer NOT NULL DEFAULT 0;
+ALTER TABLE "order_events" ADD COLUMN "reason" text;
+ALTER TABLE "order_events" ADD COLUMN "match_id" text;
+
+COMMENT ON COLUMN "users"."stp_mode" IS 'self-trade prevention of orders that do not set one 0: none 1: cancel newest 2: cancel oldest 3: cancel both 4: decrement and cancel';
+COMMENT ON COLUMN "orders"."stp_mode" IS '0: none 1: cancel newest 2: cancel oldest 3: cancel both 4: decrement and cancel';
+COMMENT ON COLUMN "order_events"."match_id" IS 'settlement log entry the event was written for, makes writing it idempotent';
+
+CREATE UNIQUE INDEX IF NOT EXISTS "order_events_order_id_match_id_idx" ON "order_events" ("order_id", "match_id") WHERE "match_id" IS NOT NULL;
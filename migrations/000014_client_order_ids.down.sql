@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS "orders_user_id_client_order_id_idx";
+
+ALTER TABLE "orders" DROP COLUMN IF EXISTS "client_order_id";
@@ -0,0 +1,5 @@
+ALTER TABLE "orders" ADD COLUMN "client_order_id" text;
+
+COMMENT ON COLUMN "orders"."client_order_id" IS 'id the user gave the order, a retried submission with the same id returns the order instead of placing it again';
+
+CREATE UNIQUE INDEX IF NOT EXISTS "orders_user_id_client_order_id_idx" ON "orders" ("user_id", "client_order_id") WHERE "client_order_id" IS NOT NULL;
@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS "user_events" (
+  "id" bigserial PRIMARY KEY,
+  "user_id" bigint NOT NULL REFERENCES "users" ("id") ON DELETE CASCADE,
+  "type" text NOT NULL,
+  "order_id" bigint NOT NULL REFERENCES "orders" ("id") ON DELETE CASCADE,
+  "data" jsonb NOT NULL,
+  "xact_id" xid8 NOT NULL DEFAULT (pg_current_xact_id()),
+  "created_at" timestamp NOT NULL DEFAULT (now())
+);
+
+COMMENT ON COLUMN "user_events"."xact_id" IS 'transaction that wrote the event, the stream reads events in transaction order so an event committed late is not skipped';
+
+CREATE INDEX IF NOT EXISTS "user_events_user_id_xact_id_id_idx" ON "user_events" ("user_id", "xact_id", "id");
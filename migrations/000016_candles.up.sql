@@ -0,0 +1,18 @@
+CREATE TABLE IF NOT EXISTS "candles" (
+  "stock_id" bigint NOT NULL,
+  "period" text NOT NULL,
+  "start_time" timestamp NOT NULL,
+  "open" decimal NOT NULL,
+  "high" decimal NOT NULL,
+  "low" decimal NOT NULL,
+  "close" decimal NOT NULL,
+  "volume" bigint NOT NULL,
+  "trades" integer NOT NULL,
+  "open_at" timestamp NOT NULL,
+  "close_at" timestamp NOT NULL,
+  PRIMARY KEY ("stock_id", "period", "start_time")
+);
+
+COMMENT ON COLUMN "candles"."period" IS '1m 5m 1h 1d';
+COMMENT ON COLUMN "candles"."open_at" IS 'execution time of the trade that set open, a trade settled late only replaces open when it was executed earlier';
+COMMENT ON COLUMN "candles"."close_at" IS 'execution time of the trade that set close';
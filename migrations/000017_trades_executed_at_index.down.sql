@@ -0,0 +1 @@
+DROP INDEX IF EXISTS "trades_executed_at_idx";
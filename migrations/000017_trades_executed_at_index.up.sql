@@ -0,0 +1 @@
+CREATE INDEX IF NOT EXISTS "trades_executed_at_idx" ON "trades" ("executed_at");
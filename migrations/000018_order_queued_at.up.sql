@@ -0,0 +1,6 @@
+ALTER TABLE "orders" ADD COLUMN "queued_at" timestamp;
+UPDATE "orders" SET "queued_at" = COALESCE("triggered_at", "created_at");
+ALTER TABLE "orders" ALTER COLUMN "queued_at" SET DEFAULT (now());
+ALTER TABLE "orders" ALTER COLUMN "queued_at" SET NOT NULL;
+
+COMMENT ON COLUMN "orders"."queued_at" IS 'when the order last reached the order book, placed, armed, triggered or amended to the back of its queue, the book is rebuilt in this order';